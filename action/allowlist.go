@@ -1,37 +1,97 @@
 package action
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"reflect"
 	"strings"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
-	"gopkg.in/yaml.v3"
 )
 
 // AllowlistYAML is the struct containing two allowlists.
 // One allowlist for merge commits and the other for non-merge commits.
 // A merge commit is defined as a commit with two or more parents.
+//
+// Optionally, commits of a kind (see ClassifyCommit) can have their own
+// allowlist, which is used instead of the merge or non-merge commit allowlist,
+// or added to it for reverts and cherry-picks.
 type AllowlistYAML struct {
 	MergeCommitAllowlist    Allowlist `yaml:"merge_commit_allowlist"`
 	NonMergeCommitAllowlist Allowlist `yaml:"non_merge_commit_allowlist"`
+
+	OctopusMergeCommitAllowlist *Allowlist `yaml:"octopus_merge_commit_allowlist"`
+	RootCommitAllowlist         *Allowlist `yaml:"root_commit_allowlist"`
+	RevertCommitAllowlist       *Allowlist `yaml:"revert_commit_allowlist"`
+	CherryPickCommitAllowlist   *Allowlist `yaml:"cherry_pick_commit_allowlist"`
+	EmptyCommitAllowlist        *Allowlist `yaml:"empty_commit_allowlist"`
+
+	// RepositorySettings are the settings of repositories, e.g. their
+	// enforcement, see GetSettingsForRepo.
+	RepositorySettings []RepositorySettingsEntry `yaml:"repository_settings"`
 }
 
-// Allowlist is the struct containing two lists:
+// AllowlistForKind returns the allowlist used for commits of the kind, and the
+// name of its section. Octopus merges fall back to the merge commit allowlist,
+// and the other kinds to the non-merge commit allowlist, if they do not have an
+// allowlist of their own.
+//
+// Reverts and cherry-picks are detected from the commit message, which the
+// author of the commit chooses, so their allowlists are added to the non-merge
+// commit allowlist instead of replacing it. Claiming to be a revert can then
+// not remove a restriction of the non-merge commit allowlist.
+func (a *AllowlistYAML) AllowlistForKind(kind string) (string, *Allowlist) {
+	sections := map[string]struct {
+		name      string
+		allowlist *Allowlist
+	}{
+		CommitKindOctopusMerge: {"octopus_merge_commit_allowlist", a.OctopusMergeCommitAllowlist},
+		CommitKindRoot:         {"root_commit_allowlist", a.RootCommitAllowlist},
+		CommitKindRevert:       {"revert_commit_allowlist", a.RevertCommitAllowlist},
+		CommitKindCherryPick:   {"cherry_pick_commit_allowlist", a.CherryPickCommitAllowlist},
+		CommitKindEmpty:        {"empty_commit_allowlist", a.EmptyCommitAllowlist},
+	}
+	if s, ok := sections[kind]; ok && s.allowlist != nil {
+		if kind == CommitKindRevert || kind == CommitKindCherryPick {
+			return "non_merge_commit_allowlist and " + s.name, a.NonMergeCommitAllowlist.merge(s.allowlist)
+		}
+		return s.name, s.allowlist
+	}
+
+	if kind == CommitKindMerge || kind == CommitKindOctopusMerge {
+		return "merge_commit_allowlist", &a.MergeCommitAllowlist
+	}
+	return "non_merge_commit_allowlist", &a.NonMergeCommitAllowlist
+}
+
+// Allowlist is the struct containing three lists:
 //
 // 1. (EmailAddresses) Email addresses and the repositories that the
 // email address can be used to bypass signature verification.
 // 2. (ThirdPartyKeys) Third party keys and the repositories that the
 // third party key can be used for signature verification.
+// 3. (PlatformKeys) Platforms (e.g. GitHub) and the repositories that the
+// platform's bundled signing keys can be used for signature verification.
 //
-// If the list of repositories is empty, the email address or third
-// party key can be used for ALL repositories.
+// If the list of repositories is empty, the email address, third
+// party key or platform key can be used for ALL repositories.
 type Allowlist struct {
 	// EmailAddresses is the list of EmailAddressEntries.
 	EmailAddresses []EmailAddressEntry `yaml:"email_addresses"`
 	// ThirdPartyKeys is the list of ThirdPartyKeyEntries.
 	ThirdPartyKeys []ThirdPartyKeyEntry `yaml:"third_party_keys"`
+	// PlatformKeys is the list of PlatformKeyEntries.
+	PlatformKeys []PlatformKeyEntry `yaml:"platform_keys"`
+}
+
+// merge returns an allowlist with the entries of both allowlists.
+func (a Allowlist) merge(other *Allowlist) *Allowlist {
+	return &Allowlist{
+		EmailAddresses: append(append([]EmailAddressEntry{}, a.EmailAddresses...), other.EmailAddresses...),
+		ThirdPartyKeys: append(append([]ThirdPartyKeyEntry{}, a.ThirdPartyKeys...), other.ThirdPartyKeys...),
+		PlatformKeys:   append(append([]PlatformKeyEntry{}, a.PlatformKeys...), other.PlatformKeys...),
+	}
 }
 
 // EmailAddressEntry is a struct containing an email address and a list of
@@ -47,16 +107,41 @@ type EmailAddressEntry struct {
 // repositories for which the third party key can be used for signature verification.
 // If the list of repositories is empty, the third party key can be used for signature
 // verification on all repositories.
+//
+// The key is either the ASCII-armored public key (Key) or the full hex fingerprint of
+// the primary key (Fingerprint), which is resolved through the configured KeySource.
+// Subkey optionally pins the fingerprint of the only (sub)key allowed to sign, for
+// either form. EmailAddress is the email address of a user ID on the key, used to look
+// the key up in a Web Key Directory, so it can only be set with Fingerprint.
 type ThirdPartyKeyEntry struct {
 	Key          string   `yaml:"key"`
+	Fingerprint  string   `yaml:"fingerprint"`
+	Subkey       string   `yaml:"subkey"`
+	EmailAddress string   `yaml:"email_address"`
 	Repositories []string `yaml:"repositories"`
 }
 
+// PlatformKeyEntry is a struct containing a platform name and a list of
+// repositories for which the platform's signing keys can be used for signature
+// verification. Platform keys only apply to the kind of commit (merge or
+// non-merge) of the allowlist the entry is listed in, e.g. listing "github"
+// in the non-merge commit allowlist trusts squash merges and web edits.
+// Non-merge commits are only trusted if their committer is the platform
+// identity, one of the CommitterEmails of the PlatformKeySource or of the
+// entry. Merge commits are not restricted by committer.
+// If the list of repositories is empty, the platform keys can be used for
+// signature verification on all repositories.
+type PlatformKeyEntry struct {
+	Platform        string   `yaml:"platform"`
+	CommitterEmails []string `yaml:"committer_emails"`
+	Repositories    []string `yaml:"repositories"`
+}
+
 // LoadAllowlistYAML verifies and parses the allowlist configuration from the allowlist
-// file path. If filePath is empty, returns an empty AllowlistYAML.
+// file path. If filePath is empty, returns an empty AllowlistYAML. See
+// ParseAllowlistYAML.
 func LoadAllowlistYAML(filePath string) (*AllowlistYAML, error) {
 	if filePath == "" {
-		log.Println("No allowlist configured")
 		return &AllowlistYAML{}, nil
 	}
 	yfile, err := ioutil.ReadFile(filePath)
@@ -64,15 +149,183 @@ func LoadAllowlistYAML(filePath string) (*AllowlistYAML, error) {
 		return nil, fmt.Errorf(`failed to read allowlist yaml configuration file at '%s': %w`, filePath, err)
 	}
 
-	var allowlistYAML *AllowlistYAML
-	err = yaml.Unmarshal(yfile, &allowlistYAML)
+	return ParseAllowlistYAML(filePath, yfile)
+}
+
+// ParseAllowlistYAML parses the allowlist configuration read from the file
+// name. Fields that are not part of the allowlist schema are rejected with an
+// AllowlistErrors.
+func ParseAllowlistYAML(name string, data []byte) (*AllowlistYAML, error) {
+	node, err := parseYAMLNode(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal allowlist yaml configuration file: %w", err)
 	}
+	if errs := checkAllowlistNode(name, node, reflect.TypeOf(AllowlistYAML{}), false); len(errs) > 0 {
+		return nil, errs
+	}
+
+	allowlistYAML := &AllowlistYAML{}
+	if node != nil {
+		if err := node.Decode(allowlistYAML); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal allowlist yaml configuration file: %w", err)
+		}
+	}
 
 	return allowlistYAML, nil
 }
 
+// ErrCodeInvalidAllowlistSignature is the OutcomeError code of an allowlist
+// that is required to be signed, but is not signed by an admin key.
+const ErrCodeInvalidAllowlistSignature = "INVALID_ALLOWLIST_SIGNATURE"
+
+// AllowlistSignatureError is returned if the signature of an allowlist is
+// missing or invalid.
+type AllowlistSignatureError string
+
+func (e AllowlistSignatureError) Error() string {
+	return fmt.Sprintf("invalid allowlist signature: %s", string(e))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e AllowlistSignatureError) Code() string {
+	return ErrCodeInvalidAllowlistSignature
+}
+
+// loadSignedAllowlistYAML reads the allowlist configuration from filePath and
+// verifies its detached ASCII-armored signature at "<filePath>.asc" with the
+// admin keys in keyRing before parsing it, and logs the signer to logger. See
+// ParseAllowlistYAML.
+func loadSignedAllowlistYAML(filePath string, keyRing openpgp.EntityList, policy CryptoPolicy, logger Logger) (*AllowlistYAML, error) {
+	yfile, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read allowlist yaml configuration file at '%s': %w`, filePath, err)
+	}
+	signature, err := ioutil.ReadFile(filePath + ".asc")
+	if err != nil {
+		return nil, AllowlistSignatureError(fmt.Sprintf("failed to read signature file: %v", err))
+	}
+	return parseSignedAllowlistYAML(filePath, yfile, signature, keyRing, policy, logger)
+}
+
+// parseSignedAllowlistYAML verifies the signature of the allowlist before
+// parsing it.
+func parseSignedAllowlistYAML(name string, data, signature []byte, keyRing openpgp.EntityList, policy CryptoPolicy, logger Logger) (*AllowlistYAML, error) {
+	signer, err := VerifyAllowlistSignature(data, string(signature), keyRing, policy)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Allowlist is signed by admin key %s\n\n", formatFingerprint(signer.PrimaryKey.Fingerprint))
+
+	return ParseAllowlistYAML(name, data)
+}
+
+// AllowlistSource is an allowlist file in a commit of a git repository.
+type AllowlistSource struct {
+	// RepoPath is the path to the git repository.
+	RepoPath string
+	// Ref is the commit reference to read the file from, e.g. the base branch
+	// "refs/remotes/origin/main".
+	Ref string
+	// Path is the path of the file within the commit.
+	Path string
+}
+
+// ParseAllowlistSource parses an allowlist source of the form
+// "<repo-path>@<ref>:<path>".
+func ParseAllowlistSource(s string) (*AllowlistSource, error) {
+	// Repository paths may contain '@' (e.g. Jenkins workspaces), refs may not
+	// contain ':'.
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return nil, fmt.Errorf("invalid allowlist source %q: expected <repo-path>@<ref>:<path>", s)
+	}
+	colon := strings.Index(s[at+1:], ":")
+	if colon < 0 {
+		return nil, fmt.Errorf("invalid allowlist source %q: expected <repo-path>@<ref>:<path>", s)
+	}
+
+	src := &AllowlistSource{RepoPath: s[:at], Ref: s[at+1 : at+1+colon], Path: strings.TrimPrefix(s[at+1+colon+1:], "/")}
+	if src.RepoPath == "" || src.Ref == "" || src.Path == "" {
+		return nil, fmt.Errorf("invalid allowlist source %q: expected <repo-path>@<ref>:<path>", s)
+	}
+	return src, nil
+}
+
+func (s *AllowlistSource) String() string {
+	return fmt.Sprintf("%s@%s:%s", s.RepoPath, s.Ref, s.Path)
+}
+
+// load reads the allowlist configuration from the commit that Ref resolves to
+// in the repository opened by open, and returns it with the hash of the
+// commit. If keyRing is not nil, the detached signature at "<Path>.asc" in the
+// same commit is verified with the admin keys in keyRing first. Progress is
+// logged to logger.
+func (s *AllowlistSource) load(open RepositoryOpener, keyRing openpgp.EntityList, policy CryptoPolicy, logger Logger) (*AllowlistYAML, string, error) {
+	repo, err := open(s.RepoPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read allowlist from %s: failed to open repository: %w", s, err)
+	}
+	yfile, commitHash, err := readFileAtRef(repo, s.Ref, s.Path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read allowlist from %s: %w", s, err)
+	}
+	name := fmt.Sprintf("%s:%s", commitHash, s.Path)
+	logger.Printf("Reading allowlist from %s at commit %s\n\n", s, commitHash)
+
+	if keyRing == nil {
+		allowlistYAML, err := ParseAllowlistYAML(name, yfile)
+		return allowlistYAML, commitHash.String(), err
+	}
+
+	signature, _, err := readFileAtRef(repo, commitHash.String(), s.Path+".asc")
+	if err != nil {
+		return nil, "", AllowlistSignatureError(fmt.Sprintf("failed to read signature file: %v", err))
+	}
+	allowlistYAML, err := parseSignedAllowlistYAML(name, yfile, signature, keyRing, policy, logger)
+	return allowlistYAML, commitHash.String(), err
+}
+
+// VerifyAllowlistSignature checks that the ASCII-armored detached signature of
+// the allowlist data was made by one of the admin keys in keyRing. Returns the
+// signer.
+func VerifyAllowlistSignature(data []byte, armoredSignature string, keyRing openpgp.EntityList, policy CryptoPolicy) (*openpgp.Entity, error) {
+	signer, _, err := checkArmoredDetachedSignature(keyRing, string(data), armoredSignature, policy)
+	if err != nil {
+		return nil, AllowlistSignatureError(err.Error())
+	}
+	return signer, nil
+}
+
+// LoadAllowlistSigningKeys reads the admin keys that sign the allowlist from
+// the keyring file. Every key in the keyring must be pinned by its primary key
+// fingerprint, and fingerprints must not be empty.
+func LoadAllowlistSigningKeys(filePath string, fingerprints []string) (openpgp.EntityList, error) {
+	return loadPinnedKeyRing("allowlist signing", filePath, fingerprints)
+}
+
+// loadPinnedKeyRing reads the keyring file, and checks that it is not empty
+// and that every key in it is pinned by its primary key fingerprint. name
+// describes the keyring in errors.
+func loadPinnedKeyRing(name, filePath string, fingerprints []string) (openpgp.EntityList, error) {
+	if len(fingerprints) == 0 {
+		return nil, fmt.Errorf("%s keyring at '%s' must be pinned by fingerprints", name, filePath)
+	}
+	keyRing, err := LoadKeyRingFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyRing) == 0 {
+		return nil, fmt.Errorf("%s keyring at '%s' contains no keys", name, filePath)
+	}
+	for _, e := range keyRing {
+		fp := formatFingerprint(e.PrimaryKey.Fingerprint)
+		if !containsFingerprint(fp, fingerprints) {
+			return nil, fmt.Errorf("%s keyring at '%s' contains unexpected key with fingerprint %s", name, filePath, fp)
+		}
+	}
+	return keyRing, nil
+}
+
 // RepoAllowlist is the struct containing the validated email addresses
 // and third party keys from the Allowlist struct for the specified repository.
 type RepoAllowlist struct {
@@ -81,22 +334,54 @@ type RepoAllowlist struct {
 	EmailAddresses []string
 	// ThirdPartyKeys is an array of keyrings used to validate a PGP
 	// signature for the specified repository.
-	ThirdPartyKeys []openpgp.EntityList
+	ThirdPartyKeys []ThirdPartyKeyRing
+	// PlatformKeys is the list of platforms whose signing keys can be used
+	// to validate a PGP signature for the specified repository.
+	PlatformKeys []string
+	// PlatformCommitterEmails is the list of committer email addresses of
+	// each platform in PlatformKeys, whose non-merge commits are trusted.
+	PlatformCommitterEmails map[string][]string
+}
+
+// ThirdPartyKeyRing is a keyring used to validate a PGP signature, optionally
+// restricted to a single signing key.
+type ThirdPartyKeyRing struct {
+	// KeyRing contains the third party key.
+	KeyRing openpgp.EntityList
+	// SigningKeyFingerprint, if set, is the fingerprint of the only key in
+	// KeyRing (primary key or subkey) that may produce the signature.
+	SigningKeyFingerprint string
 }
 
-// GetAllowlistForRepo parses the allowlist for valid email addresses and
-// third party keys from the Allowlist struct for the specified repository.
+// GetAllowlistForRepo parses the allowlist for valid email addresses,
+// third party keys and platforms from the Allowlist struct for the specified repository.
 // Returns any errors encountered while parsing.
+//
+// Deprecated: Use GetAllowlistForRepoWithKeySource, which also resolves third
+// party keys pinned by fingerprint.
 func GetAllowlistForRepo(al *Allowlist, repo string) (*RepoAllowlist, []error) {
+	return GetAllowlistForRepoWithKeySource(context.Background(), al, repo, nil)
+}
+
+// GetAllowlistForRepoWithKeySource parses the allowlist for valid email addresses,
+// third party keys and platforms from the Allowlist struct for the specified repository.
+// Third party keys pinned by fingerprint are resolved through keySource, which
+// may be nil if no key source is configured.
+// Returns any errors encountered while parsing.
+func GetAllowlistForRepoWithKeySource(ctx context.Context, al *Allowlist, repo string, keySource KeySource) (*RepoAllowlist, []error) {
 	emails, eaErrs := getValidEmailAddressesForRepo(al.EmailAddresses, repo)
-	keyRings, tpkErrs := getValidThirdPartyKeysForRepo(al.ThirdPartyKeys, repo)
+	keyRings, tpkErrs := getValidThirdPartyKeysForRepo(ctx, al.ThirdPartyKeys, repo, keySource)
+	platforms, committerEmails, pkErrs := getValidPlatformsForRepo(al.PlatformKeys, repo)
 
 	repoAllowlist := &RepoAllowlist{
-		EmailAddresses: emails,
-		ThirdPartyKeys: keyRings,
+		EmailAddresses:          emails,
+		ThirdPartyKeys:          keyRings,
+		PlatformKeys:            platforms,
+		PlatformCommitterEmails: committerEmails,
 	}
 
-	return repoAllowlist, append(eaErrs, tpkErrs...)
+	errs := append(eaErrs, tpkErrs...)
+	return repoAllowlist, append(errs, pkErrs...)
 }
 
 // getValidEmailAddressesForRepo parses an array of EmailAddressEntries and returns a list
@@ -119,25 +404,148 @@ func getValidEmailAddressesForRepo(entries []EmailAddressEntry, repo string) ([]
 	return emails, errs
 }
 
-// getValidEmailAddressesForRepo parses an array of ThirdPartyKeyEntries and returns a list
+// getValidThirdPartyKeysForRepo parses an array of ThirdPartyKeyEntries and returns a list
 // of keyRings used for PGP signature validation.
 // Returns any errors encountered while parsing.
-func getValidThirdPartyKeysForRepo(entries []ThirdPartyKeyEntry, repo string) ([]openpgp.EntityList, []error) {
-	keyRings := []openpgp.EntityList{}
+func getValidThirdPartyKeysForRepo(ctx context.Context, entries []ThirdPartyKeyEntry, repo string, keySource KeySource) ([]ThirdPartyKeyRing, []error) {
+	keyRings := []ThirdPartyKeyRing{}
 	errs := []error{}
 	for _, e := range entries {
-		keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(e.Key))
-		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to parse third party key: %s\n with error: %v", e.Key, err))
-		} else {
-			if len(e.Repositories) == 0 || containsRepo(repo, e.Repositories) {
-				keyRings = append(keyRings, keyRing)
+		if e.Fingerprint != "" {
+			keyRing, err := getThirdPartyKeyByFingerprint(ctx, e, repo, keySource)
+			if err != nil {
+				errs = append(errs, err)
+			} else if keyRing != nil {
+				keyRings = append(keyRings, *keyRing)
 			}
+			continue
+		}
+
+		keyRing, err := getInlineThirdPartyKey(e, repo)
+		if err != nil {
+			errs = append(errs, err)
+		} else if keyRing != nil {
+			keyRings = append(keyRings, *keyRing)
 		}
 	}
 	return keyRings, errs
 }
 
+// getInlineThirdPartyKey parses the ASCII-armored key of a ThirdPartyKeyEntry
+// and applies its Subkey pin. Returns a nil keyRing if the entry does not apply
+// to the specified repository.
+func getInlineThirdPartyKey(e ThirdPartyKeyEntry, repo string) (*ThirdPartyKeyRing, error) {
+	keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(e.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse third party key: %s\n with error: %v", e.Key, err)
+	}
+	if len(keyRing) == 0 {
+		return nil, fmt.Errorf("failed to parse third party key: %s\n with error: no keys found", e.Key)
+	}
+	fp := formatFingerprint(keyRing[0].PrimaryKey.Fingerprint)
+	// The email address is only used to look up keys pinned by fingerprint.
+	if e.EmailAddress != "" {
+		return nil, fmt.Errorf("third party key %s must not set email_address with an inline key", fp)
+	}
+	if e.Subkey != "" {
+		if err := Fingerprint(e.Subkey); err != nil {
+			return nil, err
+		}
+		found := false
+		for _, entity := range keyRing {
+			found = found || findKeyByFingerprint(entity, e.Subkey) != nil
+		}
+		if !found {
+			return nil, fmt.Errorf("third party key %s has no subkey %s", fp, e.Subkey)
+		}
+	}
+
+	if len(e.Repositories) > 0 && !containsRepo(repo, e.Repositories) {
+		return nil, nil
+	}
+	return &ThirdPartyKeyRing{
+		KeyRing:               keyRing,
+		SigningKeyFingerprint: normalizeFingerprint(e.Subkey),
+	}, nil
+}
+
+// getThirdPartyKeyByFingerprint resolves a ThirdPartyKeyEntry pinned by fingerprint
+// through the key source. Returns a nil keyRing if the entry does not apply to the
+// specified repository.
+func getThirdPartyKeyByFingerprint(ctx context.Context, e ThirdPartyKeyEntry, repo string, keySource KeySource) (*ThirdPartyKeyRing, error) {
+	if e.Key != "" {
+		return nil, fmt.Errorf("third party key %s must set only one of key or fingerprint", e.Fingerprint)
+	}
+	if err := Fingerprint(e.Fingerprint); err != nil {
+		return nil, err
+	}
+	if e.Subkey != "" {
+		if err := Fingerprint(e.Subkey); err != nil {
+			return nil, err
+		}
+	}
+	if e.EmailAddress != "" {
+		if err := Email(e.EmailAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(e.Repositories) > 0 && !containsRepo(repo, e.Repositories) {
+		return nil, nil
+	}
+
+	if keySource == nil {
+		return nil, fmt.Errorf("failed to get third party key %s: no key source configured", e.Fingerprint)
+	}
+
+	entity, err := keySource.KeyByFingerprint(ctx, e.Fingerprint, e.EmailAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get third party key %s: %w", e.Fingerprint, err)
+	}
+
+	if e.Subkey != "" && findKeyByFingerprint(entity, e.Subkey) == nil {
+		return nil, fmt.Errorf("third party key %s has no subkey %s", e.Fingerprint, e.Subkey)
+	}
+
+	return &ThirdPartyKeyRing{
+		KeyRing:               openpgp.EntityList{entity},
+		SigningKeyFingerprint: normalizeFingerprint(e.Subkey),
+	}, nil
+}
+
+// getValidPlatformsForRepo parses an array of PlatformKeyEntries and returns a
+// list of known platforms whose keys can be used for PGP signature validation,
+// and the committer email addresses of each platform.
+// Returns any errors encountered while parsing.
+func getValidPlatformsForRepo(entries []PlatformKeyEntry, repo string) ([]string, map[string][]string, []error) {
+	platforms := []string{}
+	committerEmails := map[string][]string{}
+	errs := []error{}
+	for _, e := range entries {
+		source, ok := getPlatformKeySource(e.Platform)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown platform: %q", e.Platform))
+			continue
+		}
+		emailErr := false
+		for _, email := range e.CommitterEmails {
+			if err := Email(email); err != nil {
+				errs = append(errs, err)
+				emailErr = true
+			}
+		}
+		if emailErr || (len(e.Repositories) > 0 && !containsRepo(repo, e.Repositories)) {
+			continue
+		}
+		if _, ok := committerEmails[e.Platform]; !ok {
+			platforms = append(platforms, e.Platform)
+			committerEmails[e.Platform] = append([]string{}, source.CommitterEmails...)
+		}
+		committerEmails[e.Platform] = append(committerEmails[e.Platform], e.CommitterEmails...)
+	}
+	return platforms, committerEmails, errs
+}
+
 // containsRepo checks if the specified repository is within an array
 // of repositories.
 func containsRepo(repo string, repos []string) bool {
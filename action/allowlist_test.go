@@ -0,0 +1,258 @@
+package action
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// signTestAllowlist writes the allowlist and its detached signature by signer.
+func signTestAllowlist(t *testing.T, path, allowlist string, signer *openpgp.Entity) {
+	t.Helper()
+
+	writeTestFile(t, path, allowlist)
+	sig := &strings.Builder{}
+	if err := openpgp.ArmoredDetachSign(sig, signer, strings.NewReader(allowlist), nil); err != nil {
+		t.Fatalf("failed to sign allowlist: %v", err)
+	}
+	writeTestFile(t, path+".asc", sig.String())
+}
+
+func TestRunSignedAllowlist(t *testing.T) {
+	admin := newTestEntity(t, "Admin", "admin@example.com")
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+	const allowlist = "non_merge_commit_allowlist:\n  email_addresses:\n    - email_address: jane@doe.com\n"
+
+	f := newFakeAPIServer(t, false)
+	r := newTestRepo(t)
+	r.commit(testCommitOptions{})
+
+	dir := t.TempDir()
+	keyRingPath := filepath.Join(dir, "admins.asc")
+	writeTestFile(t, keyRingPath, armorPublicKey(t, admin))
+
+	tests := []struct {
+		name         string
+		signer       *openpgp.Entity
+		tamper       bool
+		fingerprints []string
+		expectedCode string
+		expectedErr  string
+	}{
+		{
+			name:         "signed_by_pinned_admin",
+			signer:       admin,
+			fingerprints: []string{formatFingerprint(admin.PrimaryKey.Fingerprint)},
+		},
+		{
+			name:        "not_pinned",
+			signer:      admin,
+			expectedErr: "missing config field: AllowlistSigningKeyFingerprints",
+		},
+		{
+			name:         "unsigned",
+			fingerprints: []string{formatFingerprint(admin.PrimaryKey.Fingerprint)},
+			expectedCode: ErrCodeInvalidAllowlistSignature,
+		},
+		{
+			name:         "signed_by_other_key",
+			signer:       mallory,
+			fingerprints: []string{formatFingerprint(admin.PrimaryKey.Fingerprint)},
+			expectedCode: ErrCodeInvalidAllowlistSignature,
+			expectedErr:  "invalid allowlist signature: openpgp: signature made by unknown entity",
+		},
+		{
+			name:         "modified_after_signing",
+			signer:       admin,
+			tamper:       true,
+			fingerprints: []string{formatFingerprint(admin.PrimaryKey.Fingerprint)},
+			expectedCode: ErrCodeInvalidAllowlistSignature,
+		},
+		{
+			name:         "unpinned_admin",
+			signer:       admin,
+			fingerprints: []string{formatFingerprint(mallory.PrimaryKey.Fingerprint)},
+			expectedErr:  "allowlist signing keyring at '" + keyRingPath + "' contains unexpected key with fingerprint " + formatFingerprint(admin.PrimaryKey.Fingerprint),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "allowlist.yaml")
+			if tt.signer != nil {
+				signTestAllowlist(t, path, allowlist, tt.signer)
+			} else {
+				writeTestFile(t, path, allowlist)
+			}
+			if tt.tamper {
+				writeTestFile(t, path, allowlist+"    - email_address: mallory@example.com\n")
+			}
+
+			cfg := newTestRunConfig(r, f)
+			cfg.AllowlistConfigFilePath = path
+			cfg.AllowlistSigningKeyRingFilePath = keyRingPath
+			cfg.AllowlistSigningKeyFingerprints = tt.fingerprints
+
+			o := Run(context.Background(), cfg)
+			if tt.expectedCode == "" && tt.expectedErr == "" {
+				if o.Result != PASS || o.VerificationDetails == nil || o.VerificationDetails.VerifiedBy != "EMAIL_ADDRESS" {
+					t.Errorf("expected PASS by allowlist email address, got %s: %+v", o.Result, o.Errors)
+				}
+				return
+			}
+			if o.Result != FAIL || len(o.Errors) != 1 {
+				t.Fatalf("expected FAIL with 1 error, got %s: %+v", o.Result, o.Errors)
+			}
+			if o.Errors[0].Code != tt.expectedCode {
+				t.Errorf("expected code %q, got %q", tt.expectedCode, o.Errors[0].Code)
+			}
+			if tt.expectedErr != "" && o.Errors[0].Desc != tt.expectedErr {
+				t.Errorf("expected error %q, got %q", tt.expectedErr, o.Errors[0].Desc)
+			}
+		})
+	}
+}
+
+func TestParseAllowlistSource(t *testing.T) {
+	tests := []struct {
+		source      string
+		expected    AllowlistSource
+		expectedErr string
+	}{
+		{
+			source:   ".@refs/remotes/origin/main:allowlist.yaml",
+			expected: AllowlistSource{RepoPath: ".", Ref: "refs/remotes/origin/main", Path: "allowlist.yaml"},
+		},
+		{
+			source:   "/var/lib/jenkins/workspace/repo@2@main:/config/allowlist.yaml",
+			expected: AllowlistSource{RepoPath: "/var/lib/jenkins/workspace/repo@2", Ref: "main", Path: "config/allowlist.yaml"},
+		},
+		{
+			source:      "allowlist.yaml",
+			expectedErr: `invalid allowlist source "allowlist.yaml": expected <repo-path>@<ref>:<path>`,
+		},
+		{
+			source:      ".@main",
+			expectedErr: `invalid allowlist source ".@main": expected <repo-path>@<ref>:<path>`,
+		},
+		{
+			source:      ".@:allowlist.yaml",
+			expectedErr: `invalid allowlist source ".@:allowlist.yaml": expected <repo-path>@<ref>:<path>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			src, err := ParseAllowlistSource(tt.source)
+			assertEqualErr(t, tt.expectedErr, err)
+			if err == nil && *src != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *src)
+			}
+		})
+	}
+}
+
+func TestRunAllowlistConfigSource(t *testing.T) {
+	admin := newTestEntity(t, "Admin", "admin@example.com")
+	const trustedAllowlist = "non_merge_commit_allowlist:\n  email_addresses:\n    - email_address: bot@example.com\n"
+	const prAllowlist = trustedAllowlist + "    - email_address: jane@doe.com\n"
+
+	sig := &strings.Builder{}
+	if err := openpgp.ArmoredDetachSign(sig, admin, strings.NewReader(trustedAllowlist), nil); err != nil {
+		t.Fatalf("failed to sign allowlist: %v", err)
+	}
+
+	f := newFakeAPIServer(t, false)
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{Files: map[string]string{"allowlist.yaml": trustedAllowlist, "allowlist.yaml.asc": sig.String()}})
+	if err := r.Storer.SetReference(plumbing.NewHashReference("refs/heads/trusted", base)); err != nil {
+		t.Fatalf("failed to set reference: %v", err)
+	}
+	// The pull request grants its author a bypass, in the commit and in the
+	// working tree.
+	head := r.commit(testCommitOptions{Parents: []plumbing.Hash{base}, Files: map[string]string{"allowlist.yaml": prAllowlist}})
+	writeTestFile(t, filepath.Join(r.Path, "allowlist.yaml"), prAllowlist)
+
+	keyRingPath := filepath.Join(t.TempDir(), "admins.asc")
+	writeTestFile(t, keyRingPath, armorPublicKey(t, admin))
+
+	tests := []struct {
+		name            string
+		source          string
+		keyRingPath     string
+		expectedResult  string
+		expectedCommit  string
+		expectedErrCode string
+	}{
+		{
+			name:           "trusted_ref",
+			source:         r.Path + "@refs/heads/trusted:allowlist.yaml",
+			expectedResult: FAIL,
+			expectedCommit: base.String(),
+		},
+		{
+			name:           "trusted_ref_signed",
+			source:         r.Path + "@refs/heads/trusted:allowlist.yaml",
+			keyRingPath:    keyRingPath,
+			expectedResult: FAIL,
+			expectedCommit: base.String(),
+		},
+		{
+			name:           "pull_request_ref",
+			source:         r.Path + "@" + head.String() + ":allowlist.yaml",
+			expectedResult: PASS,
+			expectedCommit: head.String(),
+		},
+		{
+			name:            "pull_request_ref_unsigned",
+			source:          r.Path + "@" + head.String() + ":allowlist.yaml",
+			keyRingPath:     keyRingPath,
+			expectedResult:  FAIL,
+			expectedErrCode: ErrCodeInvalidAllowlistSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestRunConfig(r, f)
+			cfg.AllowlistConfigSource = tt.source
+			if tt.keyRingPath != "" {
+				cfg.AllowlistSigningKeyRingFilePath = tt.keyRingPath
+				cfg.AllowlistSigningKeyFingerprints = []string{formatFingerprint(admin.PrimaryKey.Fingerprint)}
+			}
+
+			o := Run(context.Background(), cfg)
+			if o.Result != tt.expectedResult || o.AllowlistCommit != tt.expectedCommit {
+				t.Errorf("expected %s with allowlist commit %q, got %s with %q: %+v", tt.expectedResult, tt.expectedCommit, o.Result, o.AllowlistCommit, o.Errors)
+			}
+			if tt.expectedErrCode != "" && (len(o.Errors) != 1 || o.Errors[0].Code != tt.expectedErrCode) {
+				t.Errorf("expected error with code %s, got %+v", tt.expectedErrCode, o.Errors)
+			}
+		})
+	}
+}
+
+func TestGetValidPlatformsForRepo(t *testing.T) {
+	platforms, committerEmails, errs := getValidPlatformsForRepo([]PlatformKeyEntry{
+		{Platform: PlatformGitHub},
+		{Platform: PlatformGitLab, CommitterEmails: []string{"gitlab@example.com"}},
+		{Platform: PlatformGitLab, CommitterEmails: []string{"other@example.com"}, Repositories: []string{"byndid/other"}},
+		{Platform: PlatformGitHub, CommitterEmails: []string{"not-an-email"}},
+	}, "byndid/auth-commit-sig")
+
+	if !reflect.DeepEqual(platforms, []string{PlatformGitHub, PlatformGitLab}) {
+		t.Errorf("expected platforms github and gitlab, got %v", platforms)
+	}
+	expected := map[string][]string{
+		PlatformGitHub: {"noreply@github.com"},
+		PlatformGitLab: {"gitlab@example.com"},
+	}
+	if !reflect.DeepEqual(committerEmails, expected) {
+		t.Errorf("expected committer emails %v, got %v", expected, committerEmails)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected 1 error for the invalid committer email, got %v", errs)
+	}
+}
@@ -1,13 +1,17 @@
 package action
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 )
 
 var (
@@ -17,12 +21,26 @@ var (
 	userAgent = "byndid/auth-commit-sig:" + version
 )
 
+// DefaultBatchSize is the maximum number of requests sent to the batch
+// authorization endpoint at once, if APIClient.BatchSize is not set.
+const DefaultBatchSize = 100
+
 // APIClient wraps an http.Client to provide access to the Beyond Identity Key
 // Management API.
 type APIClient struct {
 	HTTPClient *http.Client
-	APIToken   string
-	APIBaseURL string
+	// APIToken is used as a static Bearer token if TokenSource is not set.
+	APIToken string
+	// TokenSource provides the Bearer token, e.g. from a rotated file or an
+	// OAuth2 token endpoint. Overrides APIToken.
+	TokenSource TokenSource
+	APIBaseURL  string
+	// BatchSize is the maximum number of requests sent to the batch
+	// authorization endpoint at once. Defaults to DefaultBatchSize.
+	BatchSize int
+	// Logger logs the fallback to individual requests. Defaults to the
+	// standard logger.
+	Logger Logger
 }
 
 // BadResponseError is returned when an unexpected response is received from the
@@ -41,7 +59,7 @@ func (err BadResponseError) Error() string {
 		err.RequestMethod, err.RequestURL, err.StatusCode, http.StatusText(err.StatusCode), string(err.Body), err.Cause)
 }
 
-// Authorization is returned by a successful GetAuthorization API call.
+// Authorization is returned by a successful AuthorizeKey API call.
 type Authorization struct {
 	Authorized bool   `json:"authorized"`
 	Message    string `json:"message"`
@@ -68,16 +86,26 @@ type GPGKey struct {
 
 // GetAuthorization calls the Beyond Identity Key Management API to authorize a
 // GPG key for git commit signing.
+//
+// Deprecated: Use AuthorizeKey, which also sends the key fingerprint.
 func (c APIClient) GetAuthorization(ctx context.Context, keyID, committerEmail string) (*Authorization, error) {
-	u, err := url.Parse(c.APIBaseURL)
+	return c.AuthorizeKey(ctx, keyID, "", committerEmail)
+}
+
+// AuthorizeKey calls the Beyond Identity Key Management API to authorize a
+// GPG key for git commit signing. The full key fingerprint is sent along with the
+// key ID, if known.
+func (c APIClient) AuthorizeKey(ctx context.Context, keyID, fingerprint, committerEmail string) (*Authorization, error) {
+	u, err := c.endpoint("v0", "gpg", "key", "authorization", "git-commit-signing")
 	if err != nil {
-		return nil, fmt.Errorf("invalid base url: %w", err)
+		return nil, err
 	}
 
-	u.Path = path.Join(u.Path, "v0", "gpg", "key", "authorization", "git-commit-signing")
-
 	q := u.Query()
 	q.Set("key_id", keyID)
+	if fingerprint != "" {
+		q.Set("key_fingerprint", fingerprint)
+	}
 	q.Set("committer_email", committerEmail)
 	u.RawQuery = q.Encode()
 
@@ -86,23 +114,250 @@ func (c APIClient) GetAuthorization(ctx context.Context, keyID, committerEmail s
 		return nil, fmt.Errorf("failed to build request: %w", err)
 	}
 
+	a := Authorization{}
+	err = c.do(req, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// LookupIdentity calls the Beyond Identity directory to look up whether the
+// email address belongs to a known, authorized user.
+func (c APIClient) LookupIdentity(ctx context.Context, emailAddress string) (*Identity, error) {
+	u, err := c.endpoint("v0", "directory", "identity")
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("email", emailAddress)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	identity := Identity{}
+	err = c.do(req, &identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// AuthorizationRequest is a single request to authorize a GPG key for git
+// commit signing by a committer, as sent to the batch authorization endpoint.
+type AuthorizationRequest struct {
+	KeyID          string `json:"key_id"`
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
+	CommitterEmail string `json:"committer_email"`
+}
+
+type batchAuthorizationRequest struct {
+	Requests []AuthorizationRequest `json:"requests"`
+}
+
+type batchAuthorizationResponse struct {
+	Authorizations []batchAuthorization `json:"authorizations"`
+}
+
+// batchAuthorization is an authorization returned by the batch authorization
+// endpoint, with the request it answers echoed back, so that it does not rely
+// on the order of the response.
+type batchAuthorization struct {
+	AuthorizationRequest
+	Authorization
+}
+
+// batchMismatchError is returned when the authorizations returned by the batch
+// authorization endpoint do not answer exactly the requests sent.
+type batchMismatchError string
+
+func (e batchMismatchError) Error() string {
+	return fmt.Sprintf("batch authorization response does not match the requests: %s", string(e))
+}
+
+// GetAuthorizations authorizes many GPG keys for git commit signing. Duplicate
+// requests are only sent once, and the remaining requests are sent to the batch
+// authorization endpoint in chunks of BatchSize. If the API does not support the
+// batch endpoint, or its response does not match the requests, falls back to
+// calling AuthorizeKey for each request.
+// Returns the authorizations in the order of the requests.
+func (c APIClient) GetAuthorizations(ctx context.Context, requests []AuthorizationRequest) ([]*Authorization, error) {
+	unique := []AuthorizationRequest{}
+	indexes := map[AuthorizationRequest]int{}
+	for _, r := range requests {
+		if _, ok := indexes[r]; !ok {
+			indexes[r] = len(unique)
+			unique = append(unique, r)
+		}
+	}
+
+	batchSize := c.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	authorizations := make([]*Authorization, 0, len(unique))
+	batchSupported := true
+	for start := 0; start < len(unique); start += batchSize {
+		end := start + batchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		chunk := unique[start:end]
+
+		if batchSupported {
+			as, err := c.getAuthorizationsBatch(ctx, chunk)
+			if err == nil {
+				authorizations = append(authorizations, as...)
+				continue
+			}
+			var mismatchErr batchMismatchError
+			switch {
+			case errors.As(err, &mismatchErr):
+				c.logger().Printf("Ignoring batch authorization response, falling back to individual requests: %v\n\n", err)
+			case isBatchUnsupported(err):
+				c.logger().Printf("Batch authorization is not supported by the API, falling back to individual requests\n\n")
+			default:
+				return nil, err
+			}
+			batchSupported = false
+		}
+
+		for _, r := range chunk {
+			a, err := c.AuthorizeKey(ctx, r.KeyID, r.KeyFingerprint, r.CommitterEmail)
+			if err != nil {
+				return nil, err
+			}
+			authorizations = append(authorizations, a)
+		}
+	}
+
+	results := make([]*Authorization, len(requests))
+	for i, r := range requests {
+		results[i] = authorizations[indexes[r]]
+	}
+	return results, nil
+}
+
+// getAuthorizationsBatch sends a single request to the batch authorization
+// endpoint. The authorizations are matched to the requests by the request each
+// one echoes, and returned in the order of the requests, which must be unique.
+func (c APIClient) getAuthorizationsBatch(ctx context.Context, requests []AuthorizationRequest) ([]*Authorization, error) {
+	u, err := c.endpoint("v0", "gpg", "key", "authorization", "git-commit-signing", "batch")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(batchAuthorizationRequest{Requests: requests})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp := batchAuthorizationResponse{}
+	err = c.do(req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Authorizations) != len(requests) {
+		return nil, batchMismatchError(fmt.Sprintf("%d authorizations for %d requests", len(resp.Authorizations), len(requests)))
+	}
+
+	indexes := map[AuthorizationRequest]int{}
+	for i, r := range requests {
+		indexes[normalizeAuthorizationRequest(r)] = i
+	}
+	authorizations := make([]*Authorization, len(requests))
+	for i := range resp.Authorizations {
+		r := resp.Authorizations[i].AuthorizationRequest
+		j, ok := indexes[normalizeAuthorizationRequest(r)]
+		if !ok {
+			return nil, batchMismatchError(fmt.Sprintf("unexpected authorization for key %q (fingerprint %q) and committer %q", r.KeyID, r.KeyFingerprint, r.CommitterEmail))
+		}
+		if authorizations[j] != nil {
+			return nil, batchMismatchError(fmt.Sprintf("duplicate authorization for key %q (fingerprint %q) and committer %q", r.KeyID, r.KeyFingerprint, r.CommitterEmail))
+		}
+		authorizations[j] = &resp.Authorizations[i].Authorization
+	}
+	return authorizations, nil
+}
+
+// normalizeAuthorizationRequest upper cases the hex key ID and fingerprint of
+// the request, so that an echoed request matches regardless of their case.
+func normalizeAuthorizationRequest(r AuthorizationRequest) AuthorizationRequest {
+	r.KeyID = strings.ToUpper(r.KeyID)
+	r.KeyFingerprint = normalizeFingerprint(r.KeyFingerprint)
+	return r
+}
+
+// isBatchUnsupported checks if err is the response of an API server that does
+// not implement the batch authorization endpoint.
+func isBatchUnsupported(err error) bool {
+	var badResp BadResponseError
+	if !errors.As(err, &badResp) {
+		return false
+	}
+	switch badResp.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// endpoint returns the URL of the API endpoint with the path elements.
+func (c APIClient) endpoint(elem ...string) (*url.URL, error) {
+	u, err := url.Parse(c.APIBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+
+	u.Path = path.Join(append([]string{u.Path}, elem...)...)
+	return u, nil
+}
+
+// token returns the Bearer token of the client.
+func (c APIClient) token(ctx context.Context) (string, error) {
+	if c.TokenSource != nil {
+		return c.TokenSource.Token(ctx)
+	}
+	return c.APIToken, nil
+}
+
+// do sends the API request and decodes the JSON response body into v.
+func (c APIClient) do(req *http.Request, v interface{}) error {
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Authorization", "Bearer "+c.APIToken)
+	token, err := c.token(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to get api token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read api response: %w", err)
+		return fmt.Errorf("failed to read api response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, BadResponseError{
+		return BadResponseError{
 			RequestMethod: req.Method,
 			RequestURL:    req.URL,
 			StatusCode:    resp.StatusCode,
@@ -112,10 +367,9 @@ func (c APIClient) GetAuthorization(ctx context.Context, keyID, committerEmail s
 		}
 	}
 
-	a := Authorization{}
-	err = json.Unmarshal(body, &a)
+	err = json.Unmarshal(body, v)
 	if err != nil {
-		return nil, BadResponseError{
+		return BadResponseError{
 			RequestMethod: req.Method,
 			RequestURL:    req.URL,
 			StatusCode:    resp.StatusCode,
@@ -125,5 +379,13 @@ func (c APIClient) GetAuthorization(ctx context.Context, keyID, committerEmail s
 		}
 	}
 
-	return &a, nil
+	return nil
+}
+
+// logger returns the Logger of the client, or the standard logger.
+func (c APIClient) logger() Logger {
+	if c.Logger == nil {
+		return log.Default()
+	}
+	return c.Logger
 }
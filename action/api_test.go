@@ -0,0 +1,205 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPIServer is a fake Beyond Identity Key Management API that authorizes
+// the keys in Keys, indexed by key ID and committer email. The committer
+// emails of Keys are the known identities of its directory.
+type fakeAPIServer struct {
+	*httptest.Server
+
+	// Keys maps "<key id>/<committer email>" to the base64 key authorized for
+	// the committer.
+	Keys map[string]string
+	// Batch enables the batch authorization endpoint.
+	Batch bool
+	// BatchResponse, if set, rewrites the authorizations of batch responses,
+	// e.g. to reorder them.
+	BatchResponse func([]batchAuthorization) []batchAuthorization
+
+	mu            sync.Mutex
+	getRequests   int
+	batchRequests int
+	// batchedKeys is the number of authorizations requested in batches.
+	batchedKeys int
+}
+
+func newFakeAPIServer(t *testing.T, batch bool) *fakeAPIServer {
+	t.Helper()
+
+	f := &fakeAPIServer{Keys: map[string]string{}, Batch: batch}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v0/gpg/key/authorization/git-commit-signing", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		f.mu.Lock()
+		f.getRequests++
+		f.mu.Unlock()
+
+		q := r.URL.Query()
+		writeJSON(w, f.authorize(AuthorizationRequest{
+			KeyID:          q.Get("key_id"),
+			KeyFingerprint: q.Get("key_fingerprint"),
+			CommitterEmail: q.Get("committer_email"),
+		}))
+	})
+	mux.HandleFunc("/v0/gpg/key/authorization/git-commit-signing/batch", func(w http.ResponseWriter, r *http.Request) {
+		if !f.Batch {
+			http.NotFound(w, r)
+			return
+		}
+		f.mu.Lock()
+		f.batchRequests++
+		f.mu.Unlock()
+
+		req := batchAuthorizationRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.batchedKeys += len(req.Requests)
+		f.mu.Unlock()
+		resp := batchAuthorizationResponse{Authorizations: []batchAuthorization{}}
+		for _, ar := range req.Requests {
+			resp.Authorizations = append(resp.Authorizations, batchAuthorization{AuthorizationRequest: ar, Authorization: f.authorize(ar)})
+		}
+		if f.BatchResponse != nil {
+			resp.Authorizations = f.BatchResponse(resp.Authorizations)
+		}
+		writeJSON(w, resp)
+	})
+	mux.HandleFunc("/v0/directory/identity", func(w http.ResponseWriter, r *http.Request) {
+		email := r.URL.Query().Get("email")
+		for k := range f.Keys {
+			if strings.HasSuffix(k, "/"+email) {
+				writeJSON(w, Identity{Authorized: true, Message: "ok"})
+				return
+			}
+		}
+		writeJSON(w, Identity{Authorized: false, Message: "unknown identity"})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAPIServer) authorize(r AuthorizationRequest) Authorization {
+	key, ok := f.Keys[r.KeyID+"/"+r.CommitterEmail]
+	if !ok {
+		return Authorization{Authorized: false, Message: "key is not authorized for committer"}
+	}
+	return Authorization{Authorized: true, Message: "ok", GPGKey: GPGKey{ID: r.KeyID, Base64Key: key}}
+}
+
+func (f *fakeAPIServer) client() APIClient {
+	return APIClient{HTTPClient: f.Client(), APIToken: "token", APIBaseURL: f.URL}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestGetAuthorizations(t *testing.T) {
+	requests := []AuthorizationRequest{}
+	for i := 0; i < 5; i++ {
+		requests = append(requests,
+			AuthorizationRequest{KeyID: "87A2691085B4544E", CommitterEmail: "john@doe.com"},
+			AuthorizationRequest{KeyID: "7723AD85B1221B3B", CommitterEmail: "jane@doe.com"},
+			AuthorizationRequest{KeyID: strings.Repeat("0", 15) + string(rune('A'+i)), CommitterEmail: "jackie@doe.com"},
+		)
+	}
+
+	tests := []struct {
+		name                  string
+		batch                 bool
+		batchResponse         func([]batchAuthorization) []batchAuthorization
+		expectedGetRequests   int
+		expectedBatchRequests int
+	}{
+		{
+			name:                  "batch",
+			batch:                 true,
+			expectedBatchRequests: 4, // 7 unique requests in chunks of 2
+		},
+		{
+			name:  "batch_out_of_order",
+			batch: true,
+			batchResponse: func(as []batchAuthorization) []batchAuthorization {
+				for i, j := 0, len(as)-1; i < j; i, j = i+1, j-1 {
+					as[i], as[j] = as[j], as[i]
+				}
+				// Echoed key IDs are matched regardless of case.
+				as[0].KeyID = strings.ToLower(as[0].KeyID)
+				return as
+			},
+			expectedBatchRequests: 4,
+		},
+		{
+			// Deduplicated and padded: the first authorization is repeated in
+			// place of the second, so the batch response is ignored.
+			name:  "batch_mismatch",
+			batch: true,
+			batchResponse: func(as []batchAuthorization) []batchAuthorization {
+				if len(as) > 1 {
+					as[1] = as[0]
+				}
+				return as
+			},
+			expectedGetRequests:   7,
+			expectedBatchRequests: 1,
+		},
+		{
+			name:                  "fallback",
+			batch:                 false,
+			expectedGetRequests:   7,
+			expectedBatchRequests: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAPIServer(t, tt.batch)
+			f.BatchResponse = tt.batchResponse
+			f.Keys["87A2691085B4544E/john@doe.com"] = "john-key"
+			f.Keys["7723AD85B1221B3B/jane@doe.com"] = "jane-key"
+
+			c := f.client()
+			c.BatchSize = 2
+			authorizations, err := c.GetAuthorizations(context.Background(), requests)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(authorizations) != len(requests) {
+				t.Fatalf("expected %d authorizations, got %d", len(requests), len(authorizations))
+			}
+			for i, r := range requests {
+				_, expected := f.Keys[r.KeyID+"/"+r.CommitterEmail]
+				if authorizations[i].Authorized != expected {
+					t.Errorf("request %d: expected authorized %v, got %v", i, expected, authorizations[i].Authorized)
+				}
+				if expected && authorizations[i].GPGKey.ID != r.KeyID {
+					t.Errorf("request %d: expected key %s, got %s", i, r.KeyID, authorizations[i].GPGKey.ID)
+				}
+			}
+
+			if f.getRequests != tt.expectedGetRequests {
+				t.Errorf("expected %d GET requests, got %d", tt.expectedGetRequests, f.getRequests)
+			}
+			if f.batchRequests != tt.expectedBatchRequests {
+				t.Errorf("expected %d batch requests, got %d", tt.expectedBatchRequests, f.batchRequests)
+			}
+		})
+	}
+}
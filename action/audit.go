@@ -0,0 +1,343 @@
+package action
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// auditHTTPTimeout is the timeout of a request to the HTTP audit sink.
+const auditHTTPTimeout = 30 * time.Second
+
+// AuditRecord is a single entry of the audit log. Each record contains the hash
+// of the previous record, so that removing, reordering or modifying records
+// breaks the chain.
+type AuditRecord struct {
+	// Sequence is the position of the record in the log, starting at 1.
+	Sequence uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	// PrevHash is the Hash of the previous record, or empty for the first
+	// record.
+	PrevHash string `json:"prev_hash"`
+	// Outcome is the JSON encoded Outcome of the decision.
+	Outcome json.RawMessage `json:"outcome"`
+	// BreakGlassCommits are the hashes of the commits whose failed
+	// verification was overridden by a break-glass token, repeated from the
+	// Outcome so that overrides stand out.
+	BreakGlassCommits []string `json:"break_glass_commits,omitempty"`
+	// Hash is the hex encoded SHA-256 hash of the JSON encoding of the record
+	// without the Hash.
+	Hash string `json:"hash,omitempty"`
+}
+
+// computeHash returns the hash of the record.
+func (r AuditRecord) computeHash() (string, error) {
+	r.Hash = ""
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditSink receives the JSON encoded records of the audit log, one line at a
+// time.
+type AuditSink interface {
+	WriteRecord(ctx context.Context, line []byte) error
+}
+
+// FileAuditSink appends records to a JSONL file.
+type FileAuditSink string
+
+// WriteRecord implements AuditSink.
+func (s FileAuditSink) WriteRecord(ctx context.Context, line []byte) error {
+	f, err := os.OpenFile(string(s), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log file at '%s': %w", string(s), err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log file at '%s': %w", string(s), err)
+	}
+	return f.Close()
+}
+
+// SyslogAuditSink sends records to syslog. It is not supported on Windows and
+// Plan 9, where WriteRecord always fails.
+type SyslogAuditSink struct {
+	// Network and Address of the syslog server (e.g. "udp" and
+	// "syslog.example.com:514"). If empty, the local syslog server is used.
+	Network string
+	Address string
+}
+
+// HTTPAuditSink POSTs each record to an HTTP endpoint.
+type HTTPAuditSink struct {
+	HTTPClient *http.Client
+	URL        string
+	// Token is sent as a Bearer token, if set.
+	Token string
+}
+
+// WriteRecord implements AuditSink.
+func (s HTTPAuditSink) WriteRecord(ctx context.Context, line []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(line))
+	if err != nil {
+		return fmt.Errorf("failed to build audit request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send audit request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return BadResponseError{
+			RequestMethod: req.Method,
+			RequestURL:    req.URL,
+			StatusCode:    resp.StatusCode,
+			Body:          body,
+			Header:        resp.Header,
+			Cause:         fmt.Errorf("expected 2xx status"),
+		}
+	}
+	return nil
+}
+
+// AuditLog appends hash-chained records to its sinks. The head of the chain
+// (the sequence number and hash of the last record) is read from, and written
+// to, StateFile.
+type AuditLog struct {
+	// Sinks are written in order, and writing a record stops at the first
+	// sink that fails. The head of the chain is persisted before any sink is
+	// written, so that a record that reached a sink is never followed by
+	// another record with the same sequence number, which would fork the
+	// chain. A sink that failed has a gap in its chain instead.
+	Sinks []AuditSink
+	// StateFile is the file holding the head of the chain, and is required.
+	// It may be the JSONL file of a FileAuditSink, in which case the head is
+	// read from its last record, and that sink is written first.
+	StateFile string
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewAuditLog returns the AuditLog configured by cfg, or nil if no audit sink
+// is configured. Its sinks are the local file, syslog and the HTTP endpoint, in
+// that order. Returns an error if there is neither a local file nor a state
+// file to chain the records across runs.
+func NewAuditLog(cfg Config) (*AuditLog, error) {
+	a := &AuditLog{StateFile: cfg.AuditStateFile}
+	if cfg.AuditLogFile != "" {
+		a.Sinks = append(a.Sinks, FileAuditSink(cfg.AuditLogFile))
+		if a.StateFile == "" {
+			a.StateFile = cfg.AuditLogFile
+		}
+	}
+	if cfg.AuditSyslogAddress != "" {
+		sink := SyslogAuditSink{}
+		if cfg.AuditSyslogAddress != "local" {
+			u, err := url.Parse(cfg.AuditSyslogAddress)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid syslog address: %q", cfg.AuditSyslogAddress)
+			}
+			sink.Network, sink.Address = u.Scheme, u.Host
+		}
+		a.Sinks = append(a.Sinks, sink)
+	}
+	if cfg.AuditHTTPURL != "" {
+		a.Sinks = append(a.Sinks, HTTPAuditSink{
+			HTTPClient: &http.Client{Timeout: auditHTTPTimeout},
+			URL:        cfg.AuditHTTPURL,
+			Token:      cfg.AuditHTTPToken,
+		})
+	}
+	if len(a.Sinks) == 0 {
+		return nil, nil
+	}
+	if a.StateFile == "" {
+		return nil, errAuditStateFileRequired
+	}
+	return a, nil
+}
+
+// errAuditStateFileRequired is returned if audit records would not be chained
+// across runs.
+var errAuditStateFileRequired = errors.New("an audit log file or state file is required to chain the audit records across runs")
+
+// Append persists the record of the outcome as the head of the chain, then
+// appends it to all sinks, in order. If a sink fails, the record is not written
+// to the remaining sinks.
+func (a *AuditLog) Append(ctx context.Context, o *Outcome) (*AuditRecord, error) {
+	if a.StateFile == "" {
+		return nil, errAuditStateFileRequired
+	}
+
+	outcome, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outcome: %w", err)
+	}
+
+	head, err := a.head()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	r := &AuditRecord{Time: now().UTC(), Outcome: outcome, BreakGlassCommits: o.BreakGlassCommits}
+	if head != nil {
+		r.Sequence = head.Sequence + 1
+		r.PrevHash = head.Hash
+	} else {
+		r.Sequence = 1
+	}
+	r.Hash, err = r.computeHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash audit record: %w", err)
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	// Persist the head of the chain first, either to the state file or to the
+	// sink that it is the log of.
+	sinks := a.Sinks
+	if i := a.stateFileSink(); i >= 0 {
+		sinks = append([]AuditSink{a.Sinks[i]}, append(a.Sinks[:i:i], a.Sinks[i+1:]...)...)
+	} else if err := writeFileAtomic(a.StateFile, append(line, '\n')); err != nil {
+		return r, fmt.Errorf("failed to write audit state file: %w", err)
+	}
+
+	for _, sink := range sinks {
+		if err := sink.WriteRecord(ctx, line); err != nil {
+			return r, fmt.Errorf("failed to write audit record: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// head returns the last record of the chain, or nil if the chain is empty.
+func (a *AuditLog) head() (*AuditRecord, error) {
+	if a.StateFile == "" {
+		return nil, nil
+	}
+
+	bs, err := ioutil.ReadFile(a.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit state at '%s': %w", a.StateFile, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if last == "" {
+		return nil, nil
+	}
+	r := &AuditRecord{}
+	if err := json.Unmarshal([]byte(last), r); err != nil {
+		return nil, fmt.Errorf("failed to parse last audit record at '%s': %w", a.StateFile, err)
+	}
+	return r, nil
+}
+
+// stateFileSink returns the index of the sink whose JSONL file is the state
+// file, or -1 if there is none.
+func (a *AuditLog) stateFileSink() int {
+	for i, sink := range a.Sinks {
+		if f, ok := sink.(FileAuditSink); ok && filepath.Clean(string(f)) == filepath.Clean(a.StateFile) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AuditChainError is returned by VerifyAuditLog when the chain is broken.
+type AuditChainError struct {
+	// Line is the line number of the first record that breaks the chain.
+	Line   int
+	Reason string
+}
+
+func (e AuditChainError) Error() string {
+	return fmt.Sprintf("audit log is broken at line %d: %s", e.Line, e.Reason)
+}
+
+// VerifyAuditLog reads the JSONL audit log from r, and checks the hash of every
+// record and that it is chained to the previous record without gaps. The first
+// record must start the chain (sequence 1 without a previous hash), unless
+// anchorHash is set, in which case it must follow the record with that hash,
+// e.g. the last record of a rotated log. Returns the number of records
+// verified.
+func VerifyAuditLog(r io.Reader, anchorHash string) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	var prev *AuditRecord
+	count, line := 0, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		record := &AuditRecord{}
+		if err := json.Unmarshal([]byte(text), record); err != nil {
+			return count, AuditChainError{Line: line, Reason: fmt.Sprintf("invalid record: %v", err)}
+		}
+
+		hash, err := record.computeHash()
+		if err != nil {
+			return count, AuditChainError{Line: line, Reason: fmt.Sprintf("invalid record: %v", err)}
+		}
+		if hash != record.Hash {
+			return count, AuditChainError{Line: line, Reason: "record hash does not match its content"}
+		}
+
+		switch {
+		case prev == nil && anchorHash == "" && (record.Sequence != 1 || record.PrevHash != ""):
+			return count, AuditChainError{Line: line, Reason: fmt.Sprintf("first record has sequence %d and previous hash %q, expected the start of the chain or an anchor hash", record.Sequence, record.PrevHash)}
+		case prev == nil && anchorHash != "" && record.PrevHash != anchorHash:
+			return count, AuditChainError{Line: line, Reason: "previous hash of the first record does not match the anchor hash"}
+		case prev != nil && record.Sequence != prev.Sequence+1:
+			return count, AuditChainError{Line: line, Reason: fmt.Sprintf("expected sequence %d, got %d", prev.Sequence+1, record.Sequence)}
+		case prev != nil && record.PrevHash != prev.Hash:
+			return count, AuditChainError{Line: line, Reason: "previous hash does not match the previous record"}
+		}
+
+		prev = record
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows && !plan9
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+)
+
+// WriteRecord implements AuditSink.
+func (s SyslogAuditSink) WriteRecord(ctx context.Context, line []byte) error {
+	w, err := syslog.Dial(s.Network, s.Address, syslog.LOG_NOTICE|syslog.LOG_AUTH, "auth-commit-sig")
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	defer w.Close()
+
+	if err := w.Notice(string(line)); err != nil {
+		return fmt.Errorf("failed to write to syslog: %w", err)
+	}
+	return nil
+}
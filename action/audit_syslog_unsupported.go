@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package action
+
+import (
+	"context"
+	"errors"
+)
+
+// WriteRecord implements AuditSink. Syslog is not supported on this platform.
+func (s SyslogAuditSink) WriteRecord(ctx context.Context, line []byte) error {
+	return errors.New("syslog audit sink is not supported on this platform")
+}
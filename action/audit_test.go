@@ -0,0 +1,193 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer audit-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		posted = append(posted, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := NewAuditLog(Config{AuditLogFile: path, AuditHTTPURL: srv.URL, AuditHTTPToken: "audit-token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auditLog.Now = func() time.Time { return time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	for _, result := range []string{PASS, FAIL, PASS} {
+		if _, err := auditLog.Append(context.Background(), &Outcome{Repository: "repo", Result: result, Errors: []OutcomeError{}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(posted) != 3 {
+		t.Errorf("expected 3 records posted to the HTTP sink, got %d", len(posted))
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+
+	tests := []struct {
+		name        string
+		lines       []string
+		anchor      string
+		expectedErr string
+	}{
+		{
+			name:  "valid",
+			lines: lines,
+		},
+		{
+			name:        "truncated",
+			lines:       lines[1:],
+			expectedErr: "audit log is broken at line 1: first record has sequence 2 and previous hash \"" + hashOf(lines[0]) + "\", expected the start of the chain or an anchor hash",
+		},
+		{
+			name:   "rotated",
+			lines:  lines[1:],
+			anchor: hashOf(lines[0]),
+		},
+		{
+			name:        "rotated_wrong_anchor",
+			lines:       lines[2:],
+			anchor:      hashOf(lines[0]),
+			expectedErr: "audit log is broken at line 1: previous hash of the first record does not match the anchor hash",
+		},
+		{
+			name:        "gap",
+			lines:       []string{lines[0], lines[2]},
+			expectedErr: "audit log is broken at line 2: expected sequence 2, got 3",
+		},
+		{
+			name:        "tampered",
+			lines:       []string{lines[0], strings.Replace(lines[1], `"result":"FAIL"`, `"result":"PASS"`, 1), lines[2]},
+			expectedErr: "audit log is broken at line 2: record hash does not match its content",
+		},
+		{
+			name:        "reordered",
+			lines:       []string{lines[1], lines[0]},
+			anchor:      hashOf(lines[0]),
+			expectedErr: "audit log is broken at line 2: expected sequence 3, got 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyAuditLog(strings.NewReader(strings.Join(tt.lines, "\n")), tt.anchor)
+			assertEqualErr(t, tt.expectedErr, err)
+		})
+	}
+}
+
+// hashOf returns the hash of the JSON encoded audit record.
+func hashOf(line string) string {
+	r := AuditRecord{}
+	if err := json.Unmarshal([]byte(line), &r); err != nil {
+		panic(err)
+	}
+	return r.Hash
+}
+
+func TestAuditLogStateFile(t *testing.T) {
+	dir := t.TempDir()
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted = append(posted, string(body))
+	}))
+	defer srv.Close()
+
+	cfg := Config{AuditHTTPURL: srv.URL, AuditStateFile: filepath.Join(dir, "audit.state")}
+	for i := 0; i < 2; i++ {
+		// A new AuditLog for every run, so the chain head comes from the state file.
+		auditLog, err := NewAuditLog(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := auditLog.Append(context.Background(), &Outcome{Result: PASS, Errors: []OutcomeError{}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if n, err := VerifyAuditLog(strings.NewReader(strings.Join(posted, "\n")), ""); err != nil || n != 2 {
+		t.Errorf("expected 2 chained records, got %d: %v", n, err)
+	}
+}
+
+func TestAuditLogSinkFailure(t *testing.T) {
+	fail := true
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		posted = append(posted, string(body))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := NewAuditLog(Config{AuditLogFile: path, AuditHTTPURL: srv.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := auditLog.Append(context.Background(), &Outcome{Result: PASS, Errors: []OutcomeError{}}); err == nil {
+		t.Fatalf("expected an error when the HTTP sink fails")
+	}
+
+	// The local log holds the head of the chain, so the next record follows
+	// the failed one instead of forking the chain of the HTTP sink.
+	fail = false
+	if _, err := auditLog.Append(context.Background(), &Outcome{Result: PASS, Errors: []OutcomeError{}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if n, err := VerifyAuditLog(strings.NewReader(string(bs)), ""); err != nil || n != 2 {
+		t.Fatalf("expected 2 chained records, got %d: %v", n, err)
+	}
+	if len(posted) != 1 || posted[0] != lines[1] {
+		t.Errorf("expected only the second record in the HTTP sink, got %v", posted)
+	}
+	if _, err := VerifyAuditLog(strings.NewReader(posted[0]), hashOf(lines[0])); err != nil {
+		t.Errorf("expected the HTTP sink to have a gap instead of a fork, got %v", err)
+	}
+}
+
+func TestNewAuditLogRequiresState(t *testing.T) {
+	_, err := NewAuditLog(Config{AuditHTTPURL: "https://audit.example.com"})
+	if err != errAuditStateFileRequired {
+		t.Errorf("expected %v, got %v", errAuditStateFileRequired, err)
+	}
+	found := false
+	for _, err := range (Config{AuditSyslogAddress: "local"}).validate(false, false) {
+		found = found || err == MissingConfigFieldError("AuditStateFile")
+	}
+	if !found {
+		t.Errorf("expected a missing AuditStateFile error")
+	}
+}
@@ -0,0 +1,118 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultParallelism is the number of commits of a range verified at once, if
+// Config.Parallelism is not set.
+const DefaultParallelism = 4
+
+// DefaultMaxConcurrentAPIRequests is the maximum number of authorization
+// requests in flight at once, if Config.MaxConcurrentAPIRequests is not set.
+const DefaultMaxConcurrentAPIRequests = 4
+
+// Authorizer authorizes a GPG key for git commit signing by a committer.
+// APIClient and Snapshot are Authorizers.
+type Authorizer interface {
+	AuthorizeKey(ctx context.Context, keyID, fingerprint, committerEmail string) (*Authorization, error)
+}
+
+// IdentityDirectory looks up whether an email address belongs to a known,
+// authorized Beyond Identity user. APIClient and Snapshot are
+// IdentityDirectories.
+type IdentityDirectory interface {
+	LookupIdentity(ctx context.Context, emailAddress string) (*Identity, error)
+}
+
+// errNoIdentityDirectory is returned when co-authors are verified with an
+// Authorizer that is not an IdentityDirectory.
+var errNoIdentityDirectory = errors.New("co-author verification requires an authorizer that can look up identities")
+
+// Identity is returned by a successful LookupIdentity call.
+type Identity struct {
+	Authorized bool   `json:"authorized"`
+	Message    string `json:"message"`
+}
+
+// limitedAuthorizer is an Authorizer that limits the number of concurrent
+// calls to the wrapped Authorizer.
+type limitedAuthorizer struct {
+	Authorizer
+	sem chan struct{}
+}
+
+// newLimitedAuthorizer wraps a, allowing at most limit concurrent calls, or
+// DefaultMaxConcurrentAPIRequests if limit is not positive.
+func newLimitedAuthorizer(a Authorizer, limit int) *limitedAuthorizer {
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentAPIRequests
+	}
+	return &limitedAuthorizer{Authorizer: a, sem: make(chan struct{}, limit)}
+}
+
+// AuthorizeKey implements Authorizer.
+func (a *limitedAuthorizer) AuthorizeKey(ctx context.Context, keyID, fingerprint, committerEmail string) (*Authorization, error) {
+	select {
+	case a.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-a.sem }()
+
+	return a.Authorizer.AuthorizeKey(ctx, keyID, fingerprint, committerEmail)
+}
+
+// LookupIdentity looks up the email address in the wrapped Authorizer, with the
+// same limit as AuthorizeKey. Returns an error if the wrapped Authorizer is
+// not an IdentityDirectory.
+func (a *limitedAuthorizer) LookupIdentity(ctx context.Context, emailAddress string) (*Identity, error) {
+	d, ok := a.Authorizer.(IdentityDirectory)
+	if !ok {
+		return nil, errNoIdentityDirectory
+	}
+	select {
+	case a.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-a.sem }()
+
+	return d.LookupIdentity(ctx, emailAddress)
+}
+
+// GetAuthorizations requests the authorizations at once through the batch API
+// of the wrapped Authorizer, with the same limit as AuthorizeKey. Returns an
+// error if the wrapped Authorizer is not an APIClient.
+func (a *limitedAuthorizer) GetAuthorizations(ctx context.Context, requests []AuthorizationRequest) ([]*Authorization, error) {
+	client, ok := a.Authorizer.(APIClient)
+	if !ok {
+		return nil, fmt.Errorf("authorizer does not support batch requests")
+	}
+	select {
+	case a.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-a.sem }()
+
+	return client.GetAuthorizations(ctx, requests)
+}
+
+// prefetchedAuthorizer is an Authorizer that answers from authorizations that
+// were requested in advance, and falls back to the wrapped Authorizer.
+type prefetchedAuthorizer struct {
+	Authorizer
+	Authorizations map[AuthorizationRequest]*Authorization
+}
+
+// AuthorizeKey implements Authorizer.
+func (a prefetchedAuthorizer) AuthorizeKey(ctx context.Context, keyID, fingerprint, committerEmail string) (*Authorization, error) {
+	r := AuthorizationRequest{KeyID: keyID, KeyFingerprint: fingerprint, CommitterEmail: committerEmail}
+	if authorization, ok := a.Authorizations[r]; ok {
+		return authorization, nil
+	}
+	return a.Authorizer.AuthorizeKey(ctx, keyID, fingerprint, committerEmail)
+}
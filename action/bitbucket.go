@@ -0,0 +1,136 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LoadBitbucketEvent derives the commits of a Bitbucket Pipelines build from
+// its default variables, read with getenv (e.g. os.Getenv).
+//
+// Bitbucket does not provide the commit before a push, so only pull request
+// pipelines have a base commit.
+func LoadBitbucketEvent(getenv func(string) string) (*CIEvent, error) {
+	e := &CIEvent{
+		Name:       "push",
+		Repository: getenv("BITBUCKET_REPO_FULL_NAME"),
+		// Pull request pipelines merge the destination branch into the clone,
+		// but BITBUCKET_COMMIT is still the head of the source branch.
+		HeadSHA: getenv("BITBUCKET_COMMIT"),
+	}
+	if getenv("BITBUCKET_PR_ID") != "" {
+		e.Name = "pull_request"
+		// An abbreviated hash, which is resolved like any other ref.
+		e.BaseSHA = getenv("BITBUCKET_PR_DESTINATION_COMMIT")
+	}
+
+	if e.HeadSHA == "" {
+		return nil, fmt.Errorf("BITBUCKET_COMMIT is not set")
+	}
+	return e, nil
+}
+
+// BitbucketReport is a Bitbucket Code Insights report.
+// See https://developer.atlassian.com/cloud/bitbucket/rest/api-group-reports/.
+type BitbucketReport struct {
+	Title      string                `json:"title"`
+	Details    string                `json:"details"`
+	ReportType string                `json:"report_type"`
+	Reporter   string                `json:"reporter"`
+	Result     string                `json:"result"`
+	Data       []BitbucketReportData `json:"data"`
+}
+
+// BitbucketReportData is a data field of a Bitbucket Code Insights report.
+type BitbucketReportData struct {
+	Title string      `json:"title"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// BitbucketAnnotation is an annotation of a Bitbucket Code Insights report.
+type BitbucketAnnotation struct {
+	ExternalID     string `json:"external_id"`
+	Title          string `json:"title"`
+	AnnotationType string `json:"annotation_type"`
+	Summary        string `json:"summary"`
+	Severity       string `json:"severity"`
+	Result         string `json:"result"`
+}
+
+// NewBitbucketReport returns the Code Insights report of the outcome.
+func NewBitbucketReport(o *Outcome) *BitbucketReport {
+	result := "PASSED"
+	if o.Result == FAIL {
+		result = "FAILED"
+	}
+
+	verified := 1
+	if len(o.Commits) > 0 {
+		verified = len(o.Commits)
+	}
+	return &BitbucketReport{
+		Title:      "Authorize Commit Signing",
+		Details:    o.Desc,
+		ReportType: "SECURITY",
+		Reporter:   "auth-commit-sig",
+		Result:     result,
+		Data: []BitbucketReportData{
+			{Title: "Commits verified", Type: "NUMBER", Value: verified},
+			{Title: "Commits failed", Type: "NUMBER", Value: len(failedOutcomes(o))},
+		},
+	}
+}
+
+// NewBitbucketAnnotations returns an annotation for every commit of the
+// outcome that failed verification.
+func NewBitbucketAnnotations(o *Outcome) []BitbucketAnnotation {
+	annotations := []BitbucketAnnotation{}
+	for i, co := range failedOutcomes(o) {
+		id := fmt.Sprintf("auth-commit-sig-%d", i+1)
+		title := "Commit failed verification"
+		if co.Commit != nil {
+			id = "auth-commit-sig-" + co.Commit.CommitHash
+			title = fmt.Sprintf("Commit %s failed verification", co.Commit.CommitHash)
+		}
+		severity := "HIGH"
+		if co.Result == WARN {
+			severity = "MEDIUM"
+		}
+		summary := co.Desc
+		for _, e := range co.Errors {
+			summary += " " + e.Desc
+		}
+		annotations = append(annotations, BitbucketAnnotation{
+			ExternalID:     id,
+			Title:          title,
+			AnnotationType: "VULNERABILITY",
+			Summary:        summary,
+			Severity:       severity,
+			Result:         "FAILED",
+		})
+	}
+	return annotations
+}
+
+// WriteBitbucketReport writes the Code Insights report of the outcome to
+// reportPath and its annotations to annotationsPath, if set, to be uploaded
+// to the reports API of the commit.
+func WriteBitbucketReport(reportPath, annotationsPath string, o *Outcome) error {
+	bs, err := json.MarshalIndent(NewBitbucketReport(o), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := writeFileAtomic(reportPath, bs); err != nil {
+		return err
+	}
+	if annotationsPath == "" {
+		return nil
+	}
+
+	bs, err = json.MarshalIndent(NewBitbucketAnnotations(o), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode annotations: %w", err)
+	}
+	return writeFileAtomic(annotationsPath, bs)
+}
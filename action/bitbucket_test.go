@@ -0,0 +1,51 @@
+package action
+
+import (
+	"testing"
+)
+
+func TestLoadBitbucketEvent(t *testing.T) {
+	e, err := LoadBitbucketEvent(testEnv(map[string]string{
+		"BITBUCKET_REPO_FULL_NAME":        "workspace/repo",
+		"BITBUCKET_COMMIT":                "head",
+		"BITBUCKET_PR_ID":                 "3",
+		"BITBUCKET_PR_DESTINATION_COMMIT": "base",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := CIEvent{Name: "pull_request", Repository: "workspace/repo", BaseSHA: "base", HeadSHA: "head"}
+	if *e != expected {
+		t.Errorf("expected %+v, got %+v", expected, *e)
+	}
+
+	e, err = LoadBitbucketEvent(testEnv(map[string]string{"BITBUCKET_REPO_FULL_NAME": "workspace/repo", "BITBUCKET_COMMIT": "head"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = CIEvent{Name: "push", Repository: "workspace/repo", HeadSHA: "head"}
+	if *e != expected {
+		t.Errorf("expected %+v, got %+v", expected, *e)
+	}
+}
+
+func TestBitbucketReport(t *testing.T) {
+	o := &Outcome{
+		Result: FAIL,
+		Desc:   "1 of 2 commits failed verification. See commits for details.",
+		Commits: []*Outcome{
+			{Result: PASS, Commit: &Commit{CommitHash: "aaaa"}},
+			{Result: FAIL, Desc: "Commit is not signed.", Commit: &Commit{CommitHash: "bbbb"}},
+		},
+	}
+
+	report := NewBitbucketReport(o)
+	if report.Result != "FAILED" || report.Data[0].Value != 2 || report.Data[1].Value != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	annotations := NewBitbucketAnnotations(o)
+	if len(annotations) != 1 || annotations[0].ExternalID != "auth-commit-sig-bbbb" || annotations[0].Summary != "Commit is not signed." {
+		t.Errorf("unexpected annotations %+v", annotations)
+	}
+}
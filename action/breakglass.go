@@ -0,0 +1,215 @@
+package action
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrCodeInvalidBreakGlassToken is the OutcomeError code of a break-glass token
+// that cannot be trusted.
+const ErrCodeInvalidBreakGlassToken = "INVALID_BREAK_GLASS_TOKEN"
+
+// BreakGlassTokenVersion is the version of the BreakGlassToken format.
+const BreakGlassTokenVersion = 1
+
+// DefaultBreakGlassMaxTTL is the longest a break-glass token may be valid, if
+// Config.BreakGlassMaxTTL is not set.
+const DefaultBreakGlassMaxTTL = 24 * time.Hour
+
+// BreakGlassTrailer is the key of the commit message trailer that supplies a
+// break-glass token, e.g. "Break-glass-token: <token>".
+const BreakGlassTrailer = "Break-glass-token"
+
+// BreakGlassToken overrides the verification of a commit of a repository,
+// e.g. to merge an unsigned hotfix during an incident.
+type BreakGlassToken struct {
+	Version    int    `json:"version"`
+	Repository string `json:"repository"`
+	// CommitHash is the full hash of the commit the token overrides.
+	CommitHash string `json:"commit_hash"`
+	// Justification says why verification is overridden.
+	Justification string    `json:"justification"`
+	IssuedAt      time.Time `json:"issued_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// SignedBreakGlassToken is the JSON encoded BreakGlassToken and an
+// ASCII-armored detached signature over its compact encoding. Encoded tokens
+// are the base64url encoding of its JSON encoding, see EncodeBreakGlassToken.
+type SignedBreakGlassToken struct {
+	Token     json.RawMessage `json:"token"`
+	Signature string          `json:"signature"`
+}
+
+// BreakGlassTokenError is returned when a break-glass token cannot be trusted.
+type BreakGlassTokenError string
+
+func (e BreakGlassTokenError) Error() string {
+	return fmt.Sprintf("invalid break-glass token: %s", string(e))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e BreakGlassTokenError) Code() string {
+	return ErrCodeInvalidBreakGlassToken
+}
+
+// NewBreakGlassToken returns a token overriding the verification of the commit
+// of the repository that is valid for ttl from now.
+func NewBreakGlassToken(repository, commitHash, justification string, now time.Time, ttl time.Duration) *BreakGlassToken {
+	return &BreakGlassToken{
+		Version:       BreakGlassTokenVersion,
+		Repository:    repository,
+		CommitHash:    strings.ToLower(commitHash),
+		Justification: justification,
+		IssuedAt:      now.UTC(),
+		ExpiresAt:     now.Add(ttl).UTC(),
+	}
+}
+
+// EncodeBreakGlassToken signs the token with the private key of signer and
+// returns it encoded on a single line, to be supplied as an input or in a
+// commit message trailer.
+func EncodeBreakGlassToken(t *BreakGlassToken, signer *openpgp.Entity) (string, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal break-glass token: %w", err)
+	}
+
+	sig := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader(payload), nil); err != nil {
+		return "", fmt.Errorf("failed to sign break-glass token: %w", err)
+	}
+	bs, err := json.Marshal(SignedBreakGlassToken{Token: payload, Signature: sig.String()})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal break-glass token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// VerifyBreakGlassToken decodes the token and checks that it is signed by a
+// key in keyRing, is valid at now, and was not issued for longer than maxTTL.
+// Returns the token and the key that signed it.
+func VerifyBreakGlassToken(encoded string, keyRing openpgp.EntityList, policy CryptoPolicy, now time.Time, maxTTL time.Duration) (*BreakGlassToken, *openpgp.Entity, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(encoded), "="))
+	if err != nil {
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("failed to decode token: %v", err))
+	}
+	signed := SignedBreakGlassToken{}
+	if err := json.Unmarshal(bs, &signed); err != nil {
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("failed to parse token: %v", err))
+	}
+
+	payload := &bytes.Buffer{}
+	if err := json.Compact(payload, signed.Token); err != nil {
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("failed to parse token: %v", err))
+	}
+	signer, _, err := checkArmoredDetachedSignature(keyRing, payload.String(), signed.Signature, policy)
+	if err != nil {
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("signature check failed: %v", err))
+	}
+
+	t := &BreakGlassToken{}
+	if err := json.Unmarshal(signed.Token, t); err != nil {
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("failed to parse token: %v", err))
+	}
+	if t.Version != BreakGlassTokenVersion {
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("unsupported version %d", t.Version))
+	}
+
+	if maxTTL <= 0 {
+		maxTTL = DefaultBreakGlassMaxTTL
+	}
+	switch {
+	case t.Repository == "" || t.Justification == "":
+		return nil, nil, BreakGlassTokenError("the repository and justification are required")
+	case len(t.CommitHash) != 40 || plumbing.NewHash(t.CommitHash).String() != t.CommitHash:
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("invalid commit hash %q", t.CommitHash))
+	case t.IssuedAt.After(now.Add(snapshotClockSkew)):
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("issued in the future at %s", t.IssuedAt.Format(time.RFC3339)))
+	case !now.Before(t.ExpiresAt):
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("expired at %s", t.ExpiresAt.Format(time.RFC3339)))
+	case t.ExpiresAt.Sub(t.IssuedAt) > maxTTL:
+		return nil, nil, BreakGlassTokenError(fmt.Sprintf("valid for %s, longer than %s", t.ExpiresAt.Sub(t.IssuedAt), maxTTL))
+	}
+	return t, signer, nil
+}
+
+// LoadBreakGlassKeys reads the admin keys that sign break-glass tokens from
+// the keyring file. Every key in the keyring must be pinned by its primary key
+// fingerprint, and fingerprints must not be empty.
+func LoadBreakGlassKeys(filePath string, fingerprints []string) (openpgp.EntityList, error) {
+	return loadPinnedKeyRing("break-glass", filePath, fingerprints)
+}
+
+// breakGlassTokens are the trusted break-glass tokens of a verification by the
+// commits they override, and the errors of the tokens that are not trusted.
+type breakGlassTokens struct {
+	overrides map[plumbing.Hash]*BreakGlassOverride
+	errs      []error
+}
+
+// loadBreakGlassTokens verifies the break-glass token of the Config and those
+// in the trailers of the commits, if a break-glass keyring is configured. A
+// commit cannot carry a token for itself, so trailers only override commits
+// when a range is verified.
+func (v *Verifier) loadBreakGlassTokens(commits []*object.Commit) *breakGlassTokens {
+	tokens := &breakGlassTokens{overrides: map[plumbing.Hash]*BreakGlassOverride{}}
+	if v.breakGlassKeyRing == nil {
+		return tokens
+	}
+
+	add := func(encoded, source string) {
+		t, signer, err := VerifyBreakGlassToken(encoded, v.breakGlassKeyRing, v.cfg.CryptoPolicy, v.now(), v.cfg.BreakGlassMaxTTL)
+		if err == nil && t.Repository != v.cfg.Repository {
+			err = BreakGlassTokenError(fmt.Sprintf("issued for repository %q", t.Repository))
+		}
+		if err != nil {
+			tokens.errs = append(tokens.errs, fmt.Errorf("%s: %w", source, err))
+			return
+		}
+		tokens.overrides[plumbing.NewHash(t.CommitHash)] = &BreakGlassOverride{
+			Token:             *t,
+			Source:            source,
+			SignerFingerprint: formatFingerprint(signer.PrimaryKey.Fingerprint),
+			SignerUserID:      signer.PrimaryIdentity().Name,
+		}
+	}
+	if v.cfg.BreakGlassToken != "" {
+		add(v.cfg.BreakGlassToken, "break-glass token input")
+	}
+	for _, c := range commits {
+		for _, t := range ParseTrailers(c.Message) {
+			if strings.EqualFold(t.Key, BreakGlassTrailer) {
+				add(t.Value, fmt.Sprintf("break-glass token in commit %s", c.Hash))
+			}
+		}
+	}
+	return tokens
+}
+
+// breakGlass overrides the failed verification of the commit in o, if a
+// trusted break-glass token was supplied for it. The errors of the failure are
+// kept in o, as are the errors of untrusted tokens if none applies.
+func (v *verification) breakGlass(o *Outcome, commit *object.Commit) {
+	override, ok := v.breakGlassTokens.overrides[commit.Hash]
+	if !ok {
+		if len(v.breakGlassTokens.errs) > 0 {
+			o.SetErrors(v.breakGlassTokens.errs...)
+		}
+		return
+	}
+
+	v.logger.Printf("\n!!!!!!!!!!!!!!!!\nBREAK-GLASS OVERRIDE of commit %s in %s\nJustification: %s\nSigned by: %s (%s)\nValid until: %s\n!!!!!!!!!!!!!!!!\n\n",
+		commit.Hash, override.Token.Repository, override.Token.Justification, override.SignerUserID, override.SignerFingerprint, override.Token.ExpiresAt.Format(time.RFC3339))
+	o.SetVerificationDetailsBreakGlass(override)
+	o.BreakGlassCommits = append(o.BreakGlassCommits, commit.Hash.String())
+	o.SetResultAndDescription(PASS, fmt.Sprintf("Verification failed, but was overridden with a break-glass token: %s. See errors for the failure.", override.Token.Justification))
+}
@@ -0,0 +1,63 @@
+package action
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func TestVerifyBreakGlassToken(t *testing.T) {
+	admin := newTestEntity(t, "Admin", "admin@example.com")
+	other := newTestEntity(t, "Other", "other@example.com")
+	now := time.Date(2022, 9, 5, 12, 0, 0, 0, time.UTC)
+	commit := "cf2d2127c69c57bef0232b553146c418e1cba43a"
+
+	encode := func(t *testing.T, token *BreakGlassToken, signer *openpgp.Entity) string {
+		t.Helper()
+		encoded, err := EncodeBreakGlassToken(token, signer)
+		if err != nil {
+			t.Fatalf("failed to encode token: %v", err)
+		}
+		return encoded
+	}
+
+	tests := []struct {
+		name        string
+		token       *BreakGlassToken
+		signer      *openpgp.Entity
+		maxTTL      time.Duration
+		expectedErr bool
+	}{
+		{name: "valid", token: NewBreakGlassToken("org/repo", commit, "INC-1", now.Add(-time.Minute), time.Hour), signer: admin},
+		{name: "expired", token: NewBreakGlassToken("org/repo", commit, "INC-1", now.Add(-2*time.Hour), time.Hour), signer: admin, expectedErr: true},
+		{name: "too_long", token: NewBreakGlassToken("org/repo", commit, "INC-1", now, 48*time.Hour), signer: admin, expectedErr: true},
+		{name: "max_ttl", token: NewBreakGlassToken("org/repo", commit, "INC-1", now, 48*time.Hour), signer: admin, maxTTL: 72 * time.Hour},
+		{name: "untrusted_signer", token: NewBreakGlassToken("org/repo", commit, "INC-1", now, time.Hour), signer: other, expectedErr: true},
+		{name: "no_justification", token: NewBreakGlassToken("org/repo", commit, "", now, time.Hour), signer: admin, expectedErr: true},
+		{name: "short_hash", token: NewBreakGlassToken("org/repo", commit[:7], "INC-1", now, time.Hour), signer: admin, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, signer, err := VerifyBreakGlassToken(encode(t, tt.token, tt.signer), openpgp.EntityList{admin}, CryptoPolicy{}, now, tt.maxTTL)
+			if tt.expectedErr {
+				var tokenErr BreakGlassTokenError
+				if !errors.As(err, &tokenErr) {
+					t.Errorf("expected BreakGlassTokenError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token.CommitHash != commit || signer.PrimaryKey.KeyId != admin.PrimaryKey.KeyId {
+				t.Errorf("unexpected token %+v signed by %X", token, signer.PrimaryKey.KeyId)
+			}
+		})
+	}
+
+	if _, _, err := VerifyBreakGlassToken("not a token", openpgp.EntityList{admin}, CryptoPolicy{}, now, 0); err == nil {
+		t.Errorf("expected error for malformed token")
+	}
+}
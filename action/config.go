@@ -1,6 +1,10 @@
 package action
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
 
 // Config configures a run of the action.
 type Config struct {
@@ -11,13 +15,93 @@ type Config struct {
 	// "cf2d2127c69c57bef0232b553146c418e1cba43a").
 	// Required.
 	CommitRef string
+	// BaseRef is the base of a range of commits to verify, if configured. All
+	// commits reachable from CommitRef but not from BaseRef are verified.
+	BaseRef string
+	// Parallelism is the number of commits in a range that are verified at
+	// once. Defaults to DefaultParallelism.
+	Parallelism int
+	// MaxConcurrentAPIRequests is the maximum number of authorization requests
+	// in flight at once. Defaults to DefaultMaxConcurrentAPIRequests.
+	MaxConcurrentAPIRequests int
+	// FailFast stops verifying a range of commits after the first failure. It
+	// is ignored with report-only enforcement, so that all failures are
+	// reported.
+	FailFast bool
+	// VerifyMergedCommits also verifies the commits that a merge commit brings
+	// in through its non-first parents. The merge commit fails if any of them
+	// fail.
+	VerifyMergedCommits bool
+	// VerifyCoAuthors also requires the email address of every Co-authored-by
+	// trailer of a commit to be on the email addresses of the allowlist, or a
+	// known, authorized identity in the Beyond Identity directory (or the
+	// offline snapshot).
+	VerifyCoAuthors bool
+	// ShallowPolicy is ShallowPolicyFail (the default if empty) to fail, or
+	// ShallowPolicyWarn to only warn, if commits cannot be verified because
+	// they are missing from a shallow clone.
+	ShallowPolicy string
+	// Enforcement is EnforcementEnforce (the default if empty) or
+	// EnforcementReport. The repository_settings of the allowlist take
+	// precedence, see GetSettingsForRepo.
+	Enforcement string
+	// BaselineCommit is the full hash of a commit whose ancestors, and the
+	// commit itself, are EXEMPT from verification, if set. The
+	// repository_settings of the allowlist take precedence.
+	BaselineCommit string
+	// Cutover exempts commits committed before it from verification, if set.
+	// The committer date is set by the committer, so a backdated commit is
+	// exempt as well; prefer BaselineCommit where possible. See ParseCutover
+	// for its format. The repository_settings of the allowlist take
+	// precedence.
+	Cutover string
+	// BreakGlassToken is an encoded BreakGlassToken that overrides the failed
+	// verification of the commit it was issued for, if set. When a range is
+	// verified, tokens can also be supplied in BreakGlassTrailer trailers of
+	// its commits. A commit cannot carry a token for itself, so trailers have
+	// no effect when a single commit is verified.
+	BreakGlassToken string
+	// BreakGlassKeyRingFilePath is a path to a keyring file with the admin keys
+	// that sign break-glass tokens. Break-glass tokens are only accepted if it
+	// is set.
+	BreakGlassKeyRingFilePath string
+	// BreakGlassKeyFingerprints pins the primary key fingerprints of the keys
+	// in BreakGlassKeyRingFilePath, and is required with it, so that a keyring
+	// replaced in the checkout is rejected.
+	BreakGlassKeyFingerprints []string
+	// BreakGlassMaxTTL is the longest a break-glass token may be valid.
+	// Defaults to DefaultBreakGlassMaxTTL.
+	BreakGlassMaxTTL time.Duration
+	// SyntheticMergeCommit is the hash of the merge commit that the CI
+	// platform created to test a pull request, if any. Verifying it fails, as
+	// it is not a commit of the pull request.
+	SyntheticMergeCommit string
 	// APIToken is used as a Bearer token for the Beyond Identity Key Management
 	// API.
-	// Required.
+	// Required, unless APITokenFile, OAuth2 client credentials or an
+	// OfflineSnapshotFile are set.
 	APIToken string
+	// APITokenFile is a path to a file containing the Bearer token, e.g. a
+	// mounted secret. The file is re-read for every request so that the token
+	// can be rotated.
+	APITokenFile string
+	// OAuthTokenURL is the token endpoint used to obtain short-lived Bearer
+	// tokens with the OAuth2 client credentials grant, if configured.
+	OAuthTokenURL string
+	// OAuthClientID is the OAuth2 client ID. Required with OAuthTokenURL.
+	OAuthClientID string
+	// OAuthClientSecret is the OAuth2 client secret. Required with
+	// OAuthTokenURL.
+	OAuthClientSecret string
+	// OAuthScopes are the scopes requested with the OAuth2 client credentials
+	// grant, if any.
+	OAuthScopes []string
 	// APIBaseURL is the base URL of the Beyond Identity Key Management API.
 	// Required.
 	APIBaseURL string
+	// Transport configures TLS and proxy settings of the connection to the API
+	// and the OAuth2 token endpoint.
+	Transport TransportConfig
 	// Repository is the name of the repository that the action is being performed on.
 	// This is also used to match against the repositories listed on the allowlist.
 	// Required.
@@ -25,6 +109,87 @@ type Config struct {
 	// AllowlistConfigFilePath is a path to the file containing the allowlist
 	// configuration, if configured.
 	AllowlistConfigFilePath string
+	// AllowlistConfigSource reads the allowlist configuration from a commit of
+	// a git repository instead of the file system, if configured. It has the
+	// form "<repo-path>@<ref>:<path>" (see ParseAllowlistSource). Cannot be
+	// set with AllowlistConfigFilePath.
+	AllowlistConfigSource string
+	// AllowlistSigningKeyRingFilePath is a path to a keyring file containing
+	// the admin keys trusted to sign the allowlist. If set, the allowlist must
+	// have a detached signature at "<AllowlistConfigFilePath>.asc" made by one
+	// of them, or at "<path>.asc" in the same commit for AllowlistConfigSource.
+	AllowlistSigningKeyRingFilePath string
+	// AllowlistSigningKeyFingerprints pins the primary key fingerprints of the
+	// keys in AllowlistSigningKeyRingFilePath, and is required with it, so
+	// that a keyring replaced in the checkout is rejected.
+	AllowlistSigningKeyFingerprints []string
+	// ThirdPartyKeyRingFilePath is a path to a keyring file containing the
+	// third party keys that are pinned by fingerprint on the allowlist, if
+	// configured.
+	ThirdPartyKeyRingFilePath string
+	// KeyserverURL is the base URL of an HKP keyserver (e.g.
+	// "https://keys.openpgp.org") used to refresh third party keys that are
+	// pinned by fingerprint, if configured.
+	KeyserverURL string
+	// WKDLookup enables refreshing third party keys that are pinned by
+	// fingerprint from the Web Key Directory of their email address.
+	WKDLookup bool
+	// WKDBaseURL overrides the base URL of the Web Key Directory, if
+	// configured. Defaults to "https://openpgpkey.<domain>".
+	WKDBaseURL string
+	// KeyCacheDir is a directory where keys fetched from a keyserver or Web
+	// Key Directory are cached, if configured.
+	KeyCacheDir string
+	// KeyCacheTTL is how long a cached key is used before it is refreshed.
+	KeyCacheTTL time.Duration
+	// PlatformKeysDir is a path to the directory containing the platform
+	// signing keys ("<platform>.asc") used for platform key verification.
+	PlatformKeysDir string
+	// OfflineSnapshotFile is a path to a signed snapshot of authorized keys. If
+	// set, commits are verified against the snapshot instead of the API.
+	OfflineSnapshotFile string
+	// OfflineSnapshotKeyRingFilePath is a path to a keyring file containing the
+	// keys trusted to sign offline snapshots. Required with
+	// OfflineSnapshotFile.
+	OfflineSnapshotKeyRingFilePath string
+	// OfflineSnapshotMaxAge rejects offline snapshots issued longer ago than
+	// this, even if they have not expired, if set.
+	OfflineSnapshotMaxAge time.Duration
+	// AuditLogFile is a path to a JSONL file that every decision is appended
+	// to as a hash-chained audit record, if configured.
+	AuditLogFile string
+	// AuditSyslogAddress sends audit records to syslog, if configured. Either
+	// "local" or a URL like "udp://syslog.example.com:514".
+	AuditSyslogAddress string
+	// AuditHTTPURL is an HTTP endpoint that audit records are POSTed to, if
+	// configured.
+	AuditHTTPURL string
+	// AuditHTTPToken is sent as a Bearer token to AuditHTTPURL, if set.
+	AuditHTTPToken string
+	// AuditStateFile is a path to a file holding the last audit record, used to
+	// chain records across runs. Defaults to AuditLogFile, and is required
+	// if only AuditSyslogAddress or AuditHTTPURL are set.
+	AuditStateFile string
+	// NotesCacheRef is the notes ref (e.g. DefaultNotesCacheRef) that the
+	// results of commits that passed verification are cached in, if set.
+	// Cached results are trusted by later runs instead of verifying the
+	// commits again.
+	NotesCacheRef string
+	// NotesCacheSigningKeyFilePath is a path to the ASCII-armored private key
+	// that new notes are signed with. If not set, the cache is only read.
+	NotesCacheSigningKeyFilePath string
+	// NotesCacheSigningKeyPassphrase decrypts the NotesCacheSigningKeyFilePath
+	// key, if it is encrypted.
+	NotesCacheSigningKeyPassphrase string
+	// NotesCacheKeyRingFilePath is a path to a keyring file containing the keys
+	// trusted to sign notes. Defaults to the NotesCacheSigningKeyFilePath key.
+	NotesCacheKeyRingFilePath string
+	// NotesCacheMaxAge stops trusting cached results made longer ago than this.
+	// Defaults to DefaultNotesCacheMaxAge.
+	NotesCacheMaxAge time.Duration
+	// CryptoPolicy restricts the algorithms accepted for signatures and keys.
+	// The zero value is a policy with secure defaults.
+	CryptoPolicy CryptoPolicy
 }
 
 // MissingConfigFieldError is returned from Config.Validate() if any required
@@ -37,21 +202,148 @@ func (e MissingConfigFieldError) Error() string {
 
 // Validate checks that the Config is valid.
 func (c Config) Validate() []error {
+	return c.validate(true, true)
+}
+
+// validate checks that the Config is valid. The CommitRef is only required if
+// requireCommitRef is set, and the settings of the authorizer if
+// requireAuthorizer is set, as a Verifier is given the refs to verify and may
+// be given an Authorizer.
+func (c Config) validate(requireCommitRef, requireAuthorizer bool) []error {
 	var errs []error
 	if c.RepoPath == "" {
 		errs = append(errs, MissingConfigFieldError("RepoPath"))
 	}
-	if c.CommitRef == "" {
+	if c.CommitRef == "" && requireCommitRef {
 		errs = append(errs, MissingConfigFieldError("CommitRef"))
 	}
-	if c.APIToken == "" {
-		errs = append(errs, MissingConfigFieldError("APIToken"))
-	}
-	if c.APIBaseURL == "" {
-		errs = append(errs, MissingConfigFieldError("APIBaseURL"))
+	switch {
+	case !requireAuthorizer:
+	case c.OfflineSnapshotFile != "":
+		if c.OfflineSnapshotKeyRingFilePath == "" {
+			errs = append(errs, MissingConfigFieldError("OfflineSnapshotKeyRingFilePath"))
+		}
+	default:
+		errs = append(errs, c.validateAPICredentials()...)
+		if c.APIBaseURL == "" {
+			errs = append(errs, MissingConfigFieldError("APIBaseURL"))
+		}
 	}
 	if c.Repository == "" {
 		errs = append(errs, MissingConfigFieldError("Repository"))
 	}
+	if c.AllowlistConfigSource != "" {
+		if c.AllowlistConfigFilePath != "" {
+			errs = append(errs, fmt.Errorf("only one of AllowlistConfigFilePath and AllowlistConfigSource may be set"))
+		}
+		if _, err := ParseAllowlistSource(c.AllowlistConfigSource); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(c.AllowlistSigningKeyFingerprints) > 0 && c.AllowlistSigningKeyRingFilePath == "" {
+		errs = append(errs, MissingConfigFieldError("AllowlistSigningKeyRingFilePath"))
+	}
+	if c.AllowlistSigningKeyRingFilePath != "" && len(c.AllowlistSigningKeyFingerprints) == 0 {
+		errs = append(errs, MissingConfigFieldError("AllowlistSigningKeyFingerprints"))
+	}
+	for _, fp := range c.AllowlistSigningKeyFingerprints {
+		if err := Fingerprint(fp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.NotesCacheRef != "" {
+		if err := validateNotesCacheRef(c.NotesCacheRef); err != nil {
+			errs = append(errs, err)
+		}
+		if c.NotesCacheSigningKeyFilePath == "" && c.NotesCacheKeyRingFilePath == "" {
+			errs = append(errs, MissingConfigFieldError("NotesCacheKeyRingFilePath"))
+		}
+	}
+	switch c.ShallowPolicy {
+	case "", ShallowPolicyFail, ShallowPolicyWarn:
+	default:
+		errs = append(errs, fmt.Errorf("invalid shallow policy %q, expected %q or %q", c.ShallowPolicy, ShallowPolicyFail, ShallowPolicyWarn))
+	}
+	if err := validateEnforcement(c.Enforcement); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateBaselineCommit(c.BaselineCommit); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateCutover(c.Cutover); err != nil {
+		errs = append(errs, err)
+	}
+	if (c.AuditSyslogAddress != "" || c.AuditHTTPURL != "") && c.AuditLogFile == "" && c.AuditStateFile == "" {
+		errs = append(errs, MissingConfigFieldError("AuditStateFile"))
+	}
+	if (c.BreakGlassToken != "" || len(c.BreakGlassKeyFingerprints) > 0) && c.BreakGlassKeyRingFilePath == "" {
+		errs = append(errs, MissingConfigFieldError("BreakGlassKeyRingFilePath"))
+	}
+	if c.BreakGlassKeyRingFilePath != "" && len(c.BreakGlassKeyFingerprints) == 0 {
+		errs = append(errs, MissingConfigFieldError("BreakGlassKeyFingerprints"))
+	}
+	for _, fp := range c.BreakGlassKeyFingerprints {
+		if err := Fingerprint(fp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.BreakGlassMaxTTL < 0 {
+		errs = append(errs, fmt.Errorf("invalid break-glass max TTL: %s", c.BreakGlassMaxTTL))
+	}
+	if c.Parallelism < 0 {
+		errs = append(errs, fmt.Errorf("invalid parallelism: %d", c.Parallelism))
+	}
+	if c.MaxConcurrentAPIRequests < 0 {
+		errs = append(errs, fmt.Errorf("invalid maximum concurrent API requests: %d", c.MaxConcurrentAPIRequests))
+	}
+	errs = append(errs, c.Transport.Validate()...)
+	errs = append(errs, c.CryptoPolicy.Validate()...)
 	return errs
 }
+
+// validateAPICredentials checks that exactly one way of authenticating to the
+// API is configured.
+func (c Config) validateAPICredentials() []error {
+	configured := 0
+	for _, set := range []bool{c.APIToken != "", c.APITokenFile != "", c.OAuthTokenURL != ""} {
+		if set {
+			configured++
+		}
+	}
+	switch {
+	case configured == 0:
+		return []error{MissingConfigFieldError("APIToken")}
+	case configured > 1:
+		return []error{fmt.Errorf("only one of APIToken, APITokenFile and OAuthTokenURL may be set")}
+	}
+
+	var errs []error
+	if c.OAuthTokenURL != "" {
+		if c.OAuthClientID == "" {
+			errs = append(errs, MissingConfigFieldError("OAuthClientID"))
+		}
+		if c.OAuthClientSecret == "" {
+			errs = append(errs, MissingConfigFieldError("OAuthClientSecret"))
+		}
+	}
+	return errs
+}
+
+// TokenSource returns the TokenSource for the API credentials configured in
+// the Config. httpClient is used to call the OAuth2 token endpoint.
+func (c Config) TokenSource(httpClient *http.Client) TokenSource {
+	switch {
+	case c.OAuthTokenURL != "":
+		return &ClientCredentialsTokenSource{
+			HTTPClient:   httpClient,
+			TokenURL:     c.OAuthTokenURL,
+			ClientID:     c.OAuthClientID,
+			ClientSecret: c.OAuthClientSecret,
+			Scopes:       c.OAuthScopes,
+		}
+	case c.APITokenFile != "":
+		return FileTokenSource(c.APITokenFile)
+	default:
+		return StaticTokenSource(c.APIToken)
+	}
+}
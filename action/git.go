@@ -1,35 +1,361 @@
 package action
 
 import (
+	"container/heap"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
+// Policies for commits that cannot be verified because they are missing from
+// a shallow clone, see Config.ShallowPolicy.
+const (
+	// ShallowPolicyFail fails the action if any commit cannot be verified.
+	ShallowPolicyFail = "fail"
+	// ShallowPolicyWarn reports the commits that cannot be verified without
+	// failing the action.
+	ShallowPolicyWarn = "warn"
+)
+
+// ErrCodeUnverifiableCommits is the OutcomeError code of commits that cannot
+// be verified because they are missing from a shallow clone.
+const ErrCodeUnverifiableCommits = "UNVERIFIABLE_COMMITS"
+
+// UnverifiableCommitsError lists the hashes of the commits whose parents are
+// missing from a shallow clone, i.e. the commits at its boundary. The missing
+// parents and their ancestors cannot be verified.
+type UnverifiableCommitsError []string
+
+func (e UnverifiableCommitsError) Error() string {
+	return fmt.Sprintf("the parents of commits are missing from the shallow clone and cannot be verified, together with their ancestors, fetch more history (e.g. fetch-depth: 0) to verify them: %s", strings.Join(e, ", "))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e UnverifiableCommitsError) Code() string {
+	return ErrCodeUnverifiableCommits
+}
+
 // GetCommit opens the repository at repoPath and returns the commit object that
 // ref resolves to.
+//
+// Deprecated: Use a Verifier, which resolves refs in the repository it opens.
 func GetCommit(repoPath string, ref string) (*object.Commit, error) {
 	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
+	return resolveCommit(repo, ref, "ref")
+}
 
+// resolveCommit returns the commit that ref resolves to. name describes the
+// ref in errors.
+func resolveCommit(repo *git.Repository, ref, name string) (*object.Commit, error) {
 	h, err := repo.ResolveRevision(plumbing.Revision(ref))
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve ref: %w", err)
+		// Commits beyond the depth of a shallow clone are not fetched at all.
+		if shallow, _ := repo.Storer.Shallow(); len(shallow) > 0 {
+			return nil, fmt.Errorf("failed to resolve %s %q in shallow clone, it may not have been fetched: %w", name, ref, err)
+		}
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
 	}
 
 	commit, err := repo.CommitObject(*h)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open commit: %w", err)
 	}
-
 	return commit, nil
 }
 
+// readFileAtRef returns the content of the file at filePath in the commit that
+// ref resolves to, and the hash of the commit. The file is read from the object
+// store, never from the working tree.
+func readFileAtRef(repo *git.Repository, ref, filePath string) ([]byte, plumbing.Hash, error) {
+	commit, err := resolveCommit(repo, ref, "ref")
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	f, err := commit.File(filePath)
+	if err != nil {
+		return nil, commit.Hash, fmt.Errorf("failed to find '%s' in commit %s: %w", filePath, commit.Hash, err)
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, commit.Hash, fmt.Errorf("failed to read '%s' in commit %s: %w", filePath, commit.Hash, err)
+	}
+	return []byte(contents), commit.Hash, nil
+}
+
+// listCommits returns the commits that are reachable from headRef but not from
+// baseRef, parents before children.
+//
+// In a shallow clone, the history ends at commits whose parents are missing.
+// The hashes of those commits in the range are returned too, as their missing
+// ancestors cannot be verified.
+func listCommits(repo *git.Repository, baseRef, headRef string) ([]*object.Commit, []plumbing.Hash, error) {
+	baseCommit, err := resolveCommit(repo, baseRef, "base ref")
+	if err != nil {
+		return nil, nil, err
+	}
+	headCommit, err := resolveCommit(repo, headRef, "ref")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+	if _, err := walkCommits(baseCommit, excluded, func(*object.Commit) {}); err != nil {
+		return nil, nil, fmt.Errorf("failed to walk base history: %w", err)
+	}
+
+	commits := []*object.Commit{}
+	cut, err := walkCommits(headCommit, excluded, func(c *object.Commit) {
+		commits = append(commits, c)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to walk history: %w", err)
+	}
+
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return sortParentsFirst(commits), cut, nil
+}
+
+// ListMergedCommits returns the commits that a merge commit brings in through
+// its non-first parents, i.e. the commits reachable from them but not from the
+// first parent, parents before children. Returns nil if the commit is not a
+// merge commit. Commits whose parents are missing from a shallow clone are
+// returned as in listCommits, including the merge commit itself if a merged
+// parent is missing.
+//
+// Like `git rev-list`, the history is walked newest first by committer date,
+// and the walk stops once only ancestors of the first parent are left, i.e. at
+// the merge bases of the first and the merged parents, instead of walking the
+// whole history of the first parent. A commit dated before its ancestors can
+// stop the walk early, in which case commits that are already in the history
+// of the first parent may be returned too, but no merged commit is left out.
+func ListMergedCommits(commit *object.Commit) ([]*object.Commit, []plumbing.Hash, error) {
+	if commit.NumParents() < 2 {
+		return nil, nil, nil
+	}
+
+	// excluded are the ancestors of the first parent found so far.
+	excluded := map[plumbing.Hash]bool{}
+	visited := map[plumbing.Hash]*object.Commit{}
+	queue := &commitQueue{}
+	first, err := commit.Parent(0)
+	switch {
+	case errors.Is(err, plumbing.ErrObjectNotFound):
+		// Without the first parent, every merged commit is considered new.
+	case err != nil:
+		return nil, nil, fmt.Errorf("failed to open the first parent of commit %s: %w", commit.Hash, err)
+	default:
+		excluded[first.Hash] = true
+		heap.Push(queue, first)
+	}
+
+	// cut are the commits with missing parents, and whether they are.
+	cut := []plumbing.Hash{}
+	isCut := map[plumbing.Hash]bool{}
+	for i := 1; i < commit.NumParents(); i++ {
+		parent, err := commit.Parent(i)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			if !isCut[commit.Hash] {
+				isCut[commit.Hash] = true
+				cut = append(cut, commit.Hash)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open parent of commit %s: %w", commit.Hash, err)
+		}
+		heap.Push(queue, parent)
+	}
+
+	walked := []*object.Commit{}
+	for queue.Len() > 0 && !queue.allExcluded(excluded) {
+		c := heap.Pop(queue).(*object.Commit)
+		if _, ok := visited[c.Hash]; ok {
+			continue
+		}
+		visited[c.Hash] = c
+		if !excluded[c.Hash] {
+			walked = append(walked, c)
+		}
+
+		for i, h := range c.ParentHashes {
+			if excluded[c.Hash] {
+				excludeAncestors(h, visited, excluded)
+			}
+			if _, ok := visited[h]; ok {
+				continue
+			}
+			parent, err := c.Parent(i)
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				if !isCut[c.Hash] {
+					isCut[c.Hash] = true
+					cut = append(cut, c.Hash)
+				}
+				continue
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to walk merged history of commit %s: %w", commit.Hash, err)
+			}
+			heap.Push(queue, parent)
+		}
+	}
+
+	// Commits found to be ancestors of the first parent after they were
+	// walked are dropped.
+	commits := []*object.Commit{}
+	for i := len(walked) - 1; i >= 0; i-- {
+		if !excluded[walked[i].Hash] {
+			commits = append(commits, walked[i])
+		}
+	}
+	unverifiable := []plumbing.Hash{}
+	for _, h := range cut {
+		if !excluded[h] {
+			unverifiable = append(unverifiable, h)
+		}
+	}
+	return sortParentsFirst(commits), unverifiable, nil
+}
+
+// excludeAncestors adds h to excluded, together with its ancestors that were
+// already visited.
+func excludeAncestors(h plumbing.Hash, visited map[plumbing.Hash]*object.Commit, excluded map[plumbing.Hash]bool) {
+	stack := []plumbing.Hash{h}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if excluded[h] {
+			continue
+		}
+		excluded[h] = true
+		if c, ok := visited[h]; ok {
+			stack = append(stack, c.ParentHashes...)
+		}
+	}
+}
+
+// commitQueue is a heap of commits, newest first by committer date.
+type commitQueue []*object.Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if !q[i].Committer.When.Equal(q[j].Committer.When) {
+		return q[i].Committer.When.After(q[j].Committer.When)
+	}
+	return q[i].Hash.String() < q[j].Hash.String()
+}
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(*object.Commit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// allExcluded checks if all commits in the queue are excluded, so that walking
+// on can only find more excluded commits.
+func (q commitQueue) allExcluded(excluded map[plumbing.Hash]bool) bool {
+	for _, c := range q {
+		if !excluded[c.Hash] {
+			return false
+		}
+	}
+	return true
+}
+
+// sortParentsFirst sorts the commits topologically with Kahn's algorithm, so
+// that every commit is listed after those of its parents that are in commits.
+// Commits whose order is not set by their ancestry are listed in the order they
+// are given, breadth-first.
+func sortParentsFirst(commits []*object.Commit) []*object.Commit {
+	indexes := make(map[plumbing.Hash]int, len(commits))
+	for i, c := range commits {
+		indexes[c.Hash] = i
+	}
+
+	// pending counts the parents of each commit that are not listed yet.
+	pending := make([]int, len(commits))
+	children := make([][]int, len(commits))
+	for i, c := range commits {
+		for _, h := range c.ParentHashes {
+			if j, ok := indexes[h]; ok {
+				pending[i]++
+				children[j] = append(children[j], i)
+			}
+		}
+	}
+
+	queue := []int{}
+	for i := range commits {
+		if pending[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	sorted := make([]*object.Commit, 0, len(commits))
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		sorted = append(sorted, commits[i])
+		for _, j := range children[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				queue = append(queue, j)
+			}
+		}
+	}
+	return sorted
+}
+
+// walkCommits calls fn for start and its ancestors that are not in seen, in
+// depth-first preorder following first parents first, and adds them to seen.
+// Parents that are missing from the repository, as in a shallow clone, are
+// added to seen but not followed, and the hashes of the walked commits with
+// missing parents are returned.
+func walkCommits(start *object.Commit, seen map[plumbing.Hash]bool, fn func(*object.Commit)) ([]plumbing.Hash, error) {
+	cut := []plumbing.Hash{}
+	stack := []*object.Commit{start}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[c.Hash] {
+			continue
+		}
+		seen[c.Hash] = true
+		fn(c)
+
+		for i := c.NumParents() - 1; i >= 0; i-- {
+			h := c.ParentHashes[i]
+			if seen[h] {
+				continue
+			}
+			parent, err := c.Parent(i)
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				seen[h] = true
+				if len(cut) == 0 || cut[len(cut)-1] != c.Hash {
+					cut = append(cut, c.Hash)
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, parent)
+		}
+	}
+	return cut, nil
+}
+
 // PrettyPrintCommit returns a full representation of the commit object.
 func PrettyPrintCommit(commit *object.Commit) string {
 	encoded := &plumbing.MemoryObject{}
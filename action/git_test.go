@@ -0,0 +1,362 @@
+package action
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testRepo is a git repository on disk whose commits are written directly to
+// the object store.
+type testRepo struct {
+	*git.Repository
+	Path string
+
+	t    *testing.T
+	tree plumbing.Hash
+	n    int
+}
+
+// testCommitOptions describe a commit written by testRepo.commit.
+type testCommitOptions struct {
+	Message string
+	Email   string
+	// Signer signs the commit, if set.
+	Signer  *openpgp.Entity
+	Parents []plumbing.Hash
+	// Files are the contents of the files in the root of the tree of the
+	// commit, if set. Otherwise the tree is empty.
+	Files map[string]string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+
+	path := t.TempDir()
+	repo, err := git.PlainInit(path, false)
+	if err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+
+	// All commits share the empty tree.
+	obj := repo.Storer.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(obj); err != nil {
+		t.Fatalf("failed to encode tree: %v", err)
+	}
+	tree, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatalf("failed to store tree: %v", err)
+	}
+
+	return &testRepo{Repository: repo, Path: path, t: t, tree: tree}
+}
+
+// commit writes a commit and points the branch main at it.
+func (r *testRepo) commit(opts testCommitOptions) plumbing.Hash {
+	r.t.Helper()
+
+	r.n++
+	if opts.Email == "" {
+		opts.Email = "jane@doe.com"
+	}
+	if opts.Message == "" {
+		opts.Message = "Commit " + strings.Repeat("I", r.n) + "\n"
+	}
+	when := time.Date(2022, 9, 5, 13, 58, 12, 0, time.UTC).Add(time.Duration(r.n) * time.Minute)
+	tree := r.tree
+	if opts.Files != nil {
+		tree = r.writeTree(opts.Files)
+	}
+	commit := &object.Commit{
+		Author:       object.Signature{Name: "Jane Doe", Email: opts.Email, When: when},
+		Committer:    object.Signature{Name: "Jane Doe", Email: opts.Email, When: when},
+		Message:      opts.Message,
+		TreeHash:     tree,
+		ParentHashes: opts.Parents,
+	}
+
+	if opts.Signer != nil {
+		payload, err := EncodedCommitWithoutSignature(commit)
+		if err != nil {
+			r.t.Fatalf("failed to encode commit: %v", err)
+		}
+		sig := &strings.Builder{}
+		if err := openpgp.ArmoredDetachSign(sig, opts.Signer, strings.NewReader(payload), nil); err != nil {
+			r.t.Fatalf("failed to sign commit: %v", err)
+		}
+		commit.PGPSignature = sig.String()
+	}
+
+	obj := r.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		r.t.Fatalf("failed to encode commit: %v", err)
+	}
+	h, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		r.t.Fatalf("failed to store commit: %v", err)
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", h)); err != nil {
+		r.t.Fatalf("failed to set reference: %v", err)
+	}
+	return h
+}
+
+// writeTree writes the files and a tree containing them.
+func (r *testRepo) writeTree(files map[string]string) plumbing.Hash {
+	r.t.Helper()
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tree := &object.Tree{}
+	for _, name := range names {
+		obj := r.Storer.NewEncodedObject()
+		obj.SetType(plumbing.BlobObject)
+		w, err := obj.Writer()
+		if err != nil {
+			r.t.Fatalf("failed to write blob: %v", err)
+		}
+		if _, err := w.Write([]byte(files[name])); err != nil {
+			r.t.Fatalf("failed to write blob: %v", err)
+		}
+		w.Close()
+		h, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			r.t.Fatalf("failed to store blob: %v", err)
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: h})
+	}
+
+	obj := r.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		r.t.Fatalf("failed to encode tree: %v", err)
+	}
+	h, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		r.t.Fatalf("failed to store tree: %v", err)
+	}
+	return h
+}
+
+func TestListCommits(t *testing.T) {
+	r := newTestRepo(t)
+	root := r.commit(testCommitOptions{})
+	base := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	side := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	feature := r.commit(testCommitOptions{Parents: []plumbing.Hash{base}})
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{feature, side}})
+
+	commits, missing, err := listCommits(r.Repository, base.String(), merge.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected no missing commits, got %v", missing)
+	}
+
+	hashes := []plumbing.Hash{}
+	for _, c := range commits {
+		hashes = append(hashes, c.Hash)
+	}
+	expected := []plumbing.Hash{side, feature, merge}
+	if len(hashes) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, hashes)
+	}
+	for i := range expected {
+		if hashes[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, hashes)
+			break
+		}
+	}
+}
+
+func TestListMergedCommits(t *testing.T) {
+	r := newTestRepo(t)
+	root := r.commit(testCommitOptions{})
+	main := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	feature1 := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	feature2 := r.commit(testCommitOptions{Parents: []plumbing.Hash{feature1}})
+	other := r.commit(testCommitOptions{Parents: []plumbing.Hash{feature1}})
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{main, feature2, other}})
+
+	c, err := r.CommitObject(merge)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	commits, _, err := ListMergedCommits(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hashes := map[plumbing.Hash]bool{}
+	for _, c := range commits {
+		hashes[c.Hash] = true
+	}
+	if len(commits) != 3 || !hashes[feature1] || !hashes[feature2] || !hashes[other] {
+		t.Errorf("expected feature1, feature2 and other once, got %v", commits)
+	}
+	if commits[0].Hash != feature1 || commits[1].Hash != feature2 || commits[2].Hash != other {
+		t.Errorf("expected parents before children, got %v", commits)
+	}
+}
+
+func TestListMergedCommitsStopsAtMergeBase(t *testing.T) {
+	r := newTestRepo(t)
+	// The parent of the oldest commit is a corrupt object, so walking it fails.
+	corrupt := plumbing.NewHash(strings.Repeat("f", 40))
+	if err := os.MkdirAll(filepath.Join(r.Path, ".git", "objects", "ff"), 0o755); err != nil {
+		t.Fatalf("failed to create object directory: %v", err)
+	}
+	writeTestFile(t, filepath.Join(r.Path, ".git", "objects", "ff", strings.Repeat("f", 38)), "corrupt")
+	broken := r.commit(testCommitOptions{Parents: []plumbing.Hash{corrupt}})
+	root := r.commit(testCommitOptions{Parents: []plumbing.Hash{broken}})
+	base := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	main := r.commit(testCommitOptions{Parents: []plumbing.Hash{base}})
+	// side is only reachable from root through the merged branch, and is
+	// walked before it is known to be merged already through base.
+	side := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	feature := r.commit(testCommitOptions{Parents: []plumbing.Hash{side, base}})
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{main, feature}})
+
+	c, err := r.CommitObject(merge)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	commits, missing, err := ListMergedCommits(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected no missing commits, got %v", missing)
+	}
+	if len(commits) != 2 || commits[0].Hash != side || commits[1].Hash != feature {
+		t.Errorf("expected side and feature, got %v", commits)
+	}
+}
+
+// assertParentsFirst checks that every commit is listed after those of its
+// parents that are listed.
+func assertParentsFirst(t *testing.T, commits []*object.Commit) {
+	t.Helper()
+
+	listed := map[plumbing.Hash]bool{}
+	for _, c := range commits {
+		listed[c.Hash] = true
+	}
+	seen := map[plumbing.Hash]bool{}
+	for _, c := range commits {
+		for _, p := range c.ParentHashes {
+			if listed[p] && !seen[p] {
+				t.Errorf("expected parent %s before commit %s, got %v", p, c.Hash, commits)
+			}
+		}
+		seen[c.Hash] = true
+	}
+}
+
+func TestListCommitsCrissCrossMerge(t *testing.T) {
+	r := newTestRepo(t)
+	root := r.commit(testCommitOptions{})
+	left := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	right := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	// Each branch merges the other one, so neither merge is an ancestor of the
+	// other, and both are reachable through the first parents of head.
+	leftMerge := r.commit(testCommitOptions{Parents: []plumbing.Hash{left, right}})
+	rightMerge := r.commit(testCommitOptions{Parents: []plumbing.Hash{right, left}})
+	head := r.commit(testCommitOptions{Parents: []plumbing.Hash{leftMerge, rightMerge}})
+
+	commits, _, err := listCommits(r.Repository, root.String(), head.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commits) != 5 {
+		t.Fatalf("expected 5 commits, got %v", commits)
+	}
+	assertParentsFirst(t, commits)
+
+	main := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}})
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{main, head}})
+	c, err := r.CommitObject(merge)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	merged, _, err := ListMergedCommits(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(merged) != 5 {
+		t.Fatalf("expected 5 merged commits, got %v", merged)
+	}
+	assertParentsFirst(t, merged)
+}
+
+// shallowCommit writes a commit whose parent is missing, as at the boundary of
+// a shallow clone, and lists it in .git/shallow. Returns the commit and the
+// hash of its missing parent. The commit is signed by signer, if set.
+func (r *testRepo) shallowCommit(signer *openpgp.Entity) (plumbing.Hash, plumbing.Hash) {
+	r.t.Helper()
+
+	missing := plumbing.NewHash(fmt.Sprintf("%040x", 0xdead0000+r.n))
+	h := r.commit(testCommitOptions{Signer: signer, Parents: []plumbing.Hash{missing}})
+	shallow, err := r.Storer.Shallow()
+	if err != nil {
+		r.t.Fatalf("failed to read shallow commits: %v", err)
+	}
+	if err := r.Storer.SetShallow(append(shallow, h)); err != nil {
+		r.t.Fatalf("failed to write shallow commits: %v", err)
+	}
+	return h, missing
+}
+
+func TestListCommitsShallow(t *testing.T) {
+	r := newTestRepo(t)
+	boundary, missing := r.shallowCommit(nil)
+	base := r.commit(testCommitOptions{Parents: []plumbing.Hash{boundary}})
+	side, _ := r.shallowCommit(nil)
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{base, side}})
+
+	// The base, whose parent is missing too, is not in the range.
+	commits, unverifiable, err := listCommits(r.Repository, base.String(), merge.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Hash != side || commits[1].Hash != merge {
+		t.Errorf("expected side and merge, got %v", commits)
+	}
+	if len(unverifiable) != 1 || unverifiable[0] != side {
+		t.Errorf("expected %s to be unverifiable, got %v", side, unverifiable)
+	}
+
+	c, err := r.CommitObject(merge)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	merged, unverifiable, err := ListMergedCommits(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(merged) != 1 || merged[0].Hash != side {
+		t.Errorf("expected side, got %v", merged)
+	}
+	if len(unverifiable) != 1 || unverifiable[0] != side {
+		t.Errorf("expected %s to be unverifiable, got %v", side, unverifiable)
+	}
+
+	_, err = resolveCommit(r.Repository, missing.String(), "ref")
+	if err == nil || !strings.Contains(err.Error(), "in shallow clone") {
+		t.Errorf("expected shallow clone error, got %v", err)
+	}
+}
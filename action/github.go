@@ -0,0 +1,145 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrCodeSyntheticMergeCommit is the OutcomeError code of a commit that is the
+// merge commit a CI platform created to test a pull request, rather than a
+// commit of the pull request itself.
+const ErrCodeSyntheticMergeCommit = "SYNTHETIC_MERGE_COMMIT"
+
+// zeroSHA is the commit SHA of a push event that creates a branch.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// CIEvent describes the commits of the CI event that triggered the action.
+type CIEvent struct {
+	// Name is the name of the event (e.g. "pull_request").
+	Name string
+	// Repository is the full name of the repository (e.g.
+	// "gobeyondidentity/auth-commit-sig").
+	Repository string
+	// BaseSHA is the commit the changes are based on, if any.
+	BaseSHA string
+	// HeadSHA is the latest commit of the changes.
+	HeadSHA string
+	// MergeCommitSHA is the merge commit that the CI platform created to test a
+	// pull request, if any. It must not be verified in place of HeadSHA.
+	MergeCommitSHA string
+}
+
+// githubEvent contains the fields used from the payload of the GitHub
+// pull_request, push and merge_group events.
+type githubEvent struct {
+	PullRequest *struct {
+		Base struct {
+			SHA string `json:"sha"`
+		} `json:"base"`
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	MergeGroup *struct {
+		BaseSHA string `json:"base_sha"`
+		HeadSHA string `json:"head_sha"`
+	} `json:"merge_group"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// UnsupportedEventError is returned by LoadGitHubEvent for events that it
+// cannot derive commits from.
+type UnsupportedEventError string
+
+func (e UnsupportedEventError) Error() string {
+	return fmt.Sprintf("unsupported event: %q", string(e))
+}
+
+// SyntheticMergeCommitError is returned when the commit to verify is the
+// merge commit a CI platform created to test a pull request.
+type SyntheticMergeCommitError string
+
+func (e SyntheticMergeCommitError) Error() string {
+	return fmt.Sprintf("commit %s is the merge commit created by the CI platform for the pull request, check out the head commit of the pull request instead", string(e))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e SyntheticMergeCommitError) Code() string {
+	return ErrCodeSyntheticMergeCommit
+}
+
+// LoadGitHubEvent reads the payload of a GitHub Actions event from eventPath
+// (GITHUB_EVENT_PATH). sha is the commit that the workflow runs on
+// (GITHUB_SHA), which is the synthetic merge commit for pull_request events.
+func LoadGitHubEvent(eventName, eventPath, sha string) (*CIEvent, error) {
+	switch eventName {
+	case "pull_request", "pull_request_target", "push", "merge_group":
+	default:
+		return nil, UnsupportedEventError(eventName)
+	}
+
+	bs, err := ioutil.ReadFile(eventPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event payload at '%s': %w", eventPath, err)
+	}
+	payload := githubEvent{}
+	if err := json.Unmarshal(bs, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse event payload at '%s': %w", eventPath, err)
+	}
+
+	e := &CIEvent{Name: eventName, Repository: payload.Repository.FullName}
+	switch eventName {
+	case "pull_request", "pull_request_target":
+		if payload.PullRequest == nil {
+			return nil, fmt.Errorf("%s event payload has no pull_request", eventName)
+		}
+		e.BaseSHA = payload.PullRequest.Base.SHA
+		e.HeadSHA = payload.PullRequest.Head.SHA
+		// For pull_request events, GITHUB_SHA is the merge commit of
+		// refs/pull/<n>/merge. For pull_request_target, it is the base branch.
+		if eventName == "pull_request" && sha != e.HeadSHA {
+			e.MergeCommitSHA = sha
+		}
+	case "push":
+		e.HeadSHA = payload.After
+		if payload.Before != zeroSHA {
+			e.BaseSHA = payload.Before
+		}
+	case "merge_group":
+		if payload.MergeGroup == nil {
+			return nil, fmt.Errorf("merge_group event payload has no merge_group")
+		}
+		e.BaseSHA = payload.MergeGroup.BaseSHA
+		e.HeadSHA = payload.MergeGroup.HeadSHA
+	}
+
+	if e.HeadSHA == "" || e.HeadSHA == zeroSHA {
+		return nil, fmt.Errorf("%s event payload has no head commit", eventName)
+	}
+	return e, nil
+}
+
+// Apply configures cfg to verify the commits of the event. Repository is only
+// set if it is empty, and CommitRef only if it is empty or "HEAD", which may be
+// the synthetic merge commit. If verifyRange is set and no BaseRef is
+// configured, all commits since BaseSHA are verified.
+func (e *CIEvent) Apply(cfg *Config, verifyRange bool) {
+	if cfg.Repository == "" {
+		cfg.Repository = e.Repository
+	}
+	if cfg.CommitRef == "" || strings.EqualFold(cfg.CommitRef, "HEAD") {
+		cfg.CommitRef = e.HeadSHA
+	}
+	if verifyRange && cfg.BaseRef == "" {
+		cfg.BaseRef = e.BaseSHA
+	}
+	if cfg.SyntheticMergeCommit == "" {
+		cfg.SyntheticMergeCommit = e.MergeCommitSHA
+	}
+}
@@ -0,0 +1,121 @@
+package action
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestLoadGitHubEvent(t *testing.T) {
+	const (
+		base  = "1111111111111111111111111111111111111111"
+		head  = "2222222222222222222222222222222222222222"
+		merge = "3333333333333333333333333333333333333333"
+	)
+
+	tests := []struct {
+		name        string
+		eventName   string
+		payload     string
+		sha         string
+		expected    CIEvent
+		expectedErr string
+	}{
+		{
+			name:      "pull_request",
+			eventName: "pull_request",
+			payload:   `{"pull_request": {"base": {"sha": "` + base + `"}, "head": {"sha": "` + head + `"}}, "repository": {"full_name": "org/repo"}}`,
+			sha:       merge,
+			expected:  CIEvent{Name: "pull_request", Repository: "org/repo", BaseSHA: base, HeadSHA: head, MergeCommitSHA: merge},
+		},
+		{
+			name:      "pull_request_target",
+			eventName: "pull_request_target",
+			payload:   `{"pull_request": {"base": {"sha": "` + base + `"}, "head": {"sha": "` + head + `"}}, "repository": {"full_name": "org/repo"}}`,
+			sha:       base,
+			expected:  CIEvent{Name: "pull_request_target", Repository: "org/repo", BaseSHA: base, HeadSHA: head},
+		},
+		{
+			name:      "push",
+			eventName: "push",
+			payload:   `{"before": "` + base + `", "after": "` + head + `", "repository": {"full_name": "org/repo"}}`,
+			sha:       head,
+			expected:  CIEvent{Name: "push", Repository: "org/repo", BaseSHA: base, HeadSHA: head},
+		},
+		{
+			name:      "push_new_branch",
+			eventName: "push",
+			payload:   `{"before": "` + zeroSHA + `", "after": "` + head + `", "repository": {"full_name": "org/repo"}}`,
+			sha:       head,
+			expected:  CIEvent{Name: "push", Repository: "org/repo", HeadSHA: head},
+		},
+		{
+			name:      "merge_group",
+			eventName: "merge_group",
+			payload:   `{"merge_group": {"base_sha": "` + base + `", "head_sha": "` + head + `"}, "repository": {"full_name": "org/repo"}}`,
+			sha:       head,
+			expected:  CIEvent{Name: "merge_group", Repository: "org/repo", BaseSHA: base, HeadSHA: head},
+		},
+		{
+			name:        "push_branch_deleted",
+			eventName:   "push",
+			payload:     `{"before": "` + base + `", "after": "` + zeroSHA + `"}`,
+			expectedErr: "push event payload has no head commit",
+		},
+		{
+			name:        "unsupported",
+			eventName:   "issue_comment",
+			payload:     `{}`,
+			expectedErr: `unsupported event: "issue_comment"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "event.json")
+			writeTestFile(t, path, tt.payload)
+
+			e, err := LoadGitHubEvent(tt.eventName, path, tt.sha)
+			assertEqualErr(t, tt.expectedErr, err)
+			if err == nil && *e != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *e)
+			}
+		})
+	}
+}
+
+func TestCIEventApply(t *testing.T) {
+	e := &CIEvent{Repository: "org/repo", BaseSHA: "base", HeadSHA: "head", MergeCommitSHA: "merge"}
+
+	cfg := Config{CommitRef: "HEAD"}
+	e.Apply(&cfg, false)
+	if cfg.Repository != "org/repo" || cfg.CommitRef != "head" || cfg.BaseRef != "" || cfg.SyntheticMergeCommit != "merge" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	cfg = Config{Repository: "other/repo", CommitRef: "refs/heads/main"}
+	e.Apply(&cfg, true)
+	if cfg.Repository != "other/repo" || cfg.CommitRef != "refs/heads/main" || cfg.BaseRef != "base" {
+		t.Errorf("expected explicit settings to be kept, got %+v", cfg)
+	}
+}
+
+func TestRunSyntheticMergeCommit(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	f := newFakeAPIServer(t, false)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{})
+	head := r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{base}})
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{base, head}})
+
+	cfg := newTestRunConfig(r, f)
+	cfg.CommitRef = merge.String()
+	cfg.SyntheticMergeCommit = merge.String()
+
+	o := Run(context.Background(), cfg)
+	if o.Result != FAIL || len(o.Errors) != 1 || o.Errors[0].Code != ErrCodeSyntheticMergeCommit {
+		t.Errorf("expected FAIL with code %s, got %s: %+v", ErrCodeSyntheticMergeCommit, o.Result, o.Errors)
+	}
+}
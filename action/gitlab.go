@@ -0,0 +1,132 @@
+package action
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// LoadGitLabEvent derives the commits of a GitLab CI pipeline from its
+// predefined variables, read with getenv (e.g. os.Getenv).
+func LoadGitLabEvent(getenv func(string) string) (*CIEvent, error) {
+	e := &CIEvent{
+		Name:       getenv("CI_PIPELINE_SOURCE"),
+		Repository: getenv("CI_PROJECT_PATH"),
+		HeadSHA:    getenv("CI_COMMIT_SHA"),
+	}
+
+	if getenv("CI_MERGE_REQUEST_IID") != "" {
+		e.BaseSHA = getenv("CI_MERGE_REQUEST_DIFF_BASE_SHA")
+		// Merged results pipelines run on a merge commit of the source and
+		// target branches, and only then CI_MERGE_REQUEST_SOURCE_BRANCH_SHA is
+		// set.
+		if head := getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_SHA"); head != "" && head != e.HeadSHA {
+			e.MergeCommitSHA = e.HeadSHA
+			e.HeadSHA = head
+		}
+	} else if before := getenv("CI_COMMIT_BEFORE_SHA"); before != zeroSHA {
+		e.BaseSHA = before
+	}
+
+	if e.HeadSHA == "" {
+		return nil, fmt.Errorf("CI_COMMIT_SHA is not set")
+	}
+	return e, nil
+}
+
+// GitLabCodeQualityIssue is an issue of a GitLab code quality report.
+// See https://docs.gitlab.com/ee/ci/testing/code_quality.html.
+type GitLabCodeQualityIssue struct {
+	Description string                    `json:"description"`
+	CheckName   string                    `json:"check_name"`
+	Fingerprint string                    `json:"fingerprint"`
+	Severity    string                    `json:"severity"`
+	Location    GitLabCodeQualityLocation `json:"location"`
+}
+
+// GitLabCodeQualityLocation is the location of a GitLab code quality issue.
+type GitLabCodeQualityLocation struct {
+	Path  string `json:"path"`
+	Lines struct {
+		Begin int `json:"begin"`
+	} `json:"lines"`
+}
+
+// NewGitLabCodeQualityReport returns an issue for every commit of the outcome
+// that failed verification. Issues are reported on the root of the repository,
+// as they do not belong to a file.
+func NewGitLabCodeQualityReport(o *Outcome) []GitLabCodeQualityIssue {
+	issues := []GitLabCodeQualityIssue{}
+	for _, co := range failedOutcomes(o) {
+		commitHash := ""
+		if co.Commit != nil {
+			commitHash = co.Commit.CommitHash
+		}
+		desc := co.Desc
+		for _, e := range co.Errors {
+			desc += " " + e.Desc
+		}
+		if commitHash != "" {
+			desc = fmt.Sprintf("Commit %s: %s", commitHash, desc)
+		}
+
+		checkName := "auth-commit-sig"
+		if len(co.Errors) > 0 && co.Errors[0].Code != "" {
+			checkName += "/" + co.Errors[0].Code
+		}
+		severity := "blocker"
+		if co.Result == WARN {
+			severity = "minor"
+		}
+		fingerprint := sha256.Sum256([]byte(o.Repository + "\x00" + commitHash + "\x00" + checkName))
+
+		issue := GitLabCodeQualityIssue{
+			Description: desc,
+			CheckName:   checkName,
+			Fingerprint: hex.EncodeToString(fingerprint[:]),
+			Severity:    severity,
+			Location:    GitLabCodeQualityLocation{Path: "."},
+		}
+		issue.Location.Lines.Begin = 1
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// WriteGitLabCodeQualityReport writes the code quality report of the outcome
+// to filePath, to be uploaded as the artifacts:reports:codequality of the job.
+func WriteGitLabCodeQualityReport(filePath string, o *Outcome) error {
+	bs, err := json.MarshalIndent(NewGitLabCodeQualityReport(o), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode code quality report: %w", err)
+	}
+	return writeFileAtomic(filePath, bs)
+}
+
+// failedOutcomes returns the outcomes of the commits of a range that failed,
+// or the outcome itself if it failed and is not a range. Outcomes with the
+// result WARN failed with report-only enforcement.
+func failedOutcomes(o *Outcome) []*Outcome {
+	failed := []*Outcome{}
+	isFailed := func(o *Outcome) bool {
+		return o.Result == FAIL || o.Result == WARN
+	}
+	if len(o.Commits) == 0 {
+		if isFailed(o) {
+			failed = append(failed, o)
+		}
+		return failed
+	}
+	for _, co := range o.Commits {
+		if isFailed(co) {
+			failed = append(failed, co)
+		}
+	}
+	// The range failed before any commit failed, e.g. the allowlist could not
+	// be read.
+	if len(failed) == 0 && isFailed(o) {
+		failed = append(failed, o)
+	}
+	return failed
+}
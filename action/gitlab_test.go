@@ -0,0 +1,101 @@
+package action
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testEnv returns a getenv function that looks up variables in env.
+func testEnv(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func TestLoadGitLabEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected CIEvent
+	}{
+		{
+			name: "merge_request",
+			env: map[string]string{
+				"CI_PIPELINE_SOURCE":             "merge_request_event",
+				"CI_PROJECT_PATH":                "group/project",
+				"CI_COMMIT_SHA":                  "head",
+				"CI_MERGE_REQUEST_IID":           "7",
+				"CI_MERGE_REQUEST_DIFF_BASE_SHA": "base",
+				"CI_COMMIT_BEFORE_SHA":           zeroSHA,
+			},
+			expected: CIEvent{Name: "merge_request_event", Repository: "group/project", BaseSHA: "base", HeadSHA: "head"},
+		},
+		{
+			name: "merged_results",
+			env: map[string]string{
+				"CI_PIPELINE_SOURCE":                 "merge_request_event",
+				"CI_PROJECT_PATH":                    "group/project",
+				"CI_COMMIT_SHA":                      "merge",
+				"CI_MERGE_REQUEST_IID":               "7",
+				"CI_MERGE_REQUEST_DIFF_BASE_SHA":     "base",
+				"CI_MERGE_REQUEST_SOURCE_BRANCH_SHA": "head",
+			},
+			expected: CIEvent{Name: "merge_request_event", Repository: "group/project", BaseSHA: "base", HeadSHA: "head", MergeCommitSHA: "merge"},
+		},
+		{
+			name: "push",
+			env: map[string]string{
+				"CI_PIPELINE_SOURCE":   "push",
+				"CI_PROJECT_PATH":      "group/project",
+				"CI_COMMIT_SHA":        "head",
+				"CI_COMMIT_BEFORE_SHA": "base",
+			},
+			expected: CIEvent{Name: "push", Repository: "group/project", BaseSHA: "base", HeadSHA: "head"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := LoadGitLabEvent(testEnv(tt.env))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *e != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *e)
+			}
+		})
+	}
+
+	_, err := LoadGitLabEvent(testEnv(nil))
+	assertEqualErr(t, "CI_COMMIT_SHA is not set", err)
+}
+
+func TestWriteGitLabCodeQualityReport(t *testing.T) {
+	o := &Outcome{
+		Repository: "group/project",
+		Result:     FAIL,
+		Commits: []*Outcome{
+			{Result: PASS, Commit: &Commit{CommitHash: "aaaa"}},
+			{Result: FAIL, Desc: "Commit is not signed.", Commit: &Commit{CommitHash: "bbbb"}, Errors: []OutcomeError{{Code: ErrCodeCryptoPolicy, Desc: "weak"}}},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "gl-code-quality-report.json")
+	if err := WriteGitLabCodeQualityReport(path, o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	issues := []GitLabCodeQualityIssue{}
+	if err := json.Unmarshal(bs, &issues); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(issues) != 1 {
+		t.Fatalf("expected 1 issue, got %d", len(issues))
+	}
+	if issues[0].Description != "Commit bbbb: Commit is not signed. weak" || issues[0].CheckName != "auth-commit-sig/"+ErrCodeCryptoPolicy || issues[0].Severity != "blocker" {
+		t.Errorf("unexpected issue %+v", issues[0])
+	}
+}
@@ -0,0 +1,398 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// keyLookupTimeout bounds each request made to a keyserver or Web Key
+// Directory.
+const keyLookupTimeout = 30 * time.Second
+
+// maxKeyResponseSize bounds the size of a keyserver or Web Key Directory
+// response, so that a misbehaving server cannot exhaust memory.
+const maxKeyResponseSize = 1 << 20
+
+// ErrKeyNotFound is returned by a KeySource when it has no key for the
+// requested fingerprint.
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeySource resolves pinned fingerprints to public keys.
+type KeySource interface {
+	// KeyByFingerprint returns the entity whose primary key has the hex
+	// encoded fingerprint. emailAddress is the email address of a user ID on
+	// the key, if known, and is used by sources that look keys up by email.
+	KeyByFingerprint(ctx context.Context, fingerprint, emailAddress string) (*openpgp.Entity, error)
+}
+
+// newKeySource builds the KeySource for third party keys pinned by fingerprint
+// from the Config. Sources are tried in order: the keyring file, the HKP
+// keyserver and the Web Key Directory. Keys are looked up with client, and
+// cache failures logged to logger. Returns nil if no source is configured.
+func newKeySource(cfg Config, client *http.Client, logger Logger) (KeySource, error) {
+	var sources MultiKeySource
+	if cfg.ThirdPartyKeyRingFilePath != "" {
+		keyRing, err := LoadKeyRingFile(cfg.ThirdPartyKeyRingFilePath)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, KeyRingKeySource{KeyRing: keyRing})
+	}
+
+	if cfg.KeyserverURL != "" {
+		sources = append(sources, newCachingKeySource(HKPKeySource{HTTPClient: client, BaseURL: cfg.KeyserverURL}, cfg, logger))
+	}
+	if cfg.WKDLookup {
+		sources = append(sources, newCachingKeySource(WKDKeySource{HTTPClient: client, BaseURL: cfg.WKDBaseURL}, cfg, logger))
+	}
+
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	return sources, nil
+}
+
+// newCachingKeySource wraps the source in a CachingKeySource if a key cache
+// directory is configured.
+func newCachingKeySource(source KeySource, cfg Config, logger Logger) KeySource {
+	if cfg.KeyCacheDir == "" {
+		return source
+	}
+	return CachingKeySource{Source: source, Dir: cfg.KeyCacheDir, TTL: cfg.KeyCacheTTL, Logger: logger}
+}
+
+// MultiKeySource is a KeySource that tries each of its sources in order and
+// returns the first key found.
+type MultiKeySource []KeySource
+
+// KeyByFingerprint implements KeySource.
+func (s MultiKeySource) KeyByFingerprint(ctx context.Context, fingerprint, emailAddress string) (*openpgp.Entity, error) {
+	var errs []string
+	for _, source := range s {
+		e, err := source.KeyByFingerprint(ctx, fingerprint, emailAddress)
+		if err == nil {
+			return e, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("no key source has key: %s", strings.Join(errs, "; "))
+}
+
+// KeyRingKeySource is a KeySource backed by an in-memory key ring, e.g. the
+// contents of a keyring file.
+type KeyRingKeySource struct {
+	KeyRing openpgp.EntityList
+}
+
+// KeyByFingerprint implements KeySource.
+func (s KeyRingKeySource) KeyByFingerprint(ctx context.Context, fingerprint, emailAddress string) (*openpgp.Entity, error) {
+	e := entityByFingerprint(s.KeyRing, fingerprint)
+	if e == nil {
+		return nil, fmt.Errorf("no key with fingerprint %s in keyring", normalizeFingerprint(fingerprint))
+	}
+	return e, nil
+}
+
+// HKPKeySource is a KeySource that fetches keys from an HKP keyserver (e.g.
+// "https://keys.openpgp.org").
+type HKPKeySource struct {
+	HTTPClient *http.Client
+	BaseURL    string
+}
+
+// KeyByFingerprint implements KeySource.
+func (s HKPKeySource) KeyByFingerprint(ctx context.Context, fingerprint, emailAddress string) (*openpgp.Entity, error) {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyserver url: %w", err)
+	}
+
+	u.Path = path.Join(u.Path, "pks", "lookup")
+
+	q := u.Query()
+	q.Set("op", "get")
+	q.Set("options", "mr")
+	q.Set("search", "0x"+normalizeFingerprint(fingerprint))
+	u.RawQuery = q.Encode()
+
+	keyRing, err := fetchKeyRing(ctx, s.HTTPClient, u.String())
+	if err != nil {
+		return nil, fmt.Errorf("keyserver lookup failed: %w", err)
+	}
+
+	return pinnedEntity(keyRing, fingerprint)
+}
+
+// WKDKeySource is a KeySource that fetches keys from the Web Key Directory of
+// the domain of the key's email address. If BaseURL is set, it replaces the
+// "https://openpgpkey.<domain>" base of the advanced method and the direct
+// method is not tried.
+type WKDKeySource struct {
+	HTTPClient *http.Client
+	BaseURL    string
+}
+
+// KeyByFingerprint implements KeySource.
+func (s WKDKeySource) KeyByFingerprint(ctx context.Context, fingerprint, emailAddress string) (*openpgp.Entity, error) {
+	if emailAddress == "" {
+		return nil, fmt.Errorf("web key directory lookup requires an email address")
+	}
+
+	urls, err := wkdURLs(s.BaseURL, emailAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyRing openpgp.EntityList
+	for _, u := range urls {
+		keyRing, err = fetchKeyRing(ctx, s.HTTPClient, u)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("web key directory lookup failed: %w", err)
+	}
+
+	return pinnedEntity(keyRing, fingerprint)
+}
+
+// wkdURLs returns the URLs to look up the email address in a Web Key
+// Directory, in order of preference.
+// See https://datatracker.ietf.org/doc/html/draft-koch-openpgp-webkey-service.
+func wkdURLs(baseURL, emailAddress string) ([]string, error) {
+	at := strings.LastIndex(emailAddress, "@")
+	if at < 1 || at == len(emailAddress)-1 {
+		return nil, fmt.Errorf("invalid email address format: %q", emailAddress)
+	}
+	local := emailAddress[:at]
+	domain := strings.ToLower(emailAddress[at+1:])
+
+	// The WKD spec mandates SHA-1 for hashing the local part.
+	digest := sha1.Sum([]byte(strings.ToLower(local)))
+	hu := zbase32Encode(digest[:]) + "?l=" + url.QueryEscape(local)
+
+	if baseURL != "" {
+		return []string{strings.TrimSuffix(baseURL, "/") + "/.well-known/openpgpkey/" + domain + "/hu/" + hu}, nil
+	}
+	return []string{
+		"https://openpgpkey." + domain + "/.well-known/openpgpkey/" + domain + "/hu/" + hu,
+		"https://" + domain + "/.well-known/openpgpkey/hu/" + hu,
+	}, nil
+}
+
+// zbase32Encode encodes bytes with the z-base-32 encoding used by WKD.
+func zbase32Encode(bs []byte) string {
+	const alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+	var sb strings.Builder
+	var buffer, bits uint
+	for _, b := range bs {
+		buffer = buffer<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			sb.WriteByte(alphabet[(buffer>>bits)&0x1f])
+		}
+	}
+	if bits > 0 {
+		sb.WriteByte(alphabet[(buffer<<(5-bits))&0x1f])
+	}
+	return sb.String()
+}
+
+// fetchKeyRing fetches and parses the keyring at the URL. Responses larger
+// than maxKeyResponseSize are rejected.
+func fetchKeyRing(ctx context.Context, client *http.Client, u string) (openpgp.EntityList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKeyResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(body) > maxKeyResponseSize {
+		return nil, fmt.Errorf("response is larger than %d bytes", maxKeyResponseSize)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, BadResponseError{
+			RequestMethod: req.Method,
+			RequestURL:    req.URL,
+			StatusCode:    resp.StatusCode,
+			Body:          body,
+			Header:        resp.Header,
+			Cause:         fmt.Errorf("expected status %d", http.StatusOK),
+		}
+	}
+
+	return readKeyRing(body)
+}
+
+// pinnedEntity returns the entity in the fetched key ring whose fingerprint
+// matches the pin. Any other keys are ignored.
+func pinnedEntity(keyRing openpgp.EntityList, fingerprint string) (*openpgp.Entity, error) {
+	e := entityByFingerprint(keyRing, fingerprint)
+	if e == nil {
+		return nil, fmt.Errorf("fetched key does not match pinned fingerprint %s", normalizeFingerprint(fingerprint))
+	}
+	return e, nil
+}
+
+// CachingKeySource wraps a remote KeySource and caches fetched keys on disk in
+// Dir as "<fingerprint>.asc". Cached keys younger than TTL are used without
+// contacting the source. If the source fails, a cached key of any age is used.
+type CachingKeySource struct {
+	Source KeySource
+	Dir    string
+	TTL    time.Duration
+	// Logger logs failures to refresh or cache keys. Defaults to the standard
+	// logger.
+	Logger Logger
+}
+
+// KeyByFingerprint implements KeySource.
+func (s CachingKeySource) KeyByFingerprint(ctx context.Context, fingerprint, emailAddress string) (*openpgp.Entity, error) {
+	fp := normalizeFingerprint(fingerprint)
+	cachePath := filepath.Join(s.Dir, fp+".asc")
+
+	cached, cachedAt, cacheErr := s.readCache(cachePath, fp)
+	if cacheErr == nil && time.Since(cachedAt) < s.TTL {
+		return cached, nil
+	}
+
+	logger := s.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	e, err := s.Source.KeyByFingerprint(ctx, fp, emailAddress)
+	if err != nil {
+		if cacheErr == nil {
+			logger.Printf("Failed to refresh key %s, using cached key from %s: %v\n\n", fp, cachedAt.Format(time.RFC3339), err)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	if err := s.writeCache(cachePath, e); err != nil {
+		logger.Printf("Failed to cache key %s: %v\n\n", fp, err)
+	}
+	return e, nil
+}
+
+// readCache reads the cached key from cachePath and returns it with its
+// modification time. The cached key must still match the pinned fingerprint.
+func (s CachingKeySource) readCache(cachePath, fingerprint string) (*openpgp.Entity, time.Time, error) {
+	info, err := os.Stat(cachePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	keyRing, err := LoadKeyRingFile(cachePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	e, err := pinnedEntity(keyRing, fingerprint)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return e, info.ModTime(), nil
+}
+
+// writeCache armors the entity and atomically writes it to cachePath.
+func (s CachingKeySource) writeCache(cachePath string, e *openpgp.Entity) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+
+	armored, err := armorEntity(e)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cachePath, armored)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of
+// filePath, and renames it to filePath.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// LoadKeyRingFile reads a keyring file containing one or more public keys,
+// either ASCII-armored or binary (as produced by `gpg --export`).
+func LoadKeyRingFile(filePath string) (openpgp.EntityList, error) {
+	bs, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file at '%s': %w", filePath, err)
+	}
+
+	keyRing, err := readKeyRing(bs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file at '%s': %w", filePath, err)
+	}
+
+	return keyRing, nil
+}
+
+// readKeyRing parses one or more public keys, either ASCII-armored or binary.
+func readKeyRing(bs []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(bs), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(bs))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(bs))
+}
+
+// entityByFingerprint returns the entity in the key ring whose primary key has
+// the fingerprint, or nil if there is none.
+func entityByFingerprint(keyRing openpgp.EntityList, fingerprint string) *openpgp.Entity {
+	want := normalizeFingerprint(fingerprint)
+	for _, e := range keyRing {
+		if formatFingerprint(e.PrimaryKey.Fingerprint) == want {
+			return e
+		}
+	}
+	return nil
+}
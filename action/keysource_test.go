@@ -0,0 +1,123 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHKPKeySource(t *testing.T) {
+	entity := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	other := newTestEntity(t, "Mallory", "mallory@example.com")
+	fp := formatFingerprint(entity.PrimaryKey.Fingerprint)
+
+	var served string
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/pks/lookup" || r.URL.Query().Get("search") != "0x"+fp {
+			http.NotFound(w, r)
+			return
+		}
+		if served == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(served))
+	}))
+	defer srv.Close()
+
+	source := HKPKeySource{HTTPClient: srv.Client(), BaseURL: srv.URL}
+	cacheDir := t.TempDir()
+	cached := CachingKeySource{Source: source, Dir: cacheDir, TTL: time.Hour}
+
+	t.Run("mismatched_fingerprint", func(t *testing.T) {
+		served = armorPublicKey(t, other)
+		_, err := source.KeyByFingerprint(context.Background(), fp, "")
+		assertEqualErr(t, "fetched key does not match pinned fingerprint "+fp, err)
+	})
+
+	t.Run("response_too_large", func(t *testing.T) {
+		served = strings.Repeat("A", maxKeyResponseSize+1)
+		_, err := source.KeyByFingerprint(context.Background(), fp, "")
+		if err == nil || !strings.Contains(err.Error(), "response is larger than") {
+			t.Errorf("expected oversized response to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("cancelled_context", func(t *testing.T) {
+		served = armorPublicKey(t, entity)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := source.KeyByFingerprint(ctx, fp, "")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("fetch_and_cache", func(t *testing.T) {
+		served = armorPublicKey(t, entity)
+		e, err := cached.KeyByFingerprint(context.Background(), fp, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if formatFingerprint(e.PrimaryKey.Fingerprint) != fp {
+			t.Errorf("expected fingerprint %s, got %s", fp, formatFingerprint(e.PrimaryKey.Fingerprint))
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, fp+".asc")); err != nil {
+			t.Errorf("expected key to be cached: %v", err)
+		}
+	})
+
+	t.Run("fresh_cache", func(t *testing.T) {
+		requests = 0
+		if _, err := cached.KeyByFingerprint(context.Background(), fp, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if requests != 0 {
+			t.Errorf("expected fresh cached key to be used without a request, got %d requests", requests)
+		}
+	})
+
+	t.Run("stale_cache_fallback", func(t *testing.T) {
+		served = ""
+		stale := CachingKeySource{Source: source, Dir: cacheDir}
+		if _, err := stale.KeyByFingerprint(context.Background(), fp, ""); err != nil {
+			t.Fatalf("expected cached key when keyserver is unavailable, got %v", err)
+		}
+	})
+}
+
+func TestWKDURLs(t *testing.T) {
+	// Test vector from draft-koch-openpgp-webkey-service.
+	urls, err := wkdURLs("", "Joe.Doe@Example.ORG")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"https://openpgpkey.example.org/.well-known/openpgpkey/example.org/hu/iy9q119eutrkn8s1mk4r39qejnbu3n5q?l=Joe.Doe",
+		"https://example.org/.well-known/openpgpkey/hu/iy9q119eutrkn8s1mk4r39qejnbu3n5q?l=Joe.Doe",
+	}
+	if len(urls) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, urls)
+	}
+	for i := range expected {
+		if urls[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], urls[i])
+		}
+	}
+
+	urls, err = wkdURLs("http://127.0.0.1:8080/", "Joe.Doe@Example.ORG")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "http://127.0.0.1:8080/.well-known/openpgpkey/example.org/hu/iy9q119eutrkn8s1mk4r39qejnbu3n5q?l=Joe.Doe"; len(urls) != 1 || urls[0] != want {
+		t.Errorf("expected [%v], got %v", want, urls)
+	}
+}
@@ -0,0 +1,61 @@
+package action
+
+import (
+	"regexp"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Kinds of commits, as classified by ClassifyCommit.
+const (
+	// CommitKindOctopusMerge is a merge commit with more than two parents.
+	CommitKindOctopusMerge = "octopus_merge"
+	// CommitKindMerge is a merge commit with two parents.
+	CommitKindMerge = "merge"
+	// CommitKindRoot is a commit without parents.
+	CommitKindRoot = "root"
+	// CommitKindRevert is a commit created by `git revert`, detected by the
+	// "This reverts commit <hash>" line or a "Reverts: <hash>" trailer.
+	CommitKindRevert = "revert"
+	// CommitKindCherryPick is a commit created by `git cherry-pick -x`,
+	// detected by the "(cherry picked from commit <hash>)" line.
+	CommitKindCherryPick = "cherry_pick"
+	// CommitKindEmpty is a commit with the same tree as its parent.
+	CommitKindEmpty = "empty"
+	// CommitKindRegular is any other commit.
+	CommitKindRegular = "regular"
+)
+
+var (
+	revertRegex        = regexp.MustCompile(`(?m)^This reverts commit [0-9a-f]{7,64}\b`)
+	revertTrailerRegex = regexp.MustCompile(`(?mi)^Reverts: *[0-9a-f]{7,64}\s*$`)
+	cherryPickRegex    = regexp.MustCompile(`(?m)^\(cherry picked from commit [0-9a-f]{7,64}\)\s*$`)
+)
+
+// ClassifyCommit returns the kind of the commit. A commit of several kinds is
+// classified by the first that applies in the order octopus merge, merge,
+// root, revert, cherry-pick, empty.
+//
+// Reverts and cherry-picks are detected from the commit message, which is
+// chosen by the author of the commit.
+func ClassifyCommit(c *object.Commit) string {
+	switch {
+	case c.NumParents() > 2:
+		return CommitKindOctopusMerge
+	case c.NumParents() == 2:
+		return CommitKindMerge
+	case c.NumParents() == 0:
+		return CommitKindRoot
+	case revertRegex.MatchString(c.Message) || revertTrailerRegex.MatchString(c.Message):
+		return CommitKindRevert
+	case cherryPickRegex.MatchString(c.Message):
+		return CommitKindCherryPick
+	}
+
+	// The parent may be missing, e.g. in a shallow clone.
+	parent, err := c.Parent(0)
+	if err == nil && parent.TreeHash == c.TreeHash {
+		return CommitKindEmpty
+	}
+	return CommitKindRegular
+}
@@ -0,0 +1,140 @@
+package action
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestClassifyCommit(t *testing.T) {
+	r := newTestRepo(t)
+	root := r.commit(testCommitOptions{})
+	regular := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}, Files: map[string]string{"a": "a"}})
+	empty := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular}, Files: map[string]string{"a": "a"}})
+	revert := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular}, Message: "Revert \"Add a\"\n\nThis reverts commit " + regular.String() + ".\n"})
+	revertTrailer := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular}, Message: "Undo a\n\nReverts: " + regular.String()[:12] + "\n"})
+	cherryPick := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}, Files: map[string]string{"a": "a"}, Message: "Add a\n\n(cherry picked from commit " + regular.String() + ")\n"})
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular, cherryPick}})
+	octopus := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular, cherryPick, revert}})
+	// Mentioning a revert in the subject is not enough.
+	notRevert := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}, Files: map[string]string{"b": "b"}, Message: "Revert \"Add a\"\n"})
+
+	tests := []struct {
+		name     string
+		commit   plumbing.Hash
+		expected string
+	}{
+		{name: "root", commit: root, expected: CommitKindRoot},
+		{name: "regular", commit: regular, expected: CommitKindRegular},
+		{name: "empty", commit: empty, expected: CommitKindEmpty},
+		{name: "revert", commit: revert, expected: CommitKindRevert},
+		{name: "revert_trailer", commit: revertTrailer, expected: CommitKindRevert},
+		{name: "cherry_pick", commit: cherryPick, expected: CommitKindCherryPick},
+		{name: "merge", commit: merge, expected: CommitKindMerge},
+		{name: "octopus_merge", commit: octopus, expected: CommitKindOctopusMerge},
+		{name: "not_revert", commit: notRevert, expected: CommitKindRegular},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := r.CommitObject(tt.commit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if kind := ClassifyCommit(c); kind != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, kind)
+			}
+		})
+	}
+}
+
+func TestRunCommitKindAllowlist(t *testing.T) {
+	f := newFakeAPIServer(t, false)
+	r := newTestRepo(t)
+	root := r.commit(testCommitOptions{Email: "bot@example.com"})
+	regular := r.commit(testCommitOptions{Parents: []plumbing.Hash{root}, Email: "bot@example.com", Files: map[string]string{"a": "a"}})
+	revert := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular}, Email: "bot@example.com", Message: "Revert \"Add a\"\n\nThis reverts commit " + regular.String() + ".\n"})
+	merge := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular, revert}, Email: "bot@example.com"})
+	octopus := r.commit(testCommitOptions{Parents: []plumbing.Hash{regular, revert, merge}, Email: "bot@example.com"})
+
+	// The bot may only revert commits and create merges.
+	allowlistPath := filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, allowlistPath, `
+merge_commit_allowlist:
+  email_addresses:
+    - email_address: bot@example.com
+revert_commit_allowlist:
+  email_addresses:
+    - email_address: bot@example.com
+octopus_merge_commit_allowlist: {}
+`)
+
+	tests := []struct {
+		name           string
+		commit         plumbing.Hash
+		expectedKind   string
+		expectedResult string
+	}{
+		{name: "root", commit: root, expectedKind: CommitKindRoot, expectedResult: FAIL},
+		{name: "regular", commit: regular, expectedKind: CommitKindRegular, expectedResult: FAIL},
+		{name: "revert", commit: revert, expectedKind: CommitKindRevert, expectedResult: PASS},
+		{name: "merge", commit: merge, expectedKind: CommitKindMerge, expectedResult: PASS},
+		{name: "octopus_merge", commit: octopus, expectedKind: CommitKindOctopusMerge, expectedResult: FAIL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestRunConfig(r, f)
+			cfg.CommitRef = tt.commit.String()
+			cfg.AllowlistConfigFilePath = allowlistPath
+
+			o := Run(context.Background(), cfg)
+			if o.Commit.Kind != tt.expectedKind || o.Result != tt.expectedResult {
+				t.Errorf("expected %s commit to %s, got %s commit with %s: %s", tt.expectedKind, tt.expectedResult, o.Commit.Kind, o.Result, o.Desc)
+			}
+		})
+	}
+}
+
+func TestAllowlistForKind(t *testing.T) {
+	allowlistYAML, err := ParseAllowlistYAML("allowlist.yaml", []byte(`
+non_merge_commit_allowlist:
+  email_addresses:
+    - email_address: jane@doe.com
+revert_commit_allowlist:
+  email_addresses:
+    - email_address: bot@example.com
+cherry_pick_commit_allowlist: {}
+root_commit_allowlist: {}
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		kind            string
+		expectedSection string
+		expectedEmails  []string
+	}{
+		{kind: CommitKindRegular, expectedSection: "non_merge_commit_allowlist", expectedEmails: []string{"jane@doe.com"}},
+		// Sections of kinds detected from the commit message add to the
+		// non-merge commit allowlist.
+		{kind: CommitKindRevert, expectedSection: "non_merge_commit_allowlist and revert_commit_allowlist", expectedEmails: []string{"jane@doe.com", "bot@example.com"}},
+		{kind: CommitKindCherryPick, expectedSection: "non_merge_commit_allowlist and cherry_pick_commit_allowlist", expectedEmails: []string{"jane@doe.com"}},
+		// Other sections replace it.
+		{kind: CommitKindRoot, expectedSection: "root_commit_allowlist", expectedEmails: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			section, allowlist := allowlistYAML.AllowlistForKind(tt.kind)
+			emails := []string{}
+			for _, e := range allowlist.EmailAddresses {
+				emails = append(emails, e.EmailAddress)
+			}
+			if section != tt.expectedSection || !reflect.DeepEqual(emails, tt.expectedEmails) {
+				t.Errorf("expected %s with %v, got %s with %v", tt.expectedSection, tt.expectedEmails, section, emails)
+			}
+		})
+	}
+}
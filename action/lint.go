@@ -0,0 +1,390 @@
+package action
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrCodeInvalidAllowlist is the OutcomeError code of an allowlist that does
+// not match the allowlist schema.
+const ErrCodeInvalidAllowlist = "INVALID_ALLOWLIST"
+
+// AllowlistError is an error at a position of an allowlist file.
+type AllowlistError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *AllowlistError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// Code returns the OutcomeError code of the error.
+func (e *AllowlistError) Code() string {
+	return ErrCodeInvalidAllowlist
+}
+
+// AllowlistErrors is returned by LoadAllowlistYAML if the allowlist does not
+// match the allowlist schema.
+type AllowlistErrors []*AllowlistError
+
+func (e AllowlistErrors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Code returns the OutcomeError code of the error.
+func (e AllowlistErrors) Code() string {
+	return ErrCodeInvalidAllowlist
+}
+
+// splitErrors returns the errors of an AllowlistErrors individually, so each
+// becomes an OutcomeError, or else err itself.
+func splitErrors(err error) []error {
+	var allowlistErrs AllowlistErrors
+	if !errors.As(err, &allowlistErrs) {
+		return []error{err}
+	}
+	errs := []error{}
+	for _, e := range allowlistErrs {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// allowlistValueValidators validate the values of allowlist fields by their
+// key, in addition to the schema. They are only checked by LintAllowlistYAML,
+// since invalid entries are reported without failing verification when the
+// allowlist is used.
+var allowlistValueValidators = map[string]func(string) error{
+	"email_address":    Email,
+	"committer_emails": Email,
+	"enforcement":      validateEnforcement,
+	"baseline_commit":  validateBaselineCommit,
+	"cutover":          validateCutover,
+	"fingerprint":      Fingerprint,
+	"subkey":           Fingerprint,
+	"platform": func(s string) error {
+		if _, ok := getPlatformKeySource(s); !ok {
+			return fmt.Errorf("unknown platform: %q", s)
+		}
+		return nil
+	},
+	"key": func(s string) error {
+		keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(s))
+		if err != nil {
+			return fmt.Errorf("failed to parse third party key: %v", err)
+		}
+		if len(keyRing) == 0 {
+			return fmt.Errorf("failed to parse third party key: no keys found")
+		}
+		return nil
+	},
+}
+
+// LintAllowlistYAML checks the allowlist file against the allowlist schema
+// and validates its email addresses, fingerprints, keys and platforms. Returns
+// all errors found, and warnings about entries of commit kind sections that
+// grant more than the section the kind falls back to.
+func LintAllowlistYAML(filePath string) ([]error, []error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return []error{fmt.Errorf(`failed to read allowlist yaml configuration file at '%s': %w`, filePath, err)}, nil
+	}
+	node, err := parseYAMLNode(data)
+	if err != nil {
+		return []error{fmt.Errorf("failed to unmarshal allowlist yaml configuration file '%s': %w", filePath, err)}, nil
+	}
+
+	errs := []error{}
+	for _, e := range checkAllowlistNode(filePath, node, reflect.TypeOf(AllowlistYAML{}), true) {
+		errs = append(errs, e)
+	}
+	warnings := []error{}
+	for _, w := range lintKindSections(filePath, node) {
+		warnings = append(warnings, w)
+	}
+	return errs, warnings
+}
+
+// kindSectionFallbacks are the allowlist sections of commit kinds, and the
+// sections used for the kinds without them, see AllowlistYAML.AllowlistForKind.
+var kindSectionFallbacks = []struct {
+	section  string
+	fallback string
+}{
+	{"octopus_merge_commit_allowlist", "merge_commit_allowlist"},
+	{"root_commit_allowlist", "non_merge_commit_allowlist"},
+	{"revert_commit_allowlist", "non_merge_commit_allowlist"},
+	{"cherry_pick_commit_allowlist", "non_merge_commit_allowlist"},
+	{"empty_commit_allowlist", "non_merge_commit_allowlist"},
+}
+
+// lintKindSections returns a warning for each entry of a commit kind section
+// that is not covered by an entry of its fallback section, i.e. that lets
+// commits bypass verification or trusts keys only because of their kind.
+// Entries that cannot be decoded are skipped, as checkAllowlistNode reports
+// them.
+func lintKindSections(file string, node *yaml.Node) AllowlistErrors {
+	warnings := AllowlistErrors{}
+	for _, k := range kindSectionFallbacks {
+		section := yamlMappingValue(node, k.section)
+		if section == nil {
+			continue
+		}
+		fallback := Allowlist{}
+		if n := yamlMappingValue(node, k.fallback); n != nil {
+			if err := n.Decode(&fallback); err != nil {
+				continue
+			}
+		}
+
+		warnAt := func(n *yaml.Node, granted string) {
+			warnings = append(warnings, &AllowlistError{File: file, Line: n.Line, Column: n.Column,
+				Msg: fmt.Sprintf("%s grants %s, which %s does not", k.section, granted, k.fallback)})
+		}
+		for _, n := range yamlSequence(yamlMappingValue(section, "email_addresses")) {
+			e := EmailAddressEntry{}
+			if n.Decode(&e) == nil && !emailAddressEntryCovered(e, fallback.EmailAddresses) {
+				warnAt(n, fmt.Sprintf("email address %q", e.EmailAddress))
+			}
+		}
+		for _, n := range yamlSequence(yamlMappingValue(section, "third_party_keys")) {
+			e := ThirdPartyKeyEntry{}
+			if n.Decode(&e) == nil && !thirdPartyKeyEntryCovered(e, fallback.ThirdPartyKeys) {
+				warnAt(n, fmt.Sprintf("third party key %s", thirdPartyKeyEntryFingerprint(e)))
+			}
+		}
+		for _, n := range yamlSequence(yamlMappingValue(section, "platform_keys")) {
+			e := PlatformKeyEntry{}
+			if n.Decode(&e) == nil && !platformKeyEntryCovered(e, fallback.PlatformKeys) {
+				warnAt(n, fmt.Sprintf("platform key %q", e.Platform))
+			}
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Line < warnings[j].Line
+	})
+	return warnings
+}
+
+// yamlMappingValue returns the value of the key in the mapping node, or nil.
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			if value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			return value
+		}
+	}
+	return nil
+}
+
+// yamlSequence returns the elements of the sequence node, or nil.
+func yamlSequence(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+// reposCovered checks if an entry for the repositories repos is granted by an
+// entry for the repositories fallback, where no repositories means all of
+// them.
+func reposCovered(repos, fallback []string) bool {
+	if len(fallback) == 0 {
+		return true
+	}
+	if len(repos) == 0 {
+		return false
+	}
+	for _, r := range repos {
+		if !containsRepo(r, fallback) {
+			return false
+		}
+	}
+	return true
+}
+
+func emailAddressEntryCovered(e EmailAddressEntry, fallback []EmailAddressEntry) bool {
+	for _, f := range fallback {
+		if strings.EqualFold(e.EmailAddress, f.EmailAddress) && reposCovered(e.Repositories, f.Repositories) {
+			return true
+		}
+	}
+	return false
+}
+
+// thirdPartyKeyEntryFingerprint returns the fingerprint of the primary key of
+// the entry, parsing its inline key if necessary, or "" if it is invalid.
+func thirdPartyKeyEntryFingerprint(e ThirdPartyKeyEntry) string {
+	if e.Key == "" {
+		return normalizeFingerprint(e.Fingerprint)
+	}
+	keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(e.Key))
+	if err != nil || len(keyRing) == 0 {
+		return ""
+	}
+	return formatFingerprint(keyRing[0].PrimaryKey.Fingerprint)
+}
+
+func thirdPartyKeyEntryCovered(e ThirdPartyKeyEntry, fallback []ThirdPartyKeyEntry) bool {
+	fingerprint := thirdPartyKeyEntryFingerprint(e)
+	for _, f := range fallback {
+		if fingerprint == "" || fingerprint != thirdPartyKeyEntryFingerprint(f) || !reposCovered(e.Repositories, f.Repositories) {
+			continue
+		}
+		// A fallback without a pinned subkey trusts all of them.
+		if f.Subkey == "" || normalizeFingerprint(f.Subkey) == normalizeFingerprint(e.Subkey) {
+			return true
+		}
+	}
+	return false
+}
+
+func platformKeyEntryCovered(e PlatformKeyEntry, fallback []PlatformKeyEntry) bool {
+	source, _ := getPlatformKeySource(e.Platform)
+	for _, f := range fallback {
+		if f.Platform != e.Platform || !reposCovered(e.Repositories, f.Repositories) {
+			continue
+		}
+		// The committers of the platform are trusted by every entry.
+		trusted := append(append([]string{}, source.CommitterEmails...), f.CommitterEmails...)
+		covered := true
+		for _, email := range e.CommitterEmails {
+			if !verifyCommitByEmailAddress(email, trusted) {
+				covered = false
+			}
+		}
+		if covered {
+			return true
+		}
+	}
+	return false
+}
+
+// parseYAMLNode parses data into the node of its document. An empty document
+// is returned as a nil node.
+func parseYAMLNode(data []byte) (*yaml.Node, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return doc.Content[0], nil
+}
+
+// checkAllowlistNode checks that node can be decoded into a value of type t
+// without ignoring any of its fields. If checkValues is set, the values of the
+// fields in allowlistValueValidators are validated too.
+func checkAllowlistNode(file string, node *yaml.Node, t reflect.Type, checkValues bool) AllowlistErrors {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	errAt := func(n *yaml.Node, format string, args ...interface{}) *AllowlistError {
+		return &AllowlistError{File: file, Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	errs := AllowlistErrors{}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return append(errs, errAt(node, "expected a mapping, got %s", yamlNodeKind(node)))
+		}
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			fields[name] = t.Field(i)
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, errAt(key, "unknown field %q, expected one of %s", key.Value, strings.Join(sortedKeys(fields), ", ")))
+				continue
+			}
+			errs = append(errs, checkAllowlistNode(file, value, field.Type, checkValues)...)
+			validate, ok := allowlistValueValidators[key.Value]
+			if !ok || !checkValues {
+				continue
+			}
+			// Lists of values (e.g. committer_emails) are validated element-wise.
+			values := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				values = value.Content
+			}
+			for _, v := range values {
+				if v.Kind != yaml.ScalarNode {
+					continue
+				}
+				if err := validate(v.Value); err != nil {
+					errs = append(errs, errAt(v, "%v", err))
+				}
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return append(errs, errAt(node, "expected a list, got %s", yamlNodeKind(node)))
+		}
+		for _, n := range node.Content {
+			errs = append(errs, checkAllowlistNode(file, n, t.Elem(), checkValues)...)
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			return append(errs, errAt(node, "expected a string, got %s", yamlNodeKind(node)))
+		}
+	}
+	return errs
+}
+
+// yamlNodeKind describes the kind of node for error messages.
+func yamlNodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("%q", node.Value)
+	}
+}
+
+func sortedKeys(m map[string]reflect.StructField) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,246 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseAllowlistYAMLStrict(t *testing.T) {
+	tests := []struct {
+		name         string
+		yaml         string
+		expectedErrs []string
+	}{
+		{
+			name: "valid",
+			yaml: `
+non_merge_commit_allowlist:
+  email_addresses:
+    - email_address: jane@doe.com
+      repositories: [org/repo]
+`,
+		},
+		{
+			name: "empty",
+			yaml: "",
+		},
+		{
+			name: "unknown_fields",
+			yaml: `
+non_merge_commit_allowlist:
+  email_addresses:
+    - email_adresses: jane@doe.com
+      repository: org/repo
+mergecommit_allowlist: {}
+`,
+			expectedErrs: []string{
+				`allowlist.yaml:4:7: unknown field "email_adresses", expected one of email_address, repositories`,
+				`allowlist.yaml:5:7: unknown field "repository", expected one of email_address, repositories`,
+				`allowlist.yaml:6:1: unknown field "mergecommit_allowlist", expected one of cherry_pick_commit_allowlist, empty_commit_allowlist, merge_commit_allowlist, non_merge_commit_allowlist, octopus_merge_commit_allowlist, repository_settings, revert_commit_allowlist, root_commit_allowlist`,
+			},
+		},
+		{
+			name: "wrong_kinds",
+			yaml: `
+merge_commit_allowlist:
+  platform_keys:
+    platform: github
+  email_addresses:
+    - email_address: [jane@doe.com]
+`,
+			expectedErrs: []string{
+				`allowlist.yaml:4:5: expected a list, got a mapping`,
+				`allowlist.yaml:6:22: expected a string, got a list`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAllowlistYAML("allowlist.yaml", []byte(tt.yaml))
+			if len(tt.expectedErrs) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			errs := []string{}
+			for _, e := range splitErrors(err) {
+				errs = append(errs, e.Error())
+				if NewOutcomeError(e).Code != ErrCodeInvalidAllowlist {
+					t.Errorf("expected code %s for %v", ErrCodeInvalidAllowlist, e)
+				}
+			}
+			if !reflect.DeepEqual(errs, tt.expectedErrs) {
+				t.Errorf("expected errors:\n%s\ngot:\n%s", strings.Join(tt.expectedErrs, "\n"), strings.Join(errs, "\n"))
+			}
+		})
+	}
+}
+
+func TestLintAllowlistYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, path, `
+non_merge_commit_allowlist:
+  email_addresses:
+    - email_address: not-an-email
+  third_party_keys:
+    - fingerprint: ABCD
+    - key: "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n\n=twTO\n-----END PGP PUBLIC KEY BLOCK-----"
+  platform_keys:
+    - platform: gitea
+    - platform: github
+      committer_emails: [noreply@github.com, web-flow]
+`)
+
+	errs := []string{}
+	lintErrs, warnings := LintAllowlistYAML(path)
+	for _, err := range lintErrs {
+		errs = append(errs, strings.TrimPrefix(err.Error(), path))
+	}
+	expected := []string{
+		`:4:22: invalid email address format: "not-an-email"`,
+		`:6:20: invalid fingerprint format: "ABCD"`,
+		`:7:12: failed to parse third party key: no keys found`,
+		`:9:17: unknown platform: "gitea"`,
+		`:11:46: invalid email address format: "web-flow"`,
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected errors:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(errs, "\n"))
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestLintAllowlistYAMLKindSections(t *testing.T) {
+	entity := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	fingerprint := formatFingerprint(entity.PrimaryKey.Fingerprint)
+
+	path := filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, path, `
+merge_commit_allowlist:
+  platform_keys:
+    - platform: github
+revert_commit_allowlist:
+  email_addresses:
+    - email_address: Bot@Example.com
+      repositories: [org/a]
+    - email_address: bot@example.com
+    - email_address: mallory@example.com
+  third_party_keys:
+    - fingerprint: `+fingerprint+`
+      subkey: `+fingerprint+`
+octopus_merge_commit_allowlist:
+  platform_keys:
+    - platform: github
+      committer_emails: [noreply@github.com]
+    - platform: github
+      committer_emails: [bot@example.com]
+root_commit_allowlist:
+  third_party_keys:
+    - fingerprint: `+strings.Repeat("AB", 20)+`
+non_merge_commit_allowlist:
+  email_addresses:
+    - email_address: bot@example.com
+      repositories: [org/a, org/b]
+  third_party_keys:
+    - key: |
+`+indent(armorPublicKey(t, entity), "        ")+`
+`)
+
+	errs, warnings := LintAllowlistYAML(path)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	got := []string{}
+	for _, w := range warnings {
+		got = append(got, strings.TrimPrefix(w.Error(), path))
+	}
+	expected := []string{
+		`:9:7: revert_commit_allowlist grants email address "bot@example.com", which non_merge_commit_allowlist does not`,
+		`:10:7: revert_commit_allowlist grants email address "mallory@example.com", which non_merge_commit_allowlist does not`,
+		`:18:7: octopus_merge_commit_allowlist grants platform key "github", which merge_commit_allowlist does not`,
+		`:22:7: root_commit_allowlist grants third party key ` + strings.Repeat("AB", 20) + `, which non_merge_commit_allowlist does not`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected warnings:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+// TestAllowlistSchema checks that the published JSON Schema has the same
+// fields as the allowlist types.
+func TestAllowlistSchema(t *testing.T) {
+	bs, err := ioutil.ReadFile("../allowlist.schema.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(bs, &schema); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defs := schema["$defs"].(map[string]interface{})
+
+	// properties returns the sorted property names of a schema object.
+	properties := func(s interface{}) []string {
+		names := []string{}
+		for name := range s.(map[string]interface{})["properties"].(map[string]interface{}) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	// fields returns the sorted yaml field names of a type.
+	fields := func(v interface{}) []string {
+		names := []string{}
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			names = append(names, typ.Field(i).Tag.Get("yaml"))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	allowlist := defs["allowlist"].(map[string]interface{})
+	items := func(name string) interface{} {
+		return allowlist["properties"].(map[string]interface{})[name].(map[string]interface{})["items"]
+	}
+	tests := []struct {
+		name     string
+		schema   interface{}
+		expected []string
+	}{
+		{name: "AllowlistYAML", schema: schema, expected: fields(AllowlistYAML{})},
+		{name: "Allowlist", schema: allowlist, expected: fields(Allowlist{})},
+		{name: "EmailAddressEntry", schema: items("email_addresses"), expected: fields(EmailAddressEntry{})},
+		{name: "ThirdPartyKeyEntry", schema: items("third_party_keys"), expected: fields(ThirdPartyKeyEntry{})},
+		{name: "PlatformKeyEntry", schema: items("platform_keys"), expected: fields(PlatformKeyEntry{})},
+		{name: "RepositorySettingsEntry", schema: schema["properties"].(map[string]interface{})["repository_settings"].(map[string]interface{})["items"], expected: fields(RepositorySettingsEntry{})},
+	}
+	for _, tt := range tests {
+		if got := properties(tt.schema); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected schema properties %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestRunInvalidAllowlist(t *testing.T) {
+	f := newFakeAPIServer(t, false)
+	r := newTestRepo(t)
+	r.commit(testCommitOptions{})
+
+	cfg := newTestRunConfig(r, f)
+	cfg.AllowlistConfigFilePath = filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, cfg.AllowlistConfigFilePath, "non_merge_commit_allowlist:\n  email_address:\n    - jane@doe.com\n")
+
+	o := Run(context.Background(), cfg)
+	if o.Result != FAIL || len(o.Errors) != 1 || o.Errors[0].Code != ErrCodeInvalidAllowlist || !strings.HasSuffix(o.Errors[0].Desc, `:2:3: unknown field "email_address", expected one of email_addresses, platform_keys, third_party_keys`) {
+		t.Errorf("expected FAIL with code %s, got %s: %+v", ErrCodeInvalidAllowlist, o.Result, o.Errors)
+	}
+}
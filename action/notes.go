@@ -0,0 +1,498 @@
+package action
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// DefaultNotesCacheRef is the notes ref that verification results are
+// conventionally cached in.
+const DefaultNotesCacheRef = "refs/notes/auth-commit-sig"
+
+// DefaultNotesCacheMaxAge is the longest a cached result is trusted, if
+// Config.NotesCacheMaxAge is not set. Results are verified again eventually, so
+// that changes of the authorizations and keys that are not part of the
+// settings, e.g. revoked keys, are picked up.
+const DefaultNotesCacheMaxAge = 7 * 24 * time.Hour
+
+// CachedResultVersion is the version of the format of cached results.
+const CachedResultVersion = 1
+
+// NotesCachePolicyVersion is the version of the verification rules. It is
+// incremented whenever a change may alter the result of verifying a commit, so
+// that results cached by earlier versions are no longer trusted.
+const NotesCachePolicyVersion = 1
+
+// CachedResult is the result of verifying a commit, stored in a git note on the
+// commit.
+type CachedResult struct {
+	Version    int    `json:"version"`
+	Repository string `json:"repository"`
+	CommitHash string `json:"commit_hash"`
+	// AllowlistHash is the hex encoded SHA-256 hash of the allowlist the
+	// commit was verified with.
+	AllowlistHash string `json:"allowlist_hash"`
+	// PolicyVersion is the NotesCachePolicyVersion the commit was verified
+	// under.
+	PolicyVersion int `json:"policy_version"`
+	// SettingsHash is the hex encoded SHA-256 hash of the settings that may
+	// alter the result of verifying a commit, such as the CryptoPolicy, the
+	// third party and platform keys, and the authorizer.
+	SettingsHash string    `json:"settings_hash"`
+	VerifiedAt   time.Time `json:"verified_at"`
+	// Outcome is the JSON encoded Outcome of the commit.
+	Outcome json.RawMessage `json:"outcome"`
+}
+
+// SignedCachedResult is the content of a note: the JSON encoded CachedResult
+// and an ASCII-armored detached signature over its compact encoding.
+type SignedCachedResult struct {
+	Result    json.RawMessage `json:"result"`
+	Signature string          `json:"signature"`
+}
+
+// NotesCache caches the outcomes of commits that passed verification in git
+// notes, so that they are not verified again by later runs. A cached outcome is
+// only trusted if its note is signed by a trusted key, and it was produced for
+// the same repository, with the same allowlist, policy version and settings.
+type NotesCache struct {
+	repo    *git.Repository
+	ref     plumbing.ReferenceName
+	signer  *openpgp.Entity
+	keyRing openpgp.EntityList
+	policy  CryptoPolicy
+	maxAge  time.Duration
+
+	repository    string
+	allowlistHash string
+	settingsHash  string
+
+	mu      sync.Mutex
+	pending map[plumbing.Hash][]byte
+}
+
+// NewNotesCache opens the notes cache configured by cfg for results verified
+// with allowlistYAML and the authorizer configured by cfg. Returns nil if no
+// notes cache is configured.
+func NewNotesCache(cfg Config, allowlistYAML *AllowlistYAML) (*NotesCache, error) {
+	if cfg.NotesCacheRef == "" {
+		return nil, nil
+	}
+
+	repo, err := git.PlainOpen(cfg.RepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	return newNotesCache(cfg, repo, allowlistYAML, nil)
+}
+
+// newNotesCache is NewNotesCache in an opened repository. authorizer is the
+// Authorizer given by WithAuthorizer, if any.
+func newNotesCache(cfg Config, repo *git.Repository, allowlistYAML *AllowlistYAML, authorizer Authorizer) (*NotesCache, error) {
+	var err error
+
+	c := &NotesCache{
+		repo:       repo,
+		ref:        plumbing.ReferenceName(cfg.NotesCacheRef),
+		policy:     cfg.CryptoPolicy,
+		maxAge:     cfg.NotesCacheMaxAge,
+		repository: cfg.Repository,
+		pending:    map[plumbing.Hash][]byte{},
+	}
+	if c.maxAge <= 0 {
+		c.maxAge = DefaultNotesCacheMaxAge
+	}
+	if cfg.NotesCacheSigningKeyFilePath != "" {
+		c.signer, err = LoadSigningKeyFile(cfg.NotesCacheSigningKeyFilePath, []byte(cfg.NotesCacheSigningKeyPassphrase))
+		if err != nil {
+			return nil, err
+		}
+		c.keyRing = openpgp.EntityList{c.signer}
+	}
+	if cfg.NotesCacheKeyRingFilePath != "" {
+		c.keyRing, err = LoadKeyRingFile(cfg.NotesCacheKeyRingFilePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.allowlistHash, err = hashJSON(allowlistYAML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash allowlist: %w", err)
+	}
+	settings, err := newNotesCacheSettings(cfg, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	c.settingsHash, err = hashJSON(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash settings: %w", err)
+	}
+	return c, nil
+}
+
+// notesCacheSettings are the settings hashed into the SettingsHash of cached
+// results. Keyrings are included by the hash of their content, so that a
+// result is not trusted once the keys it was verified with change.
+type notesCacheSettings struct {
+	CryptoPolicy        CryptoPolicy
+	VerifyMergedCommits bool
+	ShallowPolicy       string
+	VerifyCoAuthors     bool
+
+	ThirdPartyKeyRingHash string
+	KeyserverURL          string
+	WKDLookup             bool
+	WKDBaseURL            string
+	// PlatformKeyHashes are the hashes of the key files of the platforms, by
+	// platform.
+	PlatformKeyHashes map[string]string
+
+	// Authorizer is the type of the Authorizer given by WithAuthorizer, or
+	// else "api" or "snapshot".
+	Authorizer          string
+	APIBaseURL          string
+	SnapshotKeyRingHash string
+}
+
+// newNotesCacheSettings returns the settings of cfg that results are cached
+// under.
+func newNotesCacheSettings(cfg Config, authorizer Authorizer) (*notesCacheSettings, error) {
+	var err error
+
+	s := &notesCacheSettings{
+		CryptoPolicy:        cfg.CryptoPolicy,
+		VerifyMergedCommits: cfg.VerifyMergedCommits,
+		ShallowPolicy:       cfg.ShallowPolicy,
+		VerifyCoAuthors:     cfg.VerifyCoAuthors,
+		KeyserverURL:        cfg.KeyserverURL,
+		WKDLookup:           cfg.WKDLookup,
+		WKDBaseURL:          cfg.WKDBaseURL,
+		PlatformKeyHashes:   map[string]string{},
+	}
+	if cfg.ThirdPartyKeyRingFilePath != "" {
+		if s.ThirdPartyKeyRingHash, err = hashFile(cfg.ThirdPartyKeyRingFilePath); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PlatformKeysDir != "" {
+		for _, source := range PlatformKeySources {
+			h, err := hashFile(filepath.Join(cfg.PlatformKeysDir, source.Platform+".asc"))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			s.PlatformKeyHashes[source.Platform] = h
+		}
+	}
+
+	switch {
+	case authorizer != nil:
+		s.Authorizer = fmt.Sprintf("%T", authorizer)
+	case cfg.OfflineSnapshotFile != "":
+		s.Authorizer = "snapshot"
+		if s.SnapshotKeyRingHash, err = hashFile(cfg.OfflineSnapshotKeyRingFilePath); err != nil {
+			return nil, err
+		}
+	default:
+		s.Authorizer = "api"
+		s.APIBaseURL = cfg.APIBaseURL
+	}
+	return s, nil
+}
+
+// hashFile returns the hex encoded SHA-256 hash of the content of the file.
+func hashFile(filePath string) (string, error) {
+	bs, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s': %w", filePath, err)
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hashJSON returns the hex encoded SHA-256 hash of the JSON encoding of v.
+func hashJSON(v interface{}) (string, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Get returns the cached Outcome of the commit, or nil if there is none. An
+// error is returned if a note exists but cannot be trusted.
+func (c *NotesCache) Get(commit plumbing.Hash, now time.Time) (*Outcome, error) {
+	data, err := c.readNote(commit)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	signed := SignedCachedResult{}
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("failed to parse note: %w", err)
+	}
+	payload := &bytes.Buffer{}
+	if err := json.Compact(payload, signed.Result); err != nil {
+		return nil, fmt.Errorf("failed to parse note: %w", err)
+	}
+	if _, _, err := checkArmoredDetachedSignature(c.keyRing, payload.String(), signed.Signature, c.policy); err != nil {
+		return nil, fmt.Errorf("note signature check failed: %w", err)
+	}
+
+	r := CachedResult{}
+	if err := json.Unmarshal(signed.Result, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse note: %w", err)
+	}
+	switch {
+	case r.Version != CachedResultVersion:
+		return nil, fmt.Errorf("unsupported note version %d", r.Version)
+	case r.CommitHash != commit.String():
+		return nil, fmt.Errorf("note is for commit %s", r.CommitHash)
+	case r.Repository != c.repository:
+		return nil, fmt.Errorf("note is for repository %q", r.Repository)
+	case r.AllowlistHash != c.allowlistHash:
+		return nil, fmt.Errorf("note was made with a different allowlist")
+	case r.PolicyVersion != NotesCachePolicyVersion:
+		return nil, fmt.Errorf("note was made under policy version %d", r.PolicyVersion)
+	case r.SettingsHash != c.settingsHash:
+		return nil, fmt.Errorf("note was made with different settings")
+	case now.Sub(r.VerifiedAt) > c.maxAge:
+		return nil, fmt.Errorf("note was made at %s, more than %s ago", r.VerifiedAt.Format(time.RFC3339), c.maxAge)
+	}
+
+	o := &Outcome{}
+	if err := json.Unmarshal(r.Outcome, o); err != nil {
+		return nil, fmt.Errorf("failed to parse cached outcome: %w", err)
+	}
+	if o.Result != PASS {
+		return nil, fmt.Errorf("cached outcome is %s", o.Result)
+	}
+	o.Cached = true
+	return o, nil
+}
+
+// Put adds the Outcome of a commit that passed verification to the cache. It is
+// written by Flush. Outcomes are only cached if a signing key is configured,
+// and not if a break-glass token overrode a failure, as the token expires.
+func (c *NotesCache) Put(o *Outcome, now time.Time) error {
+	if c.signer == nil || o.Result != PASS || o.Cached || o.Commit == nil || len(o.BreakGlassCommits) > 0 {
+		return nil
+	}
+
+	outcomeJSON, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outcome: %w", err)
+	}
+	payload, err := json.Marshal(CachedResult{
+		Version:       CachedResultVersion,
+		Repository:    c.repository,
+		CommitHash:    o.Commit.CommitHash,
+		AllowlistHash: c.allowlistHash,
+		PolicyVersion: NotesCachePolicyVersion,
+		SettingsHash:  c.settingsHash,
+		VerifiedAt:    now.UTC(),
+		Outcome:       outcomeJSON,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cached result: %w", err)
+	}
+
+	sig := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(sig, c.signer, bytes.NewReader(payload), nil); err != nil {
+		return fmt.Errorf("failed to sign cached result: %w", err)
+	}
+	note, err := json.MarshalIndent(SignedCachedResult{Result: payload, Signature: sig.String()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal note: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[plumbing.NewHash(o.Commit.CommitHash)] = append(note, '\n')
+	return nil
+}
+
+// Flush writes the notes added by Put in a single commit on the notes ref.
+// Returns the number of notes written.
+func (c *NotesCache) Flush(now time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return 0, nil
+	}
+
+	var parents []plumbing.Hash
+	entries := map[string]object.TreeEntry{}
+	ref, err := c.repo.Reference(c.ref, true)
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+	case err != nil:
+		return 0, fmt.Errorf("failed to resolve %s: %w", c.ref, err)
+	default:
+		tree, err := c.notesTree(ref)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range tree.Entries {
+			entries[e.Name] = e
+		}
+		parents = append(parents, ref.Hash())
+	}
+
+	for commit, note := range c.pending {
+		h, err := c.writeObject(plumbing.BlobObject, note)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write note: %w", err)
+		}
+		entries[commit.String()] = object.TreeEntry{Name: commit.String(), Mode: filemode.Regular, Hash: h}
+	}
+
+	tree := &object.Tree{}
+	for _, e := range entries {
+		tree.Entries = append(tree.Entries, e)
+	}
+	// Git orders tree entries by name, with "/" appended to the names of
+	// subtrees, as used by fanned out notes.
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool {
+		return sortName(tree.Entries[i]) < sortName(tree.Entries[j])
+	})
+	treeObj := c.repo.Storer.NewEncodedObject()
+	if err := tree.Encode(treeObj); err != nil {
+		return 0, fmt.Errorf("failed to encode notes tree: %w", err)
+	}
+	treeHash, err := c.repo.Storer.SetEncodedObject(treeObj)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write notes tree: %w", err)
+	}
+
+	sig := object.Signature{Name: "auth-commit-sig", Email: "auth-commit-sig@localhost", When: now}
+	commit := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      fmt.Sprintf("Cache verification results of %d commits\n", len(c.pending)),
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	commitObj := c.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(commitObj); err != nil {
+		return 0, fmt.Errorf("failed to encode notes commit: %w", err)
+	}
+	commitHash, err := c.repo.Storer.SetEncodedObject(commitObj)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write notes commit: %w", err)
+	}
+	newRef := plumbing.NewHashReference(c.ref, commitHash)
+	if ref != nil {
+		err = c.repo.Storer.CheckAndSetReference(newRef, ref)
+	} else {
+		err = c.repo.Storer.SetReference(newRef)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update %s: %w", c.ref, err)
+	}
+
+	n := len(c.pending)
+	c.pending = map[plumbing.Hash][]byte{}
+	return n, nil
+}
+
+// readNote returns the note on the commit, or nil if there is none. Notes are
+// looked up by the full hash of the commit, and in the fanned out layout that
+// git uses for large numbers of notes.
+func (c *NotesCache) readNote(commit plumbing.Hash) ([]byte, error) {
+	ref, err := c.repo.Reference(c.ref, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", c.ref, err)
+	}
+	tree, err := c.notesTree(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	name := commit.String()
+	for _, path := range []string{name, name[:2] + "/" + name[2:], name[:2] + "/" + name[2:4] + "/" + name[4:]} {
+		f, err := tree.File(path)
+		if errors.Is(err, object.ErrFileNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read note: %w", err)
+		}
+		r, err := f.Reader()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read note: %w", err)
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, nil
+}
+
+// notesTree returns the tree of the commit that the notes ref points at.
+func (c *NotesCache) notesTree(ref *plumbing.Reference) (*object.Tree, error) {
+	commit, err := c.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", c.ref, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tree of %s: %w", c.ref, err)
+	}
+	return tree, nil
+}
+
+// writeObject stores an object of type t with the content data.
+func (c *NotesCache) writeObject(t plumbing.ObjectType, data []byte) (plumbing.Hash, error) {
+	obj := c.repo.Storer.NewEncodedObject()
+	obj.SetType(t)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return c.repo.Storer.SetEncodedObject(obj)
+}
+
+// validateNotesCacheRef checks that ref is a notes ref.
+func validateNotesCacheRef(ref string) error {
+	if !plumbing.ReferenceName(ref).IsNote() || ref == "refs/notes/" || strings.ContainsAny(ref, " ~^:?*[\\") || strings.Contains(ref, "..") {
+		return fmt.Errorf("invalid notes cache ref %q, expected e.g. %q", ref, DefaultNotesCacheRef)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package action
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func armorPrivateKey(t *testing.T, e *openpgp.Entity) string {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("failed to armor key: %v", err)
+	}
+	if err := e.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("failed to serialize key: %v", err)
+	}
+	w.Close()
+	return buf.String()
+}
+
+func newTestNotesCacheConfig(t *testing.T, r *testRepo, signer *openpgp.Entity) Config {
+	t.Helper()
+
+	keyPath := filepath.Join(t.TempDir(), "notes-signing-key.asc")
+	writeTestFile(t, keyPath, armorPrivateKey(t, signer))
+	return Config{
+		RepoPath:                     r.Path,
+		Repository:                   "byndid/auth-commit-sig",
+		NotesCacheRef:                DefaultNotesCacheRef,
+		NotesCacheSigningKeyFilePath: keyPath,
+	}
+}
+
+func TestNotesCache(t *testing.T) {
+	signer := newTestEntity(t, "Notes", "notes@example.com")
+	other := newTestEntity(t, "Mallory", "mallory@example.com")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := newTestRepo(t)
+	passed := r.commit(testCommitOptions{})
+	failed := r.commit(testCommitOptions{Parents: []plumbing.Hash{passed}})
+
+	cfg := newTestNotesCacheConfig(t, r, signer)
+	allowlist := &AllowlistYAML{}
+	c, err := NewNotesCache(cfg, allowlist)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, h := range []plumbing.Hash{passed, failed} {
+		commit, err := r.CommitObject(h)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		o := &Outcome{Repository: cfg.Repository, Errors: []OutcomeError{}}
+		o.SetCommit(commit)
+		if h == passed {
+			o.SetResultAndDescription(PASS, "passed")
+		} else {
+			o.SetResultAndDescription(FAIL, "failed")
+		}
+		if err := c.Put(o, now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n, err := c.Flush(now); err != nil || n != 1 {
+		t.Fatalf("expected 1 note written, got %d, %v", n, err)
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		c, err := NewNotesCache(cfg, allowlist)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		o, err := c.Get(passed, now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if o == nil || o.Result != PASS || o.Desc != "passed" || !o.Cached || o.Commit.CommitHash != passed.String() {
+			t.Errorf("unexpected cached outcome %+v", o)
+		}
+	})
+
+	t.Run("default_max_age", func(t *testing.T) {
+		o, err := c.Get(passed, now.Add(DefaultNotesCacheMaxAge+time.Hour))
+		if o != nil || err == nil || !strings.Contains(err.Error(), "more than 168h0m0s ago") {
+			t.Errorf("expected the cached outcome to be too old, got %+v, %v", o, err)
+		}
+	})
+
+	t.Run("failures_not_cached", func(t *testing.T) {
+		o, err := c.Get(failed, now)
+		if err != nil || o != nil {
+			t.Errorf("expected no cached outcome, got %+v, %v", o, err)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		modify      func(cfg *Config)
+		allowlist   *AllowlistYAML
+		expectedErr string
+	}{
+		{
+			name: "other_allowlist",
+			allowlist: &AllowlistYAML{NonMergeCommitAllowlist: Allowlist{EmailAddresses: []EmailAddressEntry{
+				{EmailAddress: "jane@doe.com", Repositories: []string{"*"}},
+			}}},
+			expectedErr: "note was made with a different allowlist",
+		},
+		{
+			name:        "other_repository",
+			modify:      func(cfg *Config) { cfg.Repository = "byndid/other" },
+			expectedErr: `note is for repository "byndid/auth-commit-sig"`,
+		},
+		{
+			name:        "other_settings",
+			modify:      func(cfg *Config) { cfg.VerifyMergedCommits = true },
+			expectedErr: "note was made with different settings",
+		},
+		{
+			name: "other_third_party_keyring",
+			modify: func(cfg *Config) {
+				keyRingPath := filepath.Join(t.TempDir(), "third-party.asc")
+				writeTestFile(t, keyRingPath, armorPublicKey(t, other))
+				cfg.ThirdPartyKeyRingFilePath = keyRingPath
+			},
+			expectedErr: "note was made with different settings",
+		},
+		{
+			name:        "other_keyserver",
+			modify:      func(cfg *Config) { cfg.KeyserverURL = "https://keys.example.com" },
+			expectedErr: "note was made with different settings",
+		},
+		{
+			name: "other_platform_keys",
+			modify: func(cfg *Config) {
+				dir := t.TempDir()
+				writeTestFile(t, filepath.Join(dir, PlatformGitHub+".asc"), armorPublicKey(t, other))
+				cfg.PlatformKeysDir = dir
+			},
+			expectedErr: "note was made with different settings",
+		},
+		{
+			name:        "other_api",
+			modify:      func(cfg *Config) { cfg.APIBaseURL = "https://api.example.com" },
+			expectedErr: "note was made with different settings",
+		},
+		{
+			name: "offline_snapshot",
+			modify: func(cfg *Config) {
+				keyRingPath := filepath.Join(t.TempDir(), "snapshot.asc")
+				writeTestFile(t, keyRingPath, armorPublicKey(t, other))
+				cfg.OfflineSnapshotFile = "snapshot.json"
+				cfg.OfflineSnapshotKeyRingFilePath = keyRingPath
+			},
+			expectedErr: "note was made with different settings",
+		},
+		{
+			name: "untrusted_signer",
+			modify: func(cfg *Config) {
+				keyRingPath := filepath.Join(t.TempDir(), "keyring.asc")
+				writeTestFile(t, keyRingPath, armorPublicKey(t, other))
+				cfg.NotesCacheKeyRingFilePath = keyRingPath
+			},
+			expectedErr: "note signature check failed",
+		},
+		{
+			name:        "max_age",
+			modify:      func(cfg *Config) { cfg.NotesCacheMaxAge = time.Minute },
+			expectedErr: "more than 1m0s ago",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+			al := allowlist
+			if tt.allowlist != nil {
+				al = tt.allowlist
+			}
+			c, err := NewNotesCache(cfg, al)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			o, err := c.Get(passed, now.Add(time.Hour))
+			if o != nil || err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("expected error containing %q, got %+v, %v", tt.expectedErr, o, err)
+			}
+		})
+	}
+}
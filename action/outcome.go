@@ -1,6 +1,7 @@
 package action
 
 import (
+	"errors"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -15,17 +16,54 @@ type Outcome struct {
 	Desc                string               `json:"desc"`
 	VerificationDetails *VerificationDetails `json:"verification_details,omitempty"`
 	Errors              []OutcomeError       `json:"errors"`
+	// AllowlistCommit is the hash of the commit the allowlist was read from, if
+	// it was read from a git ref.
+	AllowlistCommit string `json:"allowlist_commit,omitempty"`
+	// Commits are the outcomes of the commits of a range, oldest first, if a
+	// range was verified.
+	Commits []*Outcome `json:"commits,omitempty"`
+	// FailedMergedCommits are the outcomes of the commits brought in by a merge
+	// commit that failed verification, if merged commits are verified.
+	FailedMergedCommits []*Outcome `json:"failed_merged_commits,omitempty"`
+	// UnverifiableCommits are the hashes of the commits whose parents are
+	// missing from a shallow clone, which could not be verified together with
+	// their ancestors.
+	UnverifiableCommits []string `json:"unverifiable_commits,omitempty"`
+	// Cached is set if the outcome was read from the notes cache instead of
+	// verifying the commit again.
+	Cached bool `json:"cached,omitempty"`
+	// UnauthorizedCoAuthors are the email addresses of the co-authors of the
+	// commit that are not authorized, if co-authors are verified.
+	UnauthorizedCoAuthors []string `json:"unauthorized_co_authors,omitempty"`
+	// BreakGlassCommits are the hashes of the commits whose failed
+	// verification was overridden by a break-glass token, including those of
+	// a range.
+	BreakGlassCommits []string `json:"break_glass_commits,omitempty"`
+	// ReportOnly is set if the enforcement is report-only, in which case
+	// failures have the result WARN instead of FAIL.
+	ReportOnly bool `json:"report_only,omitempty"`
+
+	// errs are the errors added by SetErrors, see CommitResult.Errors.
+	errs []error
 }
 
 // Commit contains information about a commit.
 type Commit struct {
-	CommitHash     string   `json:"commit_hash"`
-	TreeHash       string   `json:"tree_hash"`
-	ParentHashes   []string `json:"parent_hashes"`
-	Author         *Actor   `json:"author"`
-	Committer      *Actor   `json:"committer"`
-	Signed         bool     `json:"signed"`
-	SignatureKeyID string   `json:"signature_key_id,omitempty"`
+	CommitHash   string   `json:"commit_hash"`
+	TreeHash     string   `json:"tree_hash"`
+	ParentHashes []string `json:"parent_hashes"`
+	// Kind is the kind of the commit (e.g. "merge"), see ClassifyCommit.
+	Kind           string `json:"kind"`
+	Author         *Actor `json:"author"`
+	Committer      *Actor `json:"committer"`
+	Signed         bool   `json:"signed"`
+	SignatureKeyID string `json:"signature_key_id,omitempty"`
+	// SignatureFingerprint is only set if the signature contains an issuer
+	// fingerprint subpacket.
+	SignatureFingerprint string `json:"signature_fingerprint,omitempty"`
+	// CoAuthors are the email addresses of the Co-authored-by trailers of the
+	// commit message, see ParseCoAuthors.
+	CoAuthors []string `json:"co_authors,omitempty"`
 }
 
 // Actor represents a commit actor.
@@ -38,15 +76,32 @@ type Actor struct {
 // VerificationDetails contains information about how the commit
 // signature was verified.
 type VerificationDetails struct {
-	VerifiedBy    string         `json:"verified_by"`
-	EmailAddress  string         `json:"email_address,omitempty"`
-	ThirdPartyKey *ThirdPartyKey `json:"third_party_key,omitempty"`
-	BIManagedKey  *BIManagedKey  `json:"bi_managed_key,omitempty"`
+	VerifiedBy    string              `json:"verified_by"`
+	EmailAddress  string              `json:"email_address,omitempty"`
+	ThirdPartyKey *ThirdPartyKey      `json:"third_party_key,omitempty"`
+	PlatformKey   *PlatformKey        `json:"platform_key,omitempty"`
+	BIManagedKey  *BIManagedKey       `json:"bi_managed_key,omitempty"`
+	BreakGlass    *BreakGlassOverride `json:"break_glass,omitempty"`
 }
 
 // ThirdPartyKey represents a third party key that was used to
 // sign a commit.
+//
+// KeyID and Fingerprint identify the primary key, while SigningKeyID and
+// SigningFingerprint identify the key (primary key or subkey) that produced
+// the signature.
 type ThirdPartyKey struct {
+	KeyID              string `json:"key_id"`
+	Fingerprint        string `json:"fingerprint"`
+	SigningKeyID       string `json:"signing_key_id"`
+	SigningFingerprint string `json:"signing_fingerprint"`
+	UserID             string `json:"user_id"`
+}
+
+// PlatformKey represents a platform signing key (e.g. GitHub's web-flow
+// key) that was used to sign a commit.
+type PlatformKey struct {
+	Platform    string `json:"platform"`
 	KeyID       string `json:"key_id"`
 	Fingerprint string `json:"fingerprint"`
 	UserID      string `json:"user_id"`
@@ -56,11 +111,26 @@ type ThirdPartyKey struct {
 // used to sign a commit.
 type BIManagedKey struct {
 	KeyID        string `json:"key_id"`
+	Fingerprint  string `json:"fingerprint,omitempty"`
 	EmailAddress string `json:"email_address"`
 }
 
+// BreakGlassOverride represents a break-glass token that overrode the failed
+// verification of a commit.
+type BreakGlassOverride struct {
+	Token BreakGlassToken `json:"token"`
+	// Source says where the token was supplied, e.g. in the trailer of a
+	// commit.
+	Source            string `json:"source"`
+	SignerFingerprint string `json:"signer_fingerprint"`
+	SignerUserID      string `json:"signer_user_id"`
+}
+
 // OutcomeError represents an error that occurred during the action.
 type OutcomeError struct {
+	// Code identifies the kind of error, for errors that have a dedicated
+	// code (e.g. ErrCodeCryptoPolicy).
+	Code string `json:"code,omitempty"`
 	Desc string `json:"desc"`
 }
 
@@ -88,18 +158,46 @@ func (o *Outcome) SetVerificationDetailsThirdPartyKey(tpk *ThirdPartyKey) {
 	}
 }
 
+// SetVerificationDetailsPlatformKey sets the verification details with
+// a commit signed by a platform key.
+func (o *Outcome) SetVerificationDetailsPlatformKey(pk *PlatformKey) {
+	o.VerificationDetails = &VerificationDetails{
+		VerifiedBy:  "PLATFORM_KEY",
+		PlatformKey: pk,
+	}
+}
+
 // SetVerificationDetailsBIManagedKey sets the verification details with
 // a commit signed by a Beyond Identity managed key.
+//
+// Deprecated: Use SetVerificationDetailsBIManagedKeyIssuer, which also sets
+// the key fingerprint.
 func (o *Outcome) SetVerificationDetailsBIManagedKey(keyID, emailAddress string) {
+	o.SetVerificationDetailsBIManagedKeyIssuer(&SignatureIssuer{KeyID: keyID}, emailAddress)
+}
+
+// SetVerificationDetailsBIManagedKeyIssuer sets the verification details with
+// a commit signed by a Beyond Identity managed key.
+func (o *Outcome) SetVerificationDetailsBIManagedKeyIssuer(issuer *SignatureIssuer, emailAddress string) {
 	o.VerificationDetails = &VerificationDetails{
 		VerifiedBy: "BI_MANAGED_KEY",
 		BIManagedKey: &BIManagedKey{
-			KeyID:        keyID,
+			KeyID:        issuer.KeyID,
+			Fingerprint:  issuer.Fingerprint,
 			EmailAddress: emailAddress,
 		},
 	}
 }
 
+// SetVerificationDetailsBreakGlass sets the verification details with a
+// commit whose failed verification was overridden by a break-glass token.
+func (o *Outcome) SetVerificationDetailsBreakGlass(override *BreakGlassOverride) {
+	o.VerificationDetails = &VerificationDetails{
+		VerifiedBy: "BREAK_GLASS",
+		BreakGlass: override,
+	}
+}
+
 // SetCommit sets the Commit field within the Outcome.
 func (o *Outcome) SetCommit(c *object.Commit) {
 	pHashes := []string{}
@@ -113,6 +211,7 @@ func (o *Outcome) SetCommit(c *object.Commit) {
 		CommitHash:   c.Hash.String(),
 		TreeHash:     c.TreeHash.String(),
 		ParentHashes: pHashes,
+		Kind:         ClassifyCommit(c),
 		Author: &Actor{
 			Name:         c.Author.Name,
 			EmailAddress: c.Author.Email,
@@ -123,12 +222,18 @@ func (o *Outcome) SetCommit(c *object.Commit) {
 			EmailAddress: c.Committer.Email,
 			Timestamp:    c.Committer.When,
 		},
-		Signed: len(c.PGPSignature) > 0,
+		Signed:    len(c.PGPSignature) > 0,
+		CoAuthors: ParseCoAuthors(c.Message),
 	}
 }
 
-// NewOutcomeError converts an error into an OutcomeError.
+// NewOutcomeError converts an error into an OutcomeError. If the error, or an
+// error it wraps, has a Code() method, the code is included.
 func NewOutcomeError(err error) OutcomeError {
+	var coded interface{ Code() string }
+	if errors.As(err, &coded) {
+		return OutcomeError{Code: coded.Code(), Desc: err.Error()}
+	}
 	return OutcomeError{Desc: err.Error()}
 }
 
@@ -137,4 +242,5 @@ func (o *Outcome) SetErrors(errs ...error) {
 	for _, err := range errs {
 		o.Errors = append(o.Errors, NewOutcomeError(err))
 	}
+	o.errs = append(o.errs, errs...)
 }
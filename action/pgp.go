@@ -1,9 +1,12 @@
 package action
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strings"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
@@ -11,19 +14,61 @@ import (
 	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
+// SignatureIssuer identifies the key that produced a signature.
+type SignatureIssuer struct {
+	// KeyID is the canonical string representation of the issuer's PGP Key ID.
+	KeyID string
+	// Fingerprint is the hex encoded fingerprint of the issuer's key, if the
+	// signature contains an issuer fingerprint subpacket.
+	Fingerprint string
+
+	keyID uint64
+}
+
 // ParseSignatureIssuerKeyID parses an ASCII-armored PGP signature and extracts
 // the PGP Key ID of the key that produced it.
+//
+// Deprecated: Use ParseSignatureIssuer, which also extracts the fingerprint.
 func ParseSignatureIssuerKeyID(armoredSignature string) (string, error) {
+	issuer, err := ParseSignatureIssuer(armoredSignature)
+	if err != nil {
+		return "", err
+	}
+	return issuer.KeyID, nil
+}
+
+// ParseSignatureIssuer parses an ASCII-armored PGP signature and identifies the
+// key that produced it. Prefers the issuer fingerprint subpacket and falls back
+// to the issuer key ID subpacket.
+func ParseSignatureIssuer(armoredSignature string) (*SignatureIssuer, error) {
 	signature, err := parseSignature(armoredSignature)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse signature: %w", err)
+		return nil, fmt.Errorf("failed to parse signature: %w", err)
+	}
+
+	return signatureIssuer(signature)
+}
+
+// signatureIssuer identifies the key that produced the parsed signature.
+func signatureIssuer(signature *packet.Signature) (*SignatureIssuer, error) {
+	if fp := signature.IssuerFingerprint; len(fp) > 0 {
+		var keyID uint64
+		switch len(fp) {
+		case 20: // v4 keys use the low 64 bits of the fingerprint
+			keyID = binary.BigEndian.Uint64(fp[12:20])
+		case 32: // v5 keys use the high 64 bits of the fingerprint
+			keyID = binary.BigEndian.Uint64(fp[:8])
+		default:
+			return nil, fmt.Errorf("signature has issuer fingerprint of unsupported length %d", len(fp))
+		}
+		return &SignatureIssuer{KeyID: formatPGPKeyID(keyID), Fingerprint: formatFingerprint(fp), keyID: keyID}, nil
 	}
 
 	if signature.IssuerKeyId == nil {
-		return "", fmt.Errorf("signature missing issuer key id subpacket")
+		return nil, fmt.Errorf("signature missing issuer fingerprint and issuer key id subpackets")
 	}
 
-	return formatPGPKeyID(*signature.IssuerKeyId), nil
+	return &SignatureIssuer{KeyID: formatPGPKeyID(*signature.IssuerKeyId), keyID: *signature.IssuerKeyId}, nil
 }
 
 // parseSignature parses an ASCII-armored PGP signature. Expects a single
@@ -60,19 +105,159 @@ func formatPGPKeyID(keyID uint64) string {
 	return fmt.Sprintf("%016X", keyID) // note required 0 padding
 }
 
+// findKeyByFingerprint returns the primary key or subkey of the entity with the
+// hex encoded fingerprint, or nil if there is none.
+func findKeyByFingerprint(e *openpgp.Entity, fingerprint string) *packet.PublicKey {
+	want := normalizeFingerprint(fingerprint)
+	if formatFingerprint(e.PrimaryKey.Fingerprint) == want {
+		return e.PrimaryKey
+	}
+	for _, sk := range e.Subkeys {
+		if formatFingerprint(sk.PublicKey.Fingerprint) == want {
+			return sk.PublicKey
+		}
+	}
+	return nil
+}
+
+// findSigningKey returns the primary key or subkey of the entity that issued
+// the signature, or nil if there is none.
+func findSigningKey(e *openpgp.Entity, signature *packet.Signature) *packet.PublicKey {
+	if signature.CheckKeyIdOrFingerprint(e.PrimaryKey) {
+		return e.PrimaryKey
+	}
+	for _, sk := range e.Subkeys {
+		if signature.CheckKeyIdOrFingerprint(sk.PublicKey) {
+			return sk.PublicKey
+		}
+	}
+	return nil
+}
+
+// armorEntity returns the ASCII-armored public key of the entity.
+func armorEntity(e *openpgp.Entity) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor key: %w", err)
+	}
+	if err := e.Serialize(w); err != nil {
+		return nil, fmt.Errorf("failed to serialize key: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to armor key: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
 // CheckSignatureByKey checks that `signature` is valid for `payload`
-// with the PGP public key in `base64Key`.
+// with the PGP public key in `base64Key` and allowed by the default
+// CryptoPolicy.
+//
+// Deprecated: Use CheckSignatureByKeyWithPolicy.
 func CheckSignatureByKey(base64Key, armoredSignature, payload string) error {
+	return CheckSignatureByKeyWithPolicy(base64Key, armoredSignature, payload, CryptoPolicy{})
+}
+
+// CheckSignatureByKeyWithPolicy checks that `signature` is valid for `payload`
+// with the PGP public key in `base64Key` and allowed by the policy.
+func CheckSignatureByKeyWithPolicy(base64Key, armoredSignature, payload string, policy CryptoPolicy) error {
 	// Parse the key into a key ring containing only this key.
 	keyRing, err := openpgp.ReadKeyRing(base64.NewDecoder(base64.StdEncoding, strings.NewReader(base64Key)))
 	if err != nil {
 		return fmt.Errorf("failed to parse key: %w", err)
 	}
 
-	_, err = openpgp.CheckArmoredDetachedSignature(keyRing, strings.NewReader(payload), strings.NewReader(armoredSignature), nil)
+	_, _, err = checkArmoredDetachedSignature(keyRing, payload, armoredSignature, policy)
 	if err != nil {
 		return fmt.Errorf("signature verification failed: %w", err)
 	}
 
 	return nil
 }
+
+// checkArmoredDetachedSignature checks that the ASCII-armored signature is valid
+// for the payload with a key from the key ring, and that both the signature and
+// the key are allowed by the policy. Returns the signer and the key (primary key
+// or subkey) that produced the signature.
+func checkArmoredDetachedSignature(keyRing openpgp.KeyRing, payload, armoredSignature string, policy CryptoPolicy) (*openpgp.Entity, *packet.PublicKey, error) {
+	block, err := armor.Decode(strings.NewReader(armoredSignature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if block.Type != openpgp.SignatureType {
+		return nil, nil, fmt.Errorf("expected armor type %q, got %q", openpgp.SignatureType, block.Type)
+	}
+
+	sigBytes, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p, err := packet.Read(bytes.NewReader(sigBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, nil, fmt.Errorf("packet is not a signature")
+	}
+
+	if err := policy.CheckSignature(sig); err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := openpgp.CheckDetachedSignatureAndHash(keyRing, strings.NewReader(payload), bytes.NewReader(sigBytes), policy.hashes(), policy.packetConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signingKey := findSigningKey(signer, sig)
+	if signingKey == nil {
+		return nil, nil, fmt.Errorf("signer has no key matching the signature issuer") // should never happen
+	}
+
+	if err := policy.CheckPublicKey(signingKey); err != nil {
+		return nil, nil, err
+	}
+
+	return signer, signingKey, nil
+}
+
+// LoadSigningKeyFile reads an ASCII-armored private key from a file, as
+// exported by `gpg --export-secret-keys --armor`. If the key is encrypted, it is
+// decrypted with passphrase.
+func LoadSigningKeyFile(filePath string, passphrase []byte) (*openpgp.Entity, error) {
+	bs, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key file at '%s': %w", filePath, err)
+	}
+
+	keyRing, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key file at '%s': %w", filePath, err)
+	}
+	if len(keyRing) != 1 || keyRing[0].PrivateKey == nil {
+		return nil, fmt.Errorf("signing key file at '%s' must contain exactly one private key", filePath)
+	}
+
+	e := keyRing[0]
+	keys := []*packet.PrivateKey{e.PrivateKey}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey != nil {
+			keys = append(keys, subkey.PrivateKey)
+		}
+	}
+	for _, key := range keys {
+		if !key.Encrypted {
+			continue
+		}
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("signing key file at '%s' is encrypted and no passphrase is set", filePath)
+		}
+		if err := key.Decrypt(passphrase); err != nil {
+			return nil, fmt.Errorf("failed to decrypt signing key: %w", err)
+		}
+	}
+	return e, nil
+}
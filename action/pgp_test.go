@@ -1,7 +1,14 @@
 package action
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
 func TestCheckSignatureByKey(t *testing.T) {
@@ -129,6 +136,132 @@ nonsense
 	}
 }
 
+func TestParseSignatureIssuer(t *testing.T) {
+	const (
+		keyID       = "E772A191C1EEDEC5"
+		fingerprint = "4317A395D755D1DF53308A9FE772A191C1EEDEC5"
+	)
+	fp, _ := hex.DecodeString(fingerprint)
+	id := fp[12:]
+
+	tests := []struct {
+		name             string
+		armoredSignature string
+		// collidingKeys is the number of distinct allowlisted keys with the
+		// key ID of the issuer.
+		collidingKeys     int
+		expected          SignatureIssuer
+		expectedAmbiguous bool
+		expectedErr       string
+	}{
+		{
+			name: "issuer_and_issuer_fingerprint",
+			armoredSignature: `-----BEGIN PGP SIGNATURE-----
+
+iJUEABMIAD0WIQRDF6OV11XR31Mwip/ncqGRwe7exQUCYOrmfh8cZm9yZC5odXJs
+ZXlAYmV5b25kaWRlbnRpdHkuY29tAAoJEOdyoZHB7t7Fs/MA/jfzo9cigGqEvmVz
+YIKMWCp0G4FD2Gp54QLlr5osrtf9AP98F4zFigLfCQG7ria/lxvyjHx0khmpFpO4
+Q/RgcSQ+iw==
+=gFHw
+-----END PGP SIGNATURE-----
+`,
+			expected: SignatureIssuer{KeyID: keyID, Fingerprint: fingerprint},
+		},
+		{
+			name:             "issuer_fingerprint_only",
+			armoredSignature: armorTestSignature(t, testSubpacket(33, append([]byte{4}, fp...))),
+			expected:         SignatureIssuer{KeyID: keyID, Fingerprint: fingerprint},
+		},
+		{
+			name:             "issuer_key_id_only",
+			armoredSignature: armorTestSignature(t, testSubpacket(16, id)),
+			collidingKeys:    1,
+			expected:         SignatureIssuer{KeyID: keyID},
+		},
+		{
+			name:              "colliding_issuer_key_id",
+			armoredSignature:  armorTestSignature(t, testSubpacket(16, id)),
+			collidingKeys:     2,
+			expected:          SignatureIssuer{KeyID: keyID},
+			expectedAmbiguous: true,
+		},
+		{
+			// The fingerprint identifies the issuer among keys sharing its key ID.
+			name:             "colliding_key_id_with_issuer_fingerprint",
+			armoredSignature: armorTestSignature(t, testSubpacket(33, append([]byte{4}, fp...))),
+			collidingKeys:    2,
+			expected:         SignatureIssuer{KeyID: keyID, Fingerprint: fingerprint},
+		},
+		{
+			name:             "no_issuer",
+			armoredSignature: armorTestSignature(t),
+			expectedErr:      "signature missing issuer fingerprint and issuer key id subpackets",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignatureIssuer(tt.armoredSignature)
+			assertEqualErr(t, tt.expectedErr, err)
+			if err != nil {
+				return
+			}
+
+			if got.KeyID != tt.expected.KeyID || got.Fingerprint != tt.expected.Fingerprint {
+				t.Errorf("expected %+v, got %+v", tt.expected, *got)
+			}
+
+			keyRings := []openpgp.EntityList{}
+			for i := 0; i < tt.collidingKeys; i++ {
+				e := newTestEntity(t, "Jane Doe", "jane@doe.com")
+				e.PrimaryKey.KeyId = binary.BigEndian.Uint64(id)
+				keyRings = append(keyRings, openpgp.EntityList{e})
+			}
+			// As in verifyCommit, key IDs are only ambiguous without a fingerprint.
+			ambiguous := got.Fingerprint == "" && len(findIssuerKeyIDCollisions(got, keyRings)) > 1
+			if ambiguous != tt.expectedAmbiguous {
+				t.Errorf("expected ambiguous %v, got %v", tt.expectedAmbiguous, ambiguous)
+			}
+		})
+	}
+}
+
+// testSubpacket encodes a hashed signature subpacket of the type.
+func testSubpacket(subpacketType byte, contents []byte) []byte {
+	return append([]byte{byte(len(contents) + 1), subpacketType}, contents...)
+}
+
+// armorTestSignature returns an ASCII-armored v4 EdDSA signature packet with a
+// creation time and the given subpackets, so that the issuer subpackets can be
+// chosen. The signature itself is not valid.
+func armorTestSignature(t *testing.T, subpackets ...[]byte) string {
+	t.Helper()
+
+	hashed := testSubpacket(2, []byte{0x63, 0x16, 0x0b, 0x2c})
+	for _, sp := range subpackets {
+		hashed = append(hashed, sp...)
+	}
+	body := []byte{4, 0x00, byte(packet.PubKeyAlgoEdDSA), 8, 0, byte(len(hashed))}
+	body = append(body, hashed...)
+	// No unhashed subpackets, the hash tag and the r and s MPIs.
+	body = append(body, 0, 0, 0xab, 0xcd)
+	for i := 0; i < 2; i++ {
+		body = append(body, 1, 0)
+		body = append(body, bytes.Repeat([]byte{0x80}, 32)...)
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, "PGP SIGNATURE", nil)
+	if err != nil {
+		t.Fatalf("failed to armor signature: %v", err)
+	}
+	// A new format signature packet with a one-octet length.
+	_, _ = w.Write(append([]byte{0xc2, byte(len(body))}, body...))
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to armor signature: %v", err)
+	}
+	return buf.String()
+}
+
 func assertEqualErr(t *testing.T, expected string, err error) {
 	t.Helper()
 
@@ -0,0 +1,145 @@
+package action
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+const (
+	// PlatformGitHub is the platform name for commits created and signed by
+	// GitHub (e.g. squash merges and web edits signed by the web-flow key).
+	PlatformGitHub = "github"
+	// PlatformGitLab is the platform name for commits created and signed by
+	// GitLab (e.g. web edits and merge requests merged through the UI).
+	PlatformGitLab = "gitlab"
+
+	// DefaultPlatformKeysDir is the directory in the action image where the
+	// bundled platform signing keys are stored.
+	DefaultPlatformKeysDir = "/etc/auth-commit-sig/platform-keys"
+)
+
+// PlatformKeySource describes where the signing keys for a platform are
+// published and which key fingerprints are expected.
+type PlatformKeySource struct {
+	// Platform is the name of the platform (e.g. "github").
+	Platform string
+	// URL is where the platform publishes its signing keys. Empty if the key
+	// is specific to an instance of the platform (e.g. self-managed GitLab).
+	URL string
+	// Fingerprints is the list of hex encoded primary key fingerprints that
+	// are accepted for the platform. If empty, any key in the platform key
+	// file is accepted.
+	Fingerprints []string
+	// CommitterEmails is the list of committer email addresses the platform
+	// commits with. Non-merge commits signed by the platform key are only
+	// trusted if committed by one of them, see PlatformKeyEntry.
+	CommitterEmails []string
+}
+
+// PlatformKeySources is the set of platforms with bundled signing keys. The
+// keys themselves are checked in under platform-keys/, updated from URL with
+// `make update-platform-keys`, and copied into DefaultPlatformKeysDir when the
+// action image is built.
+var PlatformKeySources = []PlatformKeySource{
+	{
+		Platform: PlatformGitHub,
+		URL:      "https://github.com/web-flow.gpg",
+		Fingerprints: []string{
+			"5DE3E0509C47EA3CF04A42D34AEE18F83AFDEB23",
+			"968479A1AFF927E37D1A566BB5690EEEBB952194",
+		},
+		CommitterEmails: []string{"noreply@github.com"},
+	},
+	{
+		// GitLab signs web commits with a key specific to each instance, so
+		// there is no bundled key. Place the instance key in the platform keys
+		// directory as gitlab.asc, and list the committer email addresses of
+		// the instance in committer_emails.
+		Platform: PlatformGitLab,
+	},
+}
+
+// getPlatformKeySource returns the PlatformKeySource for the platform, if it
+// is known.
+func getPlatformKeySource(platform string) (PlatformKeySource, bool) {
+	for _, s := range PlatformKeySources {
+		if s.Platform == platform {
+			return s, true
+		}
+	}
+	return PlatformKeySource{}, false
+}
+
+// LoadPlatformKeys reads the ASCII-armored signing keys for each platform from
+// "<platform>.asc" within dir. Keys whose fingerprint is not pinned for the
+// platform are rejected.
+func LoadPlatformKeys(dir string, platforms []string) (map[string]openpgp.EntityList, error) {
+	keyRings := map[string]openpgp.EntityList{}
+	for _, platform := range platforms {
+		if _, ok := keyRings[platform]; ok {
+			continue
+		}
+
+		source, ok := getPlatformKeySource(platform)
+		if !ok {
+			return nil, fmt.Errorf("unknown platform: %q", platform)
+		}
+
+		filePath := filepath.Join(dir, platform+".asc")
+		f, err := os.Open(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no signing key available for platform %q at '%s'", platform, filePath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open platform key file at '%s': %w", filePath, err)
+		}
+
+		keyRing, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse platform key file at '%s': %w", filePath, err)
+		}
+
+		if len(source.Fingerprints) > 0 {
+			for _, e := range keyRing {
+				fp := formatFingerprint(e.PrimaryKey.Fingerprint)
+				if !containsFingerprint(fp, source.Fingerprints) {
+					return nil, fmt.Errorf("platform key file at '%s' contains unexpected key with fingerprint %s", filePath, fp)
+				}
+			}
+		}
+
+		keyRings[platform] = keyRing
+	}
+	return keyRings, nil
+}
+
+// formatFingerprint returns the canonical string representation of a PGP key
+// fingerprint (upper case hex).
+func formatFingerprint(fingerprint []byte) string {
+	return strings.ToUpper(hex.EncodeToString(fingerprint))
+}
+
+// containsFingerprint checks if the fingerprint is within an array of
+// fingerprints. Comparison ignores case and whitespace.
+func containsFingerprint(fingerprint string, fingerprints []string) bool {
+	want := normalizeFingerprint(fingerprint)
+	for _, fp := range fingerprints {
+		if normalizeFingerprint(fp) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeFingerprint upper cases a hex fingerprint and strips any spaces, as
+// commonly printed by gpg.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(fingerprint), ""))
+}
@@ -0,0 +1,110 @@
+package action
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+func TestLoadPlatformKeys(t *testing.T) {
+	entity := newTestEntity(t, "GitLab", "noreply@gitlab.example.com")
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "gitlab.asc"), armorPublicKey(t, entity))
+	writeTestFile(t, filepath.Join(dir, "github.asc"), armorPublicKey(t, entity))
+
+	tests := []struct {
+		name        string
+		platforms   []string
+		expectedErr string
+	}{
+		{
+			name:      "unpinned_platform",
+			platforms: []string{PlatformGitLab},
+		},
+		{
+			name:        "unknown_platform",
+			platforms:   []string{"sourcehut"},
+			expectedErr: `unknown platform: "sourcehut"`,
+		},
+		{
+			name:        "unexpected_fingerprint",
+			platforms:   []string{PlatformGitHub},
+			expectedErr: "platform key file at '" + filepath.Join(dir, "github.asc") + "' contains unexpected key with fingerprint " + formatFingerprint(entity.PrimaryKey.Fingerprint),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyRings, err := LoadPlatformKeys(dir, tt.platforms)
+			assertEqualErr(t, tt.expectedErr, err)
+
+			if err == nil && len(keyRings[tt.platforms[0]]) != 1 {
+				t.Errorf("expected 1 key for %s, got %d", tt.platforms[0], len(keyRings[tt.platforms[0]]))
+			}
+		})
+	}
+
+	t.Run("missing_key_file", func(t *testing.T) {
+		_, err := LoadPlatformKeys(t.TempDir(), []string{PlatformGitLab})
+		if err == nil {
+			t.Errorf("expected error for missing key file, got nil")
+		}
+	})
+}
+
+// newTestEntity generates an EdDSA signing key for tests.
+func newTestEntity(t *testing.T, name, email string) *openpgp.Entity {
+	t.Helper()
+
+	e, err := openpgp.NewEntity(name, "", email, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return e
+}
+
+// armorPublicKey returns the ASCII-armored public key of the entity.
+func armorPublicKey(t *testing.T, e *openpgp.Entity) string {
+	t.Helper()
+
+	armored, err := armorEntity(e)
+	if err != nil {
+		t.Fatalf("failed to armor key: %v", err)
+	}
+	return string(armored)
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// TestBundledPlatformKeys checks the platform keys checked in under
+// platform-keys against the fingerprints pinned in PlatformKeySources.
+func TestBundledPlatformKeys(t *testing.T) {
+	for _, source := range PlatformKeySources {
+		if source.URL == "" {
+			continue
+		}
+		t.Run(source.Platform, func(t *testing.T) {
+			keyRings, err := LoadPlatformKeys(filepath.Join("..", "platform-keys"), []string{source.Platform})
+			if err != nil {
+				t.Fatalf("failed to load bundled keys, run `make update-platform-keys`: %v", err)
+			}
+			for _, fp := range source.Fingerprints {
+				found := false
+				for _, e := range keyRings[source.Platform] {
+					found = found || containsFingerprint(formatFingerprint(e.PrimaryKey.Fingerprint), []string{fp})
+				}
+				if !found {
+					t.Errorf("pinned key %s is not bundled", fp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package action
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/ecdsa"
+	"github.com/ProtonMail/go-crypto/openpgp/eddsa"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// ErrCodeCryptoPolicy is the OutcomeError code of a signature or key that
+// violates the CryptoPolicy.
+const ErrCodeCryptoPolicy = "CRYPTO_POLICY_VIOLATION"
+
+var (
+	// defaultAllowedHashes are the hash algorithms accepted for signatures if
+	// the CryptoPolicy does not list any.
+	defaultAllowedHashes = []string{"SHA256", "SHA384", "SHA512"}
+	// defaultAllowedCurves are the elliptic curves accepted for keys if the
+	// CryptoPolicy does not list any.
+	defaultAllowedCurves = []string{"P256", "P384", "P521", "Ed25519", "Ed448"}
+	// defaultAllowedPublicKeyAlgorithms are the public key algorithms accepted
+	// for keys if the CryptoPolicy does not list any.
+	defaultAllowedPublicKeyAlgorithms = []string{"RSA", "ECDSA", "EdDSA"}
+	// defaultMinRSABits is the minimum RSA modulus size if the CryptoPolicy
+	// does not set one.
+	defaultMinRSABits = 2048
+)
+
+// hashAlgorithms maps the names accepted in a CryptoPolicy to hash algorithms.
+var hashAlgorithms = map[string]crypto.Hash{
+	"MD5":       crypto.MD5,
+	"SHA1":      crypto.SHA1,
+	"RIPEMD160": crypto.RIPEMD160,
+	"SHA224":    crypto.SHA224,
+	"SHA256":    crypto.SHA256,
+	"SHA384":    crypto.SHA384,
+	"SHA512":    crypto.SHA512,
+	"SHA3256":   crypto.SHA3_256,
+	"SHA3512":   crypto.SHA3_512,
+}
+
+// publicKeyAlgorithms maps the names accepted in a CryptoPolicy to the
+// signing public key algorithms.
+var publicKeyAlgorithms = map[string][]packet.PublicKeyAlgorithm{
+	"RSA":   {packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly},
+	"DSA":   {packet.PubKeyAlgoDSA},
+	"ECDSA": {packet.PubKeyAlgoECDSA},
+	"EDDSA": {packet.PubKeyAlgoEdDSA},
+}
+
+// curves maps the normalized names accepted in a CryptoPolicy to the curve
+// names reported by go-crypto.
+var curves = map[string]string{
+	"P256":            "P-256",
+	"P384":            "P-384",
+	"P521":            "P-521",
+	"SECP256K1":       "secp256k1",
+	"BRAINPOOLP256R1": "brainpoolP256r1",
+	"BRAINPOOLP384R1": "brainpoolP384r1",
+	"BRAINPOOLP512R1": "brainpoolP512r1",
+	"ED25519":         "ed25519",
+	"ED448":           "ed448",
+}
+
+// CryptoPolicy restricts the algorithms accepted for commit signatures and the
+// keys that produce them. The zero value is a policy with secure defaults.
+type CryptoPolicy struct {
+	// AllowedHashes is the list of hash algorithms accepted for signatures
+	// (e.g. "SHA256"). Defaults to SHA256, SHA384 and SHA512.
+	AllowedHashes []string
+	// AllowedPublicKeyAlgorithms is the list of public key algorithms accepted
+	// for signing keys ("RSA", "DSA", "ECDSA" or "EdDSA"). Defaults to RSA,
+	// ECDSA and EdDSA.
+	AllowedPublicKeyAlgorithms []string
+	// MinRSABits is the minimum modulus size of RSA signing keys. Defaults to
+	// 2048.
+	MinRSABits int
+	// AllowedCurves is the list of elliptic curves accepted for ECDSA and
+	// EdDSA signing keys (e.g. "P256" or "Ed25519"). Defaults to P256, P384,
+	// P521, Ed25519 and Ed448.
+	AllowedCurves []string
+	// AllowV3Signatures accepts legacy version 3 signatures.
+	AllowV3Signatures bool
+
+	// now is the clock that signatures and keys are checked against, e.g. for
+	// expiry. Defaults to time.Now.
+	now func() time.Time
+}
+
+// CryptoPolicyError is returned when a signature or key violates the
+// CryptoPolicy.
+type CryptoPolicyError string
+
+func (e CryptoPolicyError) Error() string {
+	return fmt.Sprintf("crypto policy violation: %s", string(e))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e CryptoPolicyError) Code() string {
+	return ErrCodeCryptoPolicy
+}
+
+// Validate checks that all algorithms named in the CryptoPolicy are known.
+func (p CryptoPolicy) Validate() []error {
+	var errs []error
+	for _, name := range p.AllowedHashes {
+		if _, ok := hashAlgorithms[normalizeAlgorithmName(name)]; !ok {
+			errs = append(errs, fmt.Errorf("unknown hash algorithm in crypto policy: %q", name))
+		}
+	}
+	for _, name := range p.AllowedPublicKeyAlgorithms {
+		if _, ok := publicKeyAlgorithms[normalizeAlgorithmName(name)]; !ok {
+			errs = append(errs, fmt.Errorf("unknown public key algorithm in crypto policy: %q", name))
+		}
+	}
+	for _, name := range p.AllowedCurves {
+		if _, ok := curves[normalizeAlgorithmName(name)]; !ok {
+			errs = append(errs, fmt.Errorf("unknown curve in crypto policy: %q", name))
+		}
+	}
+	if p.MinRSABits < 0 {
+		errs = append(errs, fmt.Errorf("invalid minimum RSA bits in crypto policy: %d", p.MinRSABits))
+	}
+	return errs
+}
+
+// CheckSignature checks that the parsed signature is allowed by the policy.
+func (p CryptoPolicy) CheckSignature(sig *packet.Signature) error {
+	if sig.Version == 3 && !p.AllowV3Signatures {
+		return CryptoPolicyError("version 3 signatures are not allowed")
+	}
+
+	for _, h := range p.hashes() {
+		if sig.Hash == h {
+			return nil
+		}
+	}
+	return CryptoPolicyError(fmt.Sprintf("signature hash algorithm %s is not allowed", sig.Hash))
+}
+
+// CheckPublicKey checks that the public key that produced a signature is
+// allowed by the policy.
+func (p CryptoPolicy) CheckPublicKey(pk *packet.PublicKey) error {
+	allowed := false
+	for _, name := range orDefault(p.AllowedPublicKeyAlgorithms, defaultAllowedPublicKeyAlgorithms) {
+		for _, algo := range publicKeyAlgorithms[normalizeAlgorithmName(name)] {
+			if pk.PubKeyAlgo == algo {
+				allowed = true
+			}
+		}
+	}
+	if !allowed {
+		return CryptoPolicyError(fmt.Sprintf("public key algorithm %d of key %s is not allowed", pk.PubKeyAlgo, formatFingerprint(pk.Fingerprint)))
+	}
+
+	switch key := pk.PublicKey.(type) {
+	case *rsa.PublicKey:
+		minBits := p.MinRSABits
+		if minBits == 0 {
+			minBits = defaultMinRSABits
+		}
+		if bits := key.N.BitLen(); bits < minBits {
+			return CryptoPolicyError(fmt.Sprintf("RSA key %s has %d bits, at least %d are required", formatFingerprint(pk.Fingerprint), bits, minBits))
+		}
+	case *ecdsa.PublicKey:
+		return p.checkCurve(key.GetCurve().GetCurveName(), pk)
+	case *eddsa.PublicKey:
+		return p.checkCurve(key.GetCurve().GetCurveName(), pk)
+	}
+	return nil
+}
+
+// checkCurve checks that the curve of the public key is allowed by the policy.
+func (p CryptoPolicy) checkCurve(curveName string, pk *packet.PublicKey) error {
+	for _, name := range orDefault(p.AllowedCurves, defaultAllowedCurves) {
+		if curves[normalizeAlgorithmName(name)] == curveName {
+			return nil
+		}
+	}
+	return CryptoPolicyError(fmt.Sprintf("curve %s of key %s is not allowed", curveName, formatFingerprint(pk.Fingerprint)))
+}
+
+// hashes returns the hash algorithms allowed by the policy.
+func (p CryptoPolicy) hashes() []crypto.Hash {
+	hs := []crypto.Hash{}
+	for _, name := range orDefault(p.AllowedHashes, defaultAllowedHashes) {
+		if h, ok := hashAlgorithms[normalizeAlgorithmName(name)]; ok {
+			hs = append(hs, h)
+		}
+	}
+	return hs
+}
+
+// withClock returns a copy of the policy that checks signatures and keys
+// against now.
+func (p CryptoPolicy) withClock(now func() time.Time) CryptoPolicy {
+	p.now = now
+	return p
+}
+
+// packetConfig returns the go-crypto configuration used when verifying
+// signatures under the policy.
+func (p CryptoPolicy) packetConfig() *packet.Config {
+	config := &packet.Config{Time: p.now}
+	if config.Time == nil {
+		config.Time = time.Now
+	}
+	if hs := p.hashes(); len(hs) > 0 {
+		config.DefaultHash = hs[0]
+	}
+	return config
+}
+
+// normalizeAlgorithmName upper cases an algorithm name and strips separators,
+// so that e.g. "SHA-256", "sha256" and "SHA256" are equivalent.
+func normalizeAlgorithmName(name string) string {
+	return strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToUpper(name))
+}
+
+// orDefault returns values, or defaultValues if values is empty.
+func orDefault(values, defaultValues []string) []string {
+	if len(values) == 0 {
+		return defaultValues
+	}
+	return values
+}
@@ -0,0 +1,115 @@
+package action
+
+import (
+	"crypto"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+func TestCryptoPolicyCheckSignature(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      CryptoPolicy
+		sig         *packet.Signature
+		expectedErr string
+	}{
+		{
+			name:   "default_sha256",
+			policy: CryptoPolicy{},
+			sig:    &packet.Signature{Version: 4, Hash: crypto.SHA256},
+		},
+		{
+			name:        "default_sha1",
+			policy:      CryptoPolicy{},
+			sig:         &packet.Signature{Version: 4, Hash: crypto.SHA1},
+			expectedErr: "crypto policy violation: signature hash algorithm SHA-1 is not allowed",
+		},
+		{
+			name:   "allowed_sha1",
+			policy: CryptoPolicy{AllowedHashes: []string{"sha-1"}},
+			sig:    &packet.Signature{Version: 4, Hash: crypto.SHA1},
+		},
+		{
+			name:        "default_v3",
+			policy:      CryptoPolicy{},
+			sig:         &packet.Signature{Version: 3, Hash: crypto.SHA256},
+			expectedErr: "crypto policy violation: version 3 signatures are not allowed",
+		},
+		{
+			name:   "allowed_v3",
+			policy: CryptoPolicy{AllowV3Signatures: true},
+			sig:    &packet.Signature{Version: 3, Hash: crypto.SHA256},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckSignature(tt.sig)
+			assertEqualErr(t, tt.expectedErr, err)
+		})
+	}
+}
+
+func TestCryptoPolicyCheckPublicKey(t *testing.T) {
+	rsa1024, err := openpgp.NewEntity("RSA", "", "rsa@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoRSA, RSABits: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	ed25519 := newTestEntity(t, "EdDSA", "eddsa@example.com")
+
+	tests := []struct {
+		name        string
+		policy      CryptoPolicy
+		key         *packet.PublicKey
+		expectedErr string
+	}{
+		{
+			name:        "default_short_rsa",
+			policy:      CryptoPolicy{},
+			key:         rsa1024.PrimaryKey,
+			expectedErr: "crypto policy violation: RSA key " + formatFingerprint(rsa1024.PrimaryKey.Fingerprint) + " has 1024 bits, at least 2048 are required",
+		},
+		{
+			name:   "lowered_min_rsa_bits",
+			policy: CryptoPolicy{MinRSABits: 1024},
+			key:    rsa1024.PrimaryKey,
+		},
+		{
+			name:   "default_ed25519",
+			policy: CryptoPolicy{},
+			key:    ed25519.PrimaryKey,
+		},
+		{
+			name:        "disallowed_curve",
+			policy:      CryptoPolicy{AllowedCurves: []string{"P-256"}},
+			key:         ed25519.PrimaryKey,
+			expectedErr: "crypto policy violation: curve ed25519 of key " + formatFingerprint(ed25519.PrimaryKey.Fingerprint) + " is not allowed",
+		},
+		{
+			name:        "disallowed_algorithm",
+			policy:      CryptoPolicy{AllowedPublicKeyAlgorithms: []string{"RSA"}},
+			key:         ed25519.PrimaryKey,
+			expectedErr: "crypto policy violation: public key algorithm 22 of key " + formatFingerprint(ed25519.PrimaryKey.Fingerprint) + " is not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckPublicKey(tt.key)
+			assertEqualErr(t, tt.expectedErr, err)
+
+			if err != nil && NewOutcomeError(err).Code != ErrCodeCryptoPolicy {
+				t.Errorf("expected outcome error code %s, got %q", ErrCodeCryptoPolicy, NewOutcomeError(err).Code)
+			}
+		})
+	}
+}
+
+func TestCryptoPolicyValidate(t *testing.T) {
+	errs := CryptoPolicy{AllowedHashes: []string{"SHA256", "SHA-2"}, AllowedCurves: []string{"Curve1174"}}.Validate()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	assertEqualErr(t, `unknown hash algorithm in crypto policy: "SHA-2"`, errs[0])
+	assertEqualErr(t, `unknown curve in crypto policy: "Curve1174"`, errs[1])
+}
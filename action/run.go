@@ -5,7 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 const (
@@ -13,6 +18,10 @@ const (
 	PASS = "PASS"
 	// FAIL is the string representation of "FAIL".
 	FAIL = "FAIL"
+	// SKIPPED is the string representation of "SKIPPED", the result of a commit
+	// in a range that was not verified because another commit failed and
+	// FailFast is enabled.
+	SKIPPED = "SKIPPED"
 )
 
 // Run the action. Returns an Outcome that captures the results of the action.
@@ -21,8 +30,40 @@ const (
 //
 // 1. Bypassing signature verification through an email address on the allowlist (if configured).
 // 2. Properly signed by a third party key on the allowlist (if configured).
-// 3. Properly signed by a Beyond Identity managed GPG key authorized for the committer.
+// 3. Properly signed by a platform key (e.g. GitHub web-flow) enabled on the allowlist (if configured).
+// 4. Properly signed by a Beyond Identity managed GPG key authorized for the committer.
+//
+// If a BaseRef is configured, every commit in the range is verified, and the
+// action only passes if all of them pass.
+//
+// With report-only enforcement, failures have the result WARN instead of FAIL.
+//
+// If an audit log is configured, the outcome is appended to it. The action
+// fails if the audit record cannot be written, or warns with report-only
+// enforcement.
 func Run(ctx context.Context, cfg Config) *Outcome {
+	o := run(ctx, cfg)
+
+	auditLog, err := NewAuditLog(cfg)
+	if err == nil && auditLog != nil {
+		var record *AuditRecord
+		record, err = auditLog.Append(ctx, o)
+		if err == nil {
+			log.Printf("Appended audit record %d with hash %s\n\n", record.Sequence, record.Hash)
+		}
+	}
+	if err != nil {
+		o.SetErrors(fmt.Errorf("failed to write audit log: %w", err))
+		o.SetResultAndDescription(FAIL, "Failed to write the audit log. See errors for details.")
+		if o.ReportOnly {
+			reportFailures(o)
+		}
+	}
+	return o
+}
+
+// run verifies the commit or range of commits with a Verifier. See Run.
+func run(ctx context.Context, cfg Config) *Outcome {
 	o := &Outcome{Version: version, Repository: cfg.Repository, Errors: []OutcomeError{}}
 	errs := cfg.Validate()
 	if len(errs) > 0 {
@@ -31,119 +72,348 @@ func Run(ctx context.Context, cfg Config) *Outcome {
 		return o
 	}
 
-	log.Printf("Verifying commit with ref %q in %q", cfg.CommitRef, cfg.RepoPath)
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		return setupFailure(o, err, cfg)
+	}
+
+	if cfg.BaseRef != "" {
+		r, err := v.VerifyRange(ctx, cfg.BaseRef, cfg.CommitRef)
+		if err != nil {
+			return setupFailure(o, err, cfg)
+		}
+		return r.Outcome
+	}
 
-	commit, err := GetCommit(cfg.RepoPath, cfg.CommitRef)
+	r, err := v.VerifyCommit(ctx, cfg.CommitRef)
 	if err != nil {
-		o.SetErrors(err)
-		o.SetResultAndDescription(FAIL, "Failed to get commit. See errors for details.")
-		return o
+		return setupFailure(o, err, cfg)
+	}
+	return r.Outcome
+}
+
+// setupFailure fails o with the errors of the SetupError err. The failure is
+// only a warning if the Enforcement of cfg is report-only, since the
+// repository_settings of the allowlist may not be known.
+func setupFailure(o *Outcome, err error, cfg Config) *Outcome {
+	setupErr := asSetupError(err)
+	o.SetErrors(setupErr.Errs...)
+	o.SetResultAndDescription(FAIL, setupErr.Desc)
+	if cfg.Enforcement == EnforcementReport {
+		reportFailures(o)
+	}
+	return o
+}
+
+// verifyCommits calls verify for every commit using parallelism workers, and
+// returns the outcomes in the order of commits. If failFast is set, commits
+// whose verification has not started when a commit fails are SKIPPED, and the
+// commits being verified are finished, so that a commit is only SKIPPED if it
+// was never verified.
+func verifyCommits(ctx context.Context, commits []*object.Commit, parallelism int, failFast bool, verify func(context.Context, *object.Commit) *Outcome) []*Outcome {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	// stopped is canceled when a commit fails, without interrupting the
+	// verification of other commits.
+	stopped, stop := context.WithCancel(ctx)
+	defer stop()
+
+	outcomes := make([]*Outcome, len(commits))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if stopped.Err() != nil {
+					outcomes[i] = skippedOutcome(commits[i])
+					continue
+				}
+				o := verify(ctx, commits[i])
+				if o.Result == FAIL && failFast {
+					stop()
+				}
+				outcomes[i] = o
+			}
+		}()
 	}
+
+	for i := range commits {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return outcomes
+}
+
+// skippedOutcome returns the Outcome of a commit that was not verified.
+func skippedOutcome(commit *object.Commit) *Outcome {
+	o := &Outcome{Version: version, Errors: []OutcomeError{}}
 	o.SetCommit(commit)
+	o.SetResultAndDescription(SKIPPED, "Verification canceled after another commit failed.")
+	return o
+}
 
-	log.Printf("\nCommit:\n================\n%s\n================\n\n", PrettyPrintCommit(commit))
+// ErrCodeMergedCommitsFailed is the OutcomeError code of a merge commit that
+// brings in commits that failed verification.
+const ErrCodeMergedCommitsFailed = "MERGED_COMMITS_FAILED"
+
+// MergedCommitsError is returned for a merge commit that brings in commits
+// that failed verification. It lists the hashes of those commits.
+type MergedCommitsError []string
+
+func (e MergedCommitsError) Error() string {
+	return fmt.Sprintf("merged commits failed verification: %s", strings.Join(e, ", "))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e MergedCommitsError) Code() string {
+	return ErrCodeMergedCommitsFailed
+}
+
+// verify verifies the commit and records the result in o. If VerifyMergedCommits
+// is set, a merge commit only passes if the commits it brings in pass too. A
+// failure is overridden if a break-glass token was supplied for the commit.
+func (v *verification) verify(ctx context.Context, o *Outcome, commit *object.Commit) {
+	v.verifyCommitAndCoAuthors(ctx, o, commit)
+	if v.cfg.VerifyMergedCommits && o.Result == PASS && commit.NumParents() > 1 {
+		v.verifyMergedCommits(ctx, o, commit)
+	}
+	if o.Result == FAIL {
+		v.breakGlass(o, commit)
+	}
+}
+
+// verifyCommitAndCoAuthors verifies the commit and, if VerifyCoAuthors is set
+// and the commit passed, its co-authors.
+func (v *verification) verifyCommitAndCoAuthors(ctx context.Context, o *Outcome, commit *object.Commit) {
+	v.verifyCommit(ctx, o, commit)
+	if v.cfg.VerifyCoAuthors && o.Result == PASS {
+		v.verifyCoAuthors(ctx, o)
+	}
+}
 
-	// Load the allowlist YAML.
-	allowlistYAML, err := LoadAllowlistYAML(cfg.AllowlistConfigFilePath)
+// verifyMergedCommits verifies the commits that the merge commit brings in
+// through its non-first parents, and fails o if any of them fail.
+func (v *verification) verifyMergedCommits(ctx context.Context, o *Outcome, commit *object.Commit) {
+	merged, missing, err := ListMergedCommits(commit)
 	if err != nil {
 		o.SetErrors(err)
-		o.SetResultAndDescription(FAIL, "Failed to load the allowlist. See errors for details.")
-		return o
+		o.SetResultAndDescription(FAIL, "Failed to list merged commits. See errors for details.")
+		return
+	}
+	v.logger.Printf("Verifying %d commits merged by commit %s\n\n", len(merged), commit.Hash)
+
+	outcomes := verifyCommits(ctx, merged, v.cfg.Parallelism, false, func(ctx context.Context, c *object.Commit) *Outcome {
+		mo := v.newOutcome(c)
+		v.verifyCommitAndCoAuthors(ctx, mo, c)
+		if mo.Result == FAIL {
+			v.breakGlass(mo, c)
+		}
+		return mo
+	})
+
+	failed := MergedCommitsError{}
+	for _, mo := range outcomes {
+		o.BreakGlassCommits = append(o.BreakGlassCommits, mo.BreakGlassCommits...)
+		if mo.Result == FAIL {
+			failed = append(failed, mo.Commit.CommitHash)
+			o.FailedMergedCommits = append(o.FailedMergedCommits, mo)
+		}
 	}
+	unverifiable := v.reportUnverifiableCommits(o, missing)
+	switch {
+	case len(failed) > 0:
+		o.SetErrors(failed)
+		o.SetResultAndDescription(FAIL, fmt.Sprintf("%d of %d merged commits failed verification. See errors for details.", len(failed), len(merged)))
+	case unverifiable:
+		o.SetResultAndDescription(FAIL, "Merged commits are missing from the shallow clone and cannot be verified. See errors for details.")
+	}
+}
 
-	var allowlist Allowlist
-	if commit.NumParents() > 1 {
-		allowlist = allowlistYAML.MergeCommitAllowlist
-		log.Printf("More than one parent hash, using merge commit allowlist.\n\n")
-	} else {
-		allowlist = allowlistYAML.NonMergeCommitAllowlist
-		log.Printf("One parent hash, using non merge commit allowlist.\n\n")
+// reportUnverifiableCommits adds an UnverifiableCommitsError to o for the
+// commits with missing parents, and returns whether they fail o under the
+// ShallowPolicy.
+func (v *Verifier) reportUnverifiableCommits(o *Outcome, missing []plumbing.Hash) bool {
+	if len(missing) == 0 {
+		return false
+	}
+	unverifiable := UnverifiableCommitsError{}
+	for _, h := range missing {
+		unverifiable = append(unverifiable, h.String())
 	}
+	o.SetErrors(unverifiable)
+	o.UnverifiableCommits = append(o.UnverifiableCommits, unverifiable...)
 
-	// Parse out valid allowlist email addresses and keys for the specified
-	// repository. Adds any parsing errors to the outcome but does not return
-	// at this step.
-	repoAllowlist, errs := GetAllowlistForRepo(&allowlist, cfg.Repository)
-	if len(errs) > 0 {
-		o.SetErrors(errs...)
+	if v.cfg.ShallowPolicy == ShallowPolicyWarn {
+		v.logger.Printf("Warning: %v\n\n", unverifiable)
+		return false
+	}
+	return true
+}
+
+// verifyCommit verifies the commit by itself and records the result in o.
+func (v *verification) verifyCommit(ctx context.Context, o *Outcome, commit *object.Commit) {
+	cfg := v.cfg
+
+	if cfg.SyntheticMergeCommit != "" && strings.EqualFold(commit.Hash.String(), cfg.SyntheticMergeCommit) {
+		o.SetErrors(SyntheticMergeCommitError(commit.Hash.String()))
+		o.SetResultAndDescription(FAIL, "Refusing to verify the merge commit created for the pull request. See errors for details.")
+		return
+	}
+
+	exemption, err := v.exemption(commit)
+	if err != nil {
+		o.SetErrors(err)
+		o.SetResultAndDescription(FAIL, "Failed to check the baseline commit. See errors for details.")
+		return
+	}
+	if exemption != "" {
+		v.logger.Printf("%s\n\n", exemption)
+		o.SetResultAndDescription(EXEMPT, exemption)
+		return
+	}
+
+	section, loaded := v.getRepoAllowlist(ctx, o.Commit.Kind)
+	v.logger.Printf("Commit kind is %q, using %s.\n\n", o.Commit.Kind, section)
+	if len(loaded.errs) > 0 {
+		o.SetErrors(loaded.errs...)
 	}
+	repoAllowlist := loaded.repoAllowlist
+	platformKeyRings := loaded.platformKeyRings
 
 	committerEmail := commit.Committer.Email
 
 	// If the repo allowlist contains email addresses, attempt to bypass signature verification
 	// through the email address.
 	if len(repoAllowlist.EmailAddresses) > 0 {
-		log.Printf("Checking signature verification bypass with email address from the allowlist.\n\n")
+		v.logger.Printf("Checking signature verification bypass with email address from the allowlist.\n\n")
 		verified := verifyCommitByEmailAddress(committerEmail, repoAllowlist.EmailAddresses)
 		if verified {
-			log.Printf("Committer email: \"%s\" is on email address allowlist, bypassing signature verification.\n\n", committerEmail)
+			v.logger.Printf("Committer email: \"%s\" is on email address allowlist, bypassing signature verification.\n\n", committerEmail)
 			o.SetVerificationDetailsEmailAddress(committerEmail)
 			o.SetResultAndDescription(PASS, "Bypassed signature verification with an email address from the allowlist.")
-			return o
+			return
 		}
-		log.Printf("Committer email: \"%s\" is not on email address allowlist, continuing signature verification.\n\n", committerEmail)
+		v.logger.Printf("Committer email: \"%s\" is not on email address allowlist, continuing signature verification.\n\n", committerEmail)
 	}
 
 	// Validate that a signature exists for third party key validation and BI cloud verification.
 	if commit.PGPSignature == "" {
 		o.SetErrors(errors.New("commit is not signed"))
 		o.SetResultAndDescription(FAIL, "Commit is not signed. See errors for details.")
-		return o
+		return
 	}
 
-	issuerKeyID, err := ParseSignatureIssuerKeyID(commit.PGPSignature)
+	issuer, err := ParseSignatureIssuer(commit.PGPSignature)
 	if err != nil {
 		o.SetErrors(err)
 		o.SetResultAndDescription(FAIL, "Failed to parse signature. See errors for details.")
-		return o
+		return
+	}
+	o.Commit.SignatureKeyID = issuer.KeyID
+	o.Commit.SignatureFingerprint = issuer.Fingerprint
+
+	// Reject signatures made with algorithms disallowed by the crypto policy up front,
+	// as no key can make them pass. Keys are checked against the policy as they are used.
+	signature, err := parseSignature(commit.PGPSignature)
+	if err != nil {
+		o.SetErrors(fmt.Errorf("failed to parse signature: %w", err))
+		o.SetResultAndDescription(FAIL, "Failed to parse signature. See errors for details.")
+		return
+	}
+	if err := cfg.CryptoPolicy.CheckSignature(signature); err != nil {
+		o.SetErrors(err)
+		o.SetResultAndDescription(FAIL, "Signature violates the crypto policy. See errors for details.")
+		return
 	}
-	o.Commit.SignatureKeyID = issuerKeyID
 
 	payload, err := EncodedCommitWithoutSignature(commit)
 	if err != nil {
 		o.SetErrors(err)
 		o.SetResultAndDescription(FAIL, "Failed to encode commit. See errors for details.")
-		return o
+		return
+	}
+
+	// Without an issuer fingerprint, the signature only identifies its key by the
+	// 64-bit key ID. Refuse to pick between allowlisted keys sharing that key ID.
+	if issuer.Fingerprint == "" {
+		keyRings := []openpgp.EntityList{}
+		for _, tpk := range repoAllowlist.ThirdPartyKeys {
+			keyRings = append(keyRings, tpk.KeyRing)
+		}
+		for _, keyRing := range platformKeyRings {
+			keyRings = append(keyRings, keyRing)
+		}
+		if fps := findIssuerKeyIDCollisions(issuer, keyRings); len(fps) > 1 {
+			o.SetErrors(fmt.Errorf("issuer key id %s is ambiguous, it matches allowlisted keys %s", issuer.KeyID, strings.Join(fps, ", ")))
+			o.SetResultAndDescription(FAIL, "Signature issuer is ambiguous. See errors for details.")
+			return
+		}
 	}
 
 	// If the repo allowlist contains third party keys, attempt to verify the signature through the keys.
 	if len(repoAllowlist.ThirdPartyKeys) > 0 {
-		log.Printf("Verifying commit signature with third party keys from the allowlist\n\n")
-		tpk, pass := verifyCommitSignatureByThirdPartyKeys(repoAllowlist.ThirdPartyKeys, payload, commit)
+		v.logger.Printf("Verifying commit signature with third party keys from the allowlist\n\n")
+		tpk, pass, policyErrs := verifyCommitSignatureByThirdPartyKeys(repoAllowlist.ThirdPartyKeys, payload, commit, cfg.CryptoPolicy, v.logger)
 		if pass {
-			log.Printf("Commit is signed by authorized third party key\n\n")
+			v.logger.Printf("Commit is signed by authorized third party key\n\n")
 			o.SetVerificationDetailsThirdPartyKey(tpk)
 			o.SetResultAndDescription(PASS, "Signature verified by a third party key from the allowlist.")
-			return o
+			return
 		}
-		log.Printf("No third party keys validated signature, continuing signature verification\n\n")
+		if len(policyErrs) > 0 {
+			o.SetErrors(policyErrs...)
+		}
+		v.logger.Printf("No third party keys validated signature, continuing signature verification\n\n")
 	}
 
-	log.Printf("Getting authorization for GPG key %q with committer email address %q\n\n", issuerKeyID, committerEmail)
+	// If the repo allowlist enables platform keys, attempt to verify the signature through the
+	// platforms' signing keys.
+	if len(platformKeyRings) > 0 {
+		v.logger.Printf("Verifying commit signature with platform keys enabled on the allowlist\n\n")
+		pk, pass, policyErrs := verifyCommitSignatureByPlatformKeys(platformKeyRings, repoAllowlist.PlatformKeys, repoAllowlist.PlatformCommitterEmails, payload, commit, cfg.CryptoPolicy, v.logger)
+		if pass {
+			v.logger.Printf("Commit is signed by an allowed platform key\n\n")
+			o.SetVerificationDetailsPlatformKey(pk)
+			o.SetResultAndDescription(PASS, "Signature verified by a platform key enabled on the allowlist.")
+			return
+		}
+		if len(policyErrs) > 0 {
+			o.SetErrors(policyErrs...)
+		}
+		v.logger.Printf("No platform keys validated signature, continuing signature verification\n\n")
+	}
 
-	// Attempt to verify signature through BI cloud.
-	authorization, err := APIClient{
-		HTTPClient: http.DefaultClient,
-		APIToken:   cfg.APIToken,
-		APIBaseURL: cfg.APIBaseURL,
-	}.GetAuthorization(ctx, issuerKeyID, committerEmail)
+	if v.authorizerErr != nil {
+		o.SetErrors(v.authorizerErr)
+		o.SetResultAndDescription(FAIL, "Failed to configure the authorizer. See errors for details.")
+		return
+	}
+
+	v.logger.Printf("Getting authorization for GPG key %q (fingerprint %q) with committer email address %q\n\n", issuer.KeyID, issuer.Fingerprint, committerEmail)
+
+	// Attempt to verify signature through BI cloud, or the offline snapshot.
+	authorization, err := v.authorizer.AuthorizeKey(ctx, issuer.KeyID, issuer.Fingerprint, committerEmail)
 	if err != nil {
 		o.SetErrors(fmt.Errorf("failed to get authorization to BI cloud: %w", err))
 		o.SetResultAndDescription(FAIL, "Failed to get authorization to BI cloud. See errors for details.")
-		return o
+		return
 	}
 
-	log.Printf("\nAPI response:\n================\n%s\n================\n\n", authorization.PrettyPrint())
+	v.logger.Printf("\nAPI response:\n================\n%s\n================\n\n", authorization.PrettyPrint())
 
-	err = Verify(commit, authorization)
+	err = VerifyWithPolicy(commit, authorization, cfg.CryptoPolicy)
 	if err != nil {
 		o.SetErrors(fmt.Errorf("failed to verify commit with authorization: %w", err))
 		o.SetResultAndDescription(FAIL, "Failed to verify commit. See errors for details.")
-		return o
+		return
 	}
 
-	log.Println("Commit is signed by an authorized Beyond Identity user")
-	o.SetVerificationDetailsBIManagedKey(issuerKeyID, committerEmail)
+	v.logger.Printf("Commit is signed by an authorized Beyond Identity user\n")
+	o.SetVerificationDetailsBIManagedKeyIssuer(issuer, committerEmail)
 	o.SetResultAndDescription(PASS, "Signature verified by a Beyond Identity managed key.")
-	return o
 }
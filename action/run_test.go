@@ -0,0 +1,692 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// base64PublicKey returns the public key of the entity as returned by the API.
+func base64PublicKey(t *testing.T, e *openpgp.Entity) string {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if err := e.Serialize(buf); err != nil {
+		t.Fatalf("failed to serialize key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// newTestRunConfig returns a Config that verifies commits of the repository
+// against the fake API server.
+func newTestRunConfig(r *testRepo, f *fakeAPIServer) Config {
+	return Config{
+		RepoPath:   r.Path,
+		CommitRef:  "refs/heads/main",
+		APIToken:   "token",
+		APIBaseURL: f.URL,
+		Repository: "byndid/auth-commit-sig",
+	}
+}
+
+func TestRunRange(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+
+	f := newFakeAPIServer(t, true)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{})
+	parent := base
+	expected := []string{}
+	for i := 0; i < 8; i++ {
+		signer := jane
+		if i == 5 {
+			signer = mallory
+		}
+		parent = r.commit(testCommitOptions{Signer: signer, Parents: []plumbing.Hash{parent}})
+		expected = append(expected, parent.String())
+	}
+
+	t.Run("parallel", func(t *testing.T) {
+		cfg := newTestRunConfig(r, f)
+		cfg.BaseRef = base.String()
+		cfg.Parallelism = 3
+		cfg.MaxConcurrentAPIRequests = 1
+
+		o := Run(context.Background(), cfg)
+		if o.Result != FAIL || o.Desc != "1 of 8 commits failed verification. See commits for details." {
+			t.Errorf("unexpected result %s: %s", o.Result, o.Desc)
+		}
+		if len(o.Commits) != len(expected) {
+			t.Fatalf("expected %d commit outcomes, got %d", len(expected), len(o.Commits))
+		}
+		for i, co := range o.Commits {
+			if co.Commit.CommitHash != expected[i] {
+				t.Errorf("commit %d: expected %s, got %s", i, expected[i], co.Commit.CommitHash)
+			}
+			expectedResult := PASS
+			if i == 5 {
+				expectedResult = FAIL
+			}
+			if co.Result != expectedResult {
+				t.Errorf("commit %d: expected %s, got %s: %s", i, expectedResult, co.Result, co.Desc)
+			}
+		}
+
+		// All authorizations are requested at once through the batch API.
+		if f.batchRequests != 1 || f.getRequests != 0 {
+			t.Errorf("expected 1 batch and 0 GET requests, got %d and %d", f.batchRequests, f.getRequests)
+		}
+	})
+
+	t.Run("fail_fast", func(t *testing.T) {
+		cfg := newTestRunConfig(r, f)
+		cfg.BaseRef = base.String()
+		cfg.Parallelism = 1
+		cfg.FailFast = true
+
+		o := Run(context.Background(), cfg)
+		if o.Result != FAIL {
+			t.Errorf("expected FAIL, got %s", o.Result)
+		}
+		for i, co := range o.Commits {
+			expectedResult := PASS
+			switch {
+			case i == 5:
+				expectedResult = FAIL
+			case i > 5:
+				expectedResult = SKIPPED
+			}
+			if co.Result != expectedResult {
+				t.Errorf("commit %d: expected %s, got %s", i, expectedResult, co.Result)
+			}
+		}
+	})
+}
+
+func TestVerifyCommitsFailFast(t *testing.T) {
+	r := newTestRepo(t)
+	commits := []*object.Commit{}
+	for i := 0; i < 3; i++ {
+		c, err := r.CommitObject(r.commit(testCommitOptions{Message: fmt.Sprintf("commit %d", i)}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		commits = append(commits, c)
+	}
+
+	// The second commit fails after the first, while the third has not started.
+	secondStarted, firstFailed := make(chan struct{}), make(chan struct{})
+	outcomes := verifyCommits(context.Background(), commits, 2, true, func(ctx context.Context, c *object.Commit) *Outcome {
+		o := &Outcome{Errors: []OutcomeError{}}
+		o.SetCommit(c)
+		switch c.Hash {
+		case commits[0].Hash:
+			<-secondStarted
+			defer close(firstFailed)
+		case commits[1].Hash:
+			close(secondStarted)
+			<-firstFailed
+			time.Sleep(10 * time.Millisecond)
+			if ctx.Err() != nil {
+				t.Errorf("expected the verification of a started commit not to be canceled")
+			}
+		}
+		o.SetResultAndDescription(FAIL, "failed")
+		return o
+	})
+
+	for i, expected := range []string{FAIL, FAIL, SKIPPED} {
+		if outcomes[i].Result != expected {
+			t.Errorf("commit %d: expected %s, got %s", i, expected, outcomes[i].Result)
+		}
+	}
+}
+
+func TestRunPrefetchAuthorizations(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	john := newTestEntity(t, "John Doe", "john@doe.com")
+	bot := newTestEntity(t, "Bot", "bot@example.com")
+
+	f := newFakeAPIServer(t, true)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+	f.Keys[formatPGPKeyID(john.PrimaryKey.KeyId)+"/john@doe.com"] = base64PublicKey(t, john)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{})
+	parent := base
+	for _, signer := range []*openpgp.Entity{jane, bot, john} {
+		email := signer.PrimaryIdentity().UserId.Email
+		parent = r.commit(testCommitOptions{Signer: signer, Email: email, Parents: []plumbing.Hash{parent}})
+	}
+
+	allowlistPath := filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, allowlistPath, "non_merge_commit_allowlist:\n  email_addresses:\n    - email_address: bot@example.com\n")
+
+	cfg := newTestRunConfig(r, f)
+	cfg.BaseRef = base.String()
+	cfg.AllowlistConfigFilePath = allowlistPath
+
+	o := Run(context.Background(), cfg)
+	if o.Result != PASS {
+		t.Fatalf("expected PASS, got %s: %s", o.Result, o.Desc)
+	}
+	// The commit passed by the allowlist is not authorized through the API.
+	if f.batchRequests != 1 || f.batchedKeys != 2 || f.getRequests != 0 {
+		t.Errorf("expected 1 batch of 2 authorizations and 0 GET requests, got %d batches of %d and %d", f.batchRequests, f.batchedKeys, f.getRequests)
+	}
+}
+
+func TestRunVerifyMergedCommits(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+
+	f := newFakeAPIServer(t, false)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{Signer: jane})
+	signed := r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{base}})
+	unsigned := r.commit(testCommitOptions{Parents: []plumbing.Hash{signed}})
+	unauthorized := r.commit(testCommitOptions{Signer: mallory, Parents: []plumbing.Hash{unsigned}})
+	r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{base, unauthorized}})
+
+	cfg := newTestRunConfig(r, f)
+	o := Run(context.Background(), cfg)
+	if o.Result != PASS {
+		t.Errorf("expected the signed merge commit to PASS by itself, got %s: %+v", o.Result, o.Errors)
+	}
+
+	cfg.VerifyMergedCommits = true
+	o = Run(context.Background(), cfg)
+	if o.Result != FAIL || o.Desc != "2 of 3 merged commits failed verification. See errors for details." {
+		t.Errorf("unexpected result %s: %s", o.Result, o.Desc)
+	}
+	expectedErr := "merged commits failed verification: " + unsigned.String() + ", " + unauthorized.String()
+	if len(o.Errors) != 1 || o.Errors[0].Code != ErrCodeMergedCommitsFailed || o.Errors[0].Desc != expectedErr {
+		t.Errorf("expected error %q, got %+v", expectedErr, o.Errors)
+	}
+	if len(o.FailedMergedCommits) != 2 || o.FailedMergedCommits[0].Commit.CommitHash != unsigned.String() {
+		t.Errorf("expected the outcomes of the failed merged commits, got %+v", o.FailedMergedCommits)
+	}
+}
+
+func TestRunShallowPolicy(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+
+	f := newFakeAPIServer(t, false)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{Signer: jane})
+	side, _ := r.shallowCommit(jane)
+	head := r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{base, side}})
+
+	tests := []struct {
+		name           string
+		policy         string
+		baseline       plumbing.Hash
+		expectedResult string
+	}{
+		{name: "default", policy: "", expectedResult: FAIL},
+		{name: "fail", policy: ShallowPolicyFail, expectedResult: FAIL},
+		{name: "warn", policy: ShallowPolicyWarn, expectedResult: PASS},
+		// The missing parent of the baseline commit is exempt.
+		{name: "baseline", policy: ShallowPolicyFail, baseline: side, expectedResult: PASS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestRunConfig(r, f)
+			cfg.BaseRef = base.String()
+			cfg.CommitRef = head.String()
+			cfg.ShallowPolicy = tt.policy
+			if !tt.baseline.IsZero() {
+				cfg.BaselineCommit = tt.baseline.String()
+			}
+
+			o := Run(context.Background(), cfg)
+			if o.Result != tt.expectedResult {
+				t.Errorf("expected %s, got %s: %s", tt.expectedResult, o.Result, o.Desc)
+			}
+			if !tt.baseline.IsZero() {
+				if len(o.UnverifiableCommits) != 0 {
+					t.Errorf("expected no unverifiable commits, got %v", o.UnverifiableCommits)
+				}
+				return
+			}
+			if len(o.UnverifiableCommits) != 1 || o.UnverifiableCommits[0] != side.String() {
+				t.Errorf("expected %s to be unverifiable, got %v", side, o.UnverifiableCommits)
+			}
+			found := false
+			for _, e := range o.Errors {
+				found = found || e.Code == ErrCodeUnverifiableCommits
+			}
+			if !found {
+				t.Errorf("expected %s error, got %v", ErrCodeUnverifiableCommits, o.Errors)
+			}
+		})
+	}
+}
+
+func TestRunEnforcement(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+
+	f := newFakeAPIServer(t, false)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{})
+	failed := r.commit(testCommitOptions{Signer: mallory, Parents: []plumbing.Hash{base}})
+	r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{failed}})
+
+	allowlistPath := filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, allowlistPath, `repository_settings:
+  - enforcement: enforce
+  - enforcement: report
+    repositories: [byndid/auth-commit-sig]
+`)
+
+	tests := []struct {
+		name           string
+		enforcement    string
+		allowlistPath  string
+		expectedResult string
+	}{
+		{name: "default", expectedResult: FAIL},
+		{name: "report", enforcement: EnforcementReport, expectedResult: WARN},
+		{name: "repository_settings", enforcement: EnforcementEnforce, allowlistPath: allowlistPath, expectedResult: WARN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestRunConfig(r, f)
+			cfg.BaseRef = base.String()
+			cfg.Enforcement = tt.enforcement
+			cfg.AllowlistConfigFilePath = tt.allowlistPath
+			cfg.FailFast = true
+			cfg.Parallelism = 1
+
+			o := Run(context.Background(), cfg)
+			if o.Result != tt.expectedResult || o.ReportOnly != (tt.expectedResult == WARN) {
+				t.Fatalf("expected %s, got %s (report only %t): %s", tt.expectedResult, o.Result, o.ReportOnly, o.Desc)
+			}
+			if tt.expectedResult == FAIL {
+				return
+			}
+			// All commits are verified, and the outcome still says what failed.
+			if o.Desc != "1 of 2 commits failed verification. See commits for details." {
+				t.Errorf("unexpected description: %s", o.Desc)
+			}
+			if len(o.Commits) != 2 || o.Commits[0].Result != WARN || len(o.Commits[0].Errors) == 0 || o.Commits[1].Result != PASS {
+				t.Errorf("expected WARN with errors and PASS, got %v", o.Commits)
+			}
+		})
+	}
+}
+
+func TestRunExemptions(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+
+	f := newFakeAPIServer(t, false)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{})
+	legacy := r.commit(testCommitOptions{Parents: []plumbing.Hash{base}})
+	baseline := r.commit(testCommitOptions{Parents: []plumbing.Hash{legacy}})
+	signed := r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{baseline}})
+	c, err := r.CommitObject(signed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cutover := c.Committer.When.Format(time.RFC3339)
+
+	allowlistPath := filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, allowlistPath, fmt.Sprintf("repository_settings:\n  - baseline_commit: %s\n    repositories: [byndid/auth-commit-sig]\n", baseline))
+
+	tests := []struct {
+		name           string
+		cfg            func(*Config)
+		expectedResult string
+		expectedDesc   string
+	}{
+		{name: "none", cfg: func(*Config) {}, expectedResult: FAIL},
+		{name: "baseline_commit", cfg: func(cfg *Config) { cfg.BaselineCommit = baseline.String() }, expectedResult: PASS, expectedDesc: "baseline commit " + baseline.String()},
+		{name: "cutover", cfg: func(cfg *Config) { cfg.Cutover = cutover }, expectedResult: PASS, expectedDesc: "before the cutover"},
+		{name: "repository_settings", cfg: func(cfg *Config) { cfg.AllowlistConfigFilePath = allowlistPath }, expectedResult: PASS, expectedDesc: "baseline commit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestRunConfig(r, f)
+			cfg.BaseRef = base.String()
+			tt.cfg(&cfg)
+
+			o := Run(context.Background(), cfg)
+			if o.Result != tt.expectedResult {
+				t.Fatalf("expected %s, got %s: %s", tt.expectedResult, o.Result, o.Desc)
+			}
+			if tt.expectedResult == FAIL {
+				return
+			}
+			if o.Desc != "1 of 3 commits passed verification, the other 2 are exempt." {
+				t.Errorf("unexpected description: %s", o.Desc)
+			}
+			for i, co := range o.Commits[:2] {
+				if co.Result != EXEMPT || !strings.Contains(co.Desc, tt.expectedDesc) {
+					t.Errorf("commit %d: expected EXEMPT with %q, got %s: %s", i, tt.expectedDesc, co.Result, co.Desc)
+				}
+			}
+			if o.Commits[2].Result != PASS {
+				t.Errorf("expected the signed commit to PASS, got %s: %s", o.Commits[2].Result, o.Commits[2].Desc)
+			}
+		})
+	}
+}
+
+func TestRunVerifyCoAuthors(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	john := newTestEntity(t, "John Doe", "john@doe.com")
+
+	f := newFakeAPIServer(t, false)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+	f.Keys[formatPGPKeyID(john.PrimaryKey.KeyId)+"/john@doe.com"] = base64PublicKey(t, john)
+
+	allowlistPath := filepath.Join(t.TempDir(), "allowlist.yaml")
+	writeTestFile(t, allowlistPath, "non_merge_commit_allowlist:\n  email_addresses:\n    - email_address: bot@example.com\n")
+
+	tests := []struct {
+		name                 string
+		coAuthors            string
+		verifyCoAuthors      bool
+		expectedResult       string
+		expectedUnauthorized []string
+	}{
+		{name: "disabled", coAuthors: "Co-authored-by: Mallory <mallory@example.com>\n", expectedResult: PASS},
+		{name: "authorized", coAuthors: "Co-authored-by: John Doe <john@doe.com>\nCo-authored-by: Bot <bot@example.com>\n", verifyCoAuthors: true, expectedResult: PASS},
+		{name: "unauthorized", coAuthors: "Co-authored-by: John Doe <john@doe.com>\nCo-authored-by: Mallory <mallory@example.com>\n", verifyCoAuthors: true, expectedResult: FAIL, expectedUnauthorized: []string{"mallory@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t)
+			r.commit(testCommitOptions{Signer: jane, Message: "Pair on the parser\n\n" + tt.coAuthors})
+
+			cfg := newTestRunConfig(r, f)
+			cfg.AllowlistConfigFilePath = allowlistPath
+			cfg.VerifyCoAuthors = tt.verifyCoAuthors
+
+			o := Run(context.Background(), cfg)
+			if o.Result != tt.expectedResult {
+				t.Fatalf("expected %s, got %s: %s", tt.expectedResult, o.Result, o.Desc)
+			}
+			if len(o.Commit.CoAuthors) == 0 {
+				t.Errorf("expected the co-authors in the outcome")
+			}
+			if !reflect.DeepEqual(o.UnauthorizedCoAuthors, tt.expectedUnauthorized) {
+				t.Errorf("expected unauthorized co-authors %v, got %v", tt.expectedUnauthorized, o.UnauthorizedCoAuthors)
+			}
+			if tt.expectedResult == FAIL && (len(o.Errors) != 1 || o.Errors[0].Code != ErrCodeUnauthorizedCoAuthors) {
+				t.Errorf("expected %s error, got %v", ErrCodeUnauthorizedCoAuthors, o.Errors)
+			}
+		})
+	}
+}
+
+func TestRunCryptoPolicyRejectsAllowlistedKeys(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	f := newFakeAPIServer(t, false)
+	r := newTestRepo(t)
+	r.commit(testCommitOptions{Signer: jane})
+
+	platformKeysDir := t.TempDir()
+	writeTestFile(t, filepath.Join(platformKeysDir, "gitlab.asc"), armorPublicKey(t, jane))
+
+	tests := []struct {
+		name          string
+		allowlist     string
+		expectedError string
+	}{
+		{
+			name:          "third_party_key",
+			allowlist:     fmt.Sprintf("non_merge_commit_allowlist:\n  third_party_keys:\n    - key: |\n%s", indent(armorPublicKey(t, jane), "        ")),
+			expectedError: "third party key: crypto policy violation: public key algorithm",
+		},
+		{
+			name:          "platform_key",
+			allowlist:     "non_merge_commit_allowlist:\n  platform_keys:\n    - platform: gitlab\n      committer_emails: [jane@doe.com]\n",
+			expectedError: "gitlab platform key: crypto policy violation: public key algorithm",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowlistPath := filepath.Join(t.TempDir(), "allowlist.yaml")
+			writeTestFile(t, allowlistPath, tt.allowlist)
+
+			cfg := newTestRunConfig(r, f)
+			cfg.AllowlistConfigFilePath = allowlistPath
+			cfg.PlatformKeysDir = platformKeysDir
+			cfg.CryptoPolicy = CryptoPolicy{AllowedPublicKeyAlgorithms: []string{"rsa"}}
+
+			o := Run(context.Background(), cfg)
+			if o.Result != FAIL {
+				t.Fatalf("expected FAIL, got %s: %s", o.Result, o.Desc)
+			}
+			found := false
+			for _, e := range o.Errors {
+				found = found || (e.Code == ErrCodeCryptoPolicy && strings.HasPrefix(e.Desc, tt.expectedError))
+			}
+			if !found {
+				t.Errorf("expected %s error %q, got %+v", ErrCodeCryptoPolicy, tt.expectedError, o.Errors)
+			}
+		})
+	}
+}
+
+// indent prefixes every line of s with prefix.
+func indent(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = prefix + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func TestRunAuditLogFailure(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+
+	f := newFakeAPIServer(t, false)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	r := newTestRepo(t)
+	r.commit(testCommitOptions{Signer: jane})
+
+	for _, tt := range []struct {
+		enforcement    string
+		expectedResult string
+	}{
+		{enforcement: EnforcementEnforce, expectedResult: FAIL},
+		{enforcement: EnforcementReport, expectedResult: WARN},
+	} {
+		t.Run(tt.enforcement, func(t *testing.T) {
+			cfg := newTestRunConfig(r, f)
+			cfg.Enforcement = tt.enforcement
+			cfg.AuditLogFile = filepath.Join(t.TempDir(), "missing", "audit.jsonl")
+
+			o := Run(context.Background(), cfg)
+			if o.Result != tt.expectedResult || o.Desc != "Failed to write the audit log. See errors for details." {
+				t.Errorf("expected %s, got %s: %s", tt.expectedResult, o.Result, o.Desc)
+			}
+		})
+	}
+}
+
+func TestRunBreakGlass(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	admin := newTestEntity(t, "Admin", "admin@example.com")
+
+	f := newFakeAPIServer(t, false)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	keyRingPath := filepath.Join(t.TempDir(), "admin.asc")
+	writeTestFile(t, keyRingPath, armorPublicKey(t, admin))
+	issue := func(t *testing.T, repository string, commit plumbing.Hash) string {
+		t.Helper()
+		token, err := EncodeBreakGlassToken(NewBreakGlassToken(repository, commit.String(), "INC-42 hotfix", time.Now(), time.Hour), admin)
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+		return token
+	}
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{Signer: jane})
+	hotfix := r.commit(testCommitOptions{Parents: []plumbing.Hash{base}})
+
+	t.Run("input", func(t *testing.T) {
+		auditLogPath := filepath.Join(t.TempDir(), "audit.jsonl")
+		cfg := newTestRunConfig(r, f)
+		cfg.CommitRef = hotfix.String()
+		cfg.BreakGlassKeyRingFilePath = keyRingPath
+		cfg.BreakGlassKeyFingerprints = []string{formatFingerprint(admin.PrimaryKey.Fingerprint)}
+		cfg.BreakGlassToken = issue(t, cfg.Repository, hotfix)
+		cfg.AuditLogFile = auditLogPath
+
+		o := Run(context.Background(), cfg)
+		if o.Result != PASS || o.VerificationDetails == nil || o.VerificationDetails.VerifiedBy != "BREAK_GLASS" {
+			t.Fatalf("expected PASS by BREAK_GLASS, got %s: %s", o.Result, o.Desc)
+		}
+		if o.VerificationDetails.BreakGlass.Token.Justification != "INC-42 hotfix" || len(o.Errors) == 0 {
+			t.Errorf("expected the justification and the errors of the failure, got %+v and %v", o.VerificationDetails.BreakGlass, o.Errors)
+		}
+		bs, err := ioutil.ReadFile(auditLogPath)
+		if err != nil {
+			t.Fatalf("failed to read audit log: %v", err)
+		}
+		if !strings.Contains(string(bs), `"break_glass_commits":["`+hotfix.String()+`"]`) {
+			t.Errorf("expected the override in the audit record, got %s", bs)
+		}
+	})
+
+	t.Run("trailer", func(t *testing.T) {
+		carrier := r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{hotfix}, Message: "Merge hotfix\n\nBreak-glass-token: " + issue(t, "byndid/auth-commit-sig", hotfix) + "\n"})
+
+		cfg := newTestRunConfig(r, f)
+		cfg.BaseRef = base.String()
+		cfg.CommitRef = carrier.String()
+		cfg.BreakGlassKeyRingFilePath = keyRingPath
+		cfg.BreakGlassKeyFingerprints = []string{formatFingerprint(admin.PrimaryKey.Fingerprint)}
+
+		o := Run(context.Background(), cfg)
+		if o.Result != PASS || len(o.BreakGlassCommits) != 1 || o.BreakGlassCommits[0] != hotfix.String() {
+			t.Errorf("expected PASS with %s overridden, got %s with %v: %s", hotfix, o.Result, o.BreakGlassCommits, o.Desc)
+		}
+
+		// Tokens are not accepted without a break-glass keyring.
+		cfg.BreakGlassKeyRingFilePath = ""
+		cfg.BreakGlassKeyFingerprints = nil
+		if o := Run(context.Background(), cfg); o.Result != FAIL {
+			t.Errorf("expected FAIL without a break-glass keyring, got %s", o.Result)
+		}
+	})
+
+	t.Run("keyring_not_pinned", func(t *testing.T) {
+		mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+		pinned := []string{formatFingerprint(mallory.PrimaryKey.Fingerprint)}
+		for _, fingerprints := range [][]string{nil, pinned} {
+			cfg := newTestRunConfig(r, f)
+			cfg.CommitRef = hotfix.String()
+			cfg.BreakGlassKeyRingFilePath = keyRingPath
+			cfg.BreakGlassKeyFingerprints = fingerprints
+			cfg.BreakGlassToken = issue(t, cfg.Repository, hotfix)
+
+			o := Run(context.Background(), cfg)
+			if o.Result != FAIL || o.VerificationDetails != nil {
+				t.Errorf("expected FAIL without an override for fingerprints %v, got %s: %s", fingerprints, o.Result, o.Desc)
+			}
+		}
+	})
+
+	t.Run("other_repository", func(t *testing.T) {
+		cfg := newTestRunConfig(r, f)
+		cfg.CommitRef = hotfix.String()
+		cfg.BreakGlassKeyRingFilePath = keyRingPath
+		cfg.BreakGlassKeyFingerprints = []string{formatFingerprint(admin.PrimaryKey.Fingerprint)}
+		cfg.BreakGlassToken = issue(t, "byndid/other", hotfix)
+
+		o := Run(context.Background(), cfg)
+		if o.Result != FAIL {
+			t.Fatalf("expected FAIL, got %s: %s", o.Result, o.Desc)
+		}
+		found := false
+		for _, e := range o.Errors {
+			found = found || e.Code == ErrCodeInvalidBreakGlassToken
+		}
+		if !found {
+			t.Errorf("expected %s error, got %v", ErrCodeInvalidBreakGlassToken, o.Errors)
+		}
+	})
+}
+
+func TestRunNotesCache(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	signer := newTestEntity(t, "Notes", "notes@example.com")
+
+	f := newFakeAPIServer(t, true)
+	f.Keys[formatPGPKeyID(jane.PrimaryKey.KeyId)+"/jane@doe.com"] = base64PublicKey(t, jane)
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{Signer: jane})
+	parent := base
+	for i := 0; i < 3; i++ {
+		parent = r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{parent}})
+	}
+
+	cfg := newTestRunConfig(r, f)
+	notesCfg := newTestNotesCacheConfig(t, r, signer)
+	cfg.BaseRef = base.String()
+	cfg.NotesCacheRef = notesCfg.NotesCacheRef
+	cfg.NotesCacheSigningKeyFilePath = notesCfg.NotesCacheSigningKeyFilePath
+
+	o := Run(context.Background(), cfg)
+	if o.Result != PASS {
+		t.Fatalf("expected PASS, got %s: %s", o.Result, o.Desc)
+	}
+	for i, co := range o.Commits {
+		if co.Cached {
+			t.Errorf("commit %d: expected not cached", i)
+		}
+	}
+	requests := f.batchRequests + f.getRequests
+
+	// A new commit is verified, the others are read from the notes cache.
+	r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{parent}})
+	o = Run(context.Background(), cfg)
+	if o.Result != PASS {
+		t.Fatalf("expected PASS, got %s: %s", o.Result, o.Desc)
+	}
+	if len(o.Commits) != 4 {
+		t.Fatalf("expected 4 commit outcomes, got %d", len(o.Commits))
+	}
+	for i, co := range o.Commits {
+		if co.Cached != (i < 3) {
+			t.Errorf("commit %d: expected cached %t, got %t", i, i < 3, co.Cached)
+		}
+	}
+	if f.batchRequests+f.getRequests != requests+1 {
+		t.Errorf("expected 1 more API request, got %d", f.batchRequests+f.getRequests-requests)
+	}
+}
@@ -0,0 +1,144 @@
+package action
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	// EnforcementEnforce fails the action if verification fails.
+	EnforcementEnforce = "enforce"
+	// EnforcementReport only reports what failed verification, with the result
+	// WARN instead of FAIL, so that the action passes.
+	EnforcementReport = "report"
+)
+
+// WARN is the string representation of "WARN", the result of a commit or range
+// that failed verification with report-only enforcement.
+const WARN = "WARN"
+
+// EXEMPT is the string representation of "EXEMPT", the result of a commit that
+// is not verified because it predates the baseline commit or cutover of the
+// repository.
+const EXEMPT = "EXEMPT"
+
+// RepositorySettingsEntry is a struct containing settings and a list of
+// repositories they apply to. If the list of repositories is empty, the
+// settings apply to all repositories. Settings that are not set are inherited
+// from the other entries, see GetSettingsForRepo.
+type RepositorySettingsEntry struct {
+	// Enforcement is EnforcementEnforce or EnforcementReport, if set.
+	Enforcement string `yaml:"enforcement"`
+	// BaselineCommit is the full hash of a commit whose ancestors, and the
+	// commit itself, are exempt from verification, if set.
+	BaselineCommit string `yaml:"baseline_commit"`
+	// Cutover exempts commits committed before it from verification, if set.
+	// See ParseCutover for its format.
+	Cutover      string   `yaml:"cutover"`
+	Repositories []string `yaml:"repositories"`
+}
+
+// RepositorySettings are the settings of a repository.
+type RepositorySettings struct {
+	// Enforcement is EnforcementEnforce or EnforcementReport, or empty if the
+	// Enforcement of the Config is used.
+	Enforcement string
+	// BaselineCommit is the full hash of the baseline commit, or empty if the
+	// BaselineCommit of the Config is used.
+	BaselineCommit string
+	// Cutover is the cutover, or empty if the Cutover of the Config is used.
+	Cutover string
+}
+
+// GetSettingsForRepo returns the settings of the repository. Each setting is
+// taken from the first entry that lists the repository and sets it, or else
+// from the first entry for all repositories that sets it, so that teams can
+// graduate individually from defaults set for all repositories.
+func GetSettingsForRepo(entries []RepositorySettingsEntry, repo string) (RepositorySettings, error) {
+	s := RepositorySettings{}
+	for _, listed := range []bool{true, false} {
+		for _, e := range entries {
+			if listed != (len(e.Repositories) > 0) || (listed && !containsRepo(repo, e.Repositories)) {
+				continue
+			}
+			if s.Enforcement == "" {
+				s.Enforcement = e.Enforcement
+			}
+			if s.BaselineCommit == "" {
+				s.BaselineCommit = e.BaselineCommit
+			}
+			if s.Cutover == "" {
+				s.Cutover = e.Cutover
+			}
+		}
+	}
+	for _, err := range []error{validateEnforcement(s.Enforcement), validateBaselineCommit(s.BaselineCommit), validateCutover(s.Cutover)} {
+		if err != nil {
+			return s, fmt.Errorf("invalid settings for repository %q: %w", repo, err)
+		}
+	}
+	return s, nil
+}
+
+// ParseCutover parses a cutover, either a date (e.g. "2022-01-01", at midnight
+// UTC) or an RFC 3339 time (e.g. "2022-01-01T12:00:00+01:00").
+func ParseCutover(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cutover %q, expected a date (YYYY-MM-DD) or an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// validateCutover checks that cutover is empty or a valid cutover.
+func validateCutover(cutover string) error {
+	if cutover == "" {
+		return nil
+	}
+	_, err := ParseCutover(cutover)
+	return err
+}
+
+// validateBaselineCommit checks that hash is empty or a full commit hash, so
+// that the baseline cannot be moved by moving a ref.
+func validateBaselineCommit(hash string) error {
+	if hash == "" {
+		return nil
+	}
+	if len(hash) != 40 || plumbing.NewHash(hash).String() != hash {
+		return fmt.Errorf("invalid baseline commit %q, expected a full lowercase commit hash", hash)
+	}
+	return nil
+}
+
+// validateEnforcement checks that enforcement is empty, EnforcementEnforce or
+// EnforcementReport.
+func validateEnforcement(enforcement string) error {
+	switch enforcement {
+	case "", EnforcementEnforce, EnforcementReport:
+		return nil
+	default:
+		return fmt.Errorf("invalid enforcement %q, expected %q or %q", enforcement, EnforcementEnforce, EnforcementReport)
+	}
+}
+
+// reportFailures turns the failures of the outcome and the outcomes of its
+// commits into warnings, for report-only enforcement. Their descriptions and
+// errors still say what failed.
+func reportFailures(o *Outcome) {
+	o.ReportOnly = true
+	if o.Result == FAIL {
+		o.Result = WARN
+	}
+	for _, co := range o.Commits {
+		reportFailures(co)
+	}
+	for _, mo := range o.FailedMergedCommits {
+		reportFailures(mo)
+	}
+}
@@ -0,0 +1,66 @@
+package action
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetSettingsForRepo(t *testing.T) {
+	entries := []RepositorySettingsEntry{
+		{Enforcement: EnforcementReport},
+		{Repositories: []string{"org/legacy"}},
+		{Enforcement: EnforcementEnforce, Repositories: []string{"org/graduated", "org/other"}},
+	}
+	tests := []struct {
+		repo     string
+		expected string
+	}{
+		{repo: "org/graduated", expected: EnforcementEnforce},
+		{repo: "org/other", expected: EnforcementEnforce},
+		// Settings that are not set are inherited from entries for all
+		// repositories.
+		{repo: "org/legacy", expected: EnforcementReport},
+		{repo: "org/new", expected: EnforcementReport},
+	}
+	for _, tt := range tests {
+		s, err := GetSettingsForRepo(entries, tt.repo)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.repo, err)
+		}
+		if s.Enforcement != tt.expected {
+			t.Errorf("%s: expected enforcement %q, got %q", tt.repo, tt.expected, s.Enforcement)
+		}
+	}
+
+	if s, _ := GetSettingsForRepo(nil, "org/repo"); s.Enforcement != "" {
+		t.Errorf("expected no enforcement without settings, got %q", s.Enforcement)
+	}
+	for _, e := range []RepositorySettingsEntry{
+		{Enforcement: "audit"},
+		{BaselineCommit: "HEAD~100"},
+		{Cutover: "01/01/2022"},
+	} {
+		if _, err := GetSettingsForRepo([]RepositorySettingsEntry{e}, "org/repo"); err == nil {
+			t.Errorf("expected error for invalid settings %+v", e)
+		}
+	}
+}
+
+func TestParseCutover(t *testing.T) {
+	tests := []struct {
+		cutover  string
+		expected time.Time
+	}{
+		{cutover: "2022-01-01", expected: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{cutover: "2022-01-01T12:00:00+01:00", expected: time.Date(2022, 1, 1, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseCutover(tt.cutover)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.cutover, err)
+		}
+		if !got.Equal(tt.expected) {
+			t.Errorf("%s: expected %s, got %s", tt.cutover, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// ErrCodeInvalidSnapshot is the OutcomeError code of an offline snapshot that is
+// expired, not yet valid, or not signed by a trusted key.
+const ErrCodeInvalidSnapshot = "INVALID_OFFLINE_SNAPSHOT"
+
+// SnapshotVersion is the version of the offline snapshot format.
+const SnapshotVersion = 1
+
+// snapshotClockSkew is how far in the future the issue time of an offline
+// snapshot may be, to allow for clock differences between machines.
+const snapshotClockSkew = 5 * time.Minute
+
+// Snapshot is a point-in-time export of authorized GPG keys, used to verify
+// commits without access to the Beyond Identity Key Management API.
+type Snapshot struct {
+	Version   int       `json:"version"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Entries are the authorized keys and the committer email addresses they
+	// are authorized for.
+	Entries []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry authorizes a GPG key for git commit signing by a committer.
+type SnapshotEntry struct {
+	KeyID          string `json:"key_id"`
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
+	CommitterEmail string `json:"committer_email"`
+	GPGKey         GPGKey `json:"gpg_key"`
+}
+
+// SignedSnapshot is the file format of an offline snapshot: the JSON encoded
+// Snapshot and an ASCII-armored detached signature over its compact encoding,
+// so that the file may be re-indented.
+type SignedSnapshot struct {
+	Snapshot  json.RawMessage `json:"snapshot"`
+	Signature string          `json:"signature"`
+}
+
+// SnapshotError is returned when an offline snapshot cannot be trusted.
+type SnapshotError string
+
+func (e SnapshotError) Error() string {
+	return fmt.Sprintf("invalid offline snapshot: %s", string(e))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e SnapshotError) Code() string {
+	return ErrCodeInvalidSnapshot
+}
+
+// NewSnapshot exports the authorized requests to a Snapshot that expires after
+// ttl. Requests that are not authorized are left out.
+func NewSnapshot(ctx context.Context, client APIClient, requests []AuthorizationRequest, now time.Time, ttl time.Duration) (*Snapshot, error) {
+	authorizations, err := client.GetAuthorizations(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{Version: SnapshotVersion, IssuedAt: now.UTC(), ExpiresAt: now.Add(ttl).UTC(), Entries: []SnapshotEntry{}}
+	for i, a := range authorizations {
+		if !a.Authorized {
+			continue
+		}
+		s.Entries = append(s.Entries, SnapshotEntry{
+			KeyID:          requests[i].KeyID,
+			KeyFingerprint: requests[i].KeyFingerprint,
+			CommitterEmail: requests[i].CommitterEmail,
+			GPGKey:         a.GPGKey,
+		})
+	}
+	return s, nil
+}
+
+// Sign signs the Snapshot with the private key of signer.
+func (s *Snapshot) Sign(signer *openpgp.Entity) (*SignedSnapshot, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	sig := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader(payload), nil); err != nil {
+		return nil, fmt.Errorf("failed to sign snapshot: %w", err)
+	}
+	return &SignedSnapshot{Snapshot: payload, Signature: sig.String()}, nil
+}
+
+// LoadSnapshot reads a SignedSnapshot from a file and verifies it. See
+// VerifySnapshot.
+func LoadSnapshot(filePath string, keyRing openpgp.EntityList, policy CryptoPolicy, now time.Time, maxAge time.Duration) (*Snapshot, error) {
+	bs, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline snapshot file at '%s': %w", filePath, err)
+	}
+
+	signed := SignedSnapshot{}
+	if err := json.Unmarshal(bs, &signed); err != nil {
+		return nil, SnapshotError(fmt.Sprintf("failed to parse '%s': %v", filePath, err))
+	}
+	return VerifySnapshot(signed, keyRing, policy, now, maxAge)
+}
+
+// VerifySnapshot checks that the SignedSnapshot is signed by a key in keyRing
+// and is valid at now. If maxAge is set, the snapshot must also have been
+// issued at most maxAge before now.
+func VerifySnapshot(signed SignedSnapshot, keyRing openpgp.EntityList, policy CryptoPolicy, now time.Time, maxAge time.Duration) (*Snapshot, error) {
+	payload := &bytes.Buffer{}
+	if err := json.Compact(payload, signed.Snapshot); err != nil {
+		return nil, SnapshotError(fmt.Sprintf("failed to parse snapshot: %v", err))
+	}
+	if _, _, err := checkArmoredDetachedSignature(keyRing, payload.String(), signed.Signature, policy); err != nil {
+		return nil, SnapshotError(fmt.Sprintf("signature check failed: %v", err))
+	}
+
+	s := &Snapshot{}
+	if err := json.Unmarshal(signed.Snapshot, s); err != nil {
+		return nil, SnapshotError(fmt.Sprintf("failed to parse snapshot: %v", err))
+	}
+	if s.Version != SnapshotVersion {
+		return nil, SnapshotError(fmt.Sprintf("unsupported version %d", s.Version))
+	}
+
+	switch {
+	case s.IssuedAt.After(now.Add(snapshotClockSkew)):
+		return nil, SnapshotError(fmt.Sprintf("issued in the future at %s", s.IssuedAt.Format(time.RFC3339)))
+	case !now.Before(s.ExpiresAt):
+		return nil, SnapshotError(fmt.Sprintf("expired at %s", s.ExpiresAt.Format(time.RFC3339)))
+	case maxAge > 0 && now.Sub(s.IssuedAt) > maxAge:
+		return nil, SnapshotError(fmt.Sprintf("issued at %s, more than %s ago", s.IssuedAt.Format(time.RFC3339), maxAge))
+	}
+	return s, nil
+}
+
+// AuthorizeKey authorizes a GPG key for git commit signing by a committer
+// using the entries of the snapshot. If the entry and the request both have a
+// fingerprint, they must match.
+func (s *Snapshot) AuthorizeKey(ctx context.Context, keyID, fingerprint, committerEmail string) (*Authorization, error) {
+	for _, e := range s.Entries {
+		if !strings.EqualFold(e.KeyID, keyID) || !strings.EqualFold(e.CommitterEmail, committerEmail) {
+			continue
+		}
+		if e.KeyFingerprint != "" && fingerprint != "" && normalizeFingerprint(e.KeyFingerprint) != normalizeFingerprint(fingerprint) {
+			continue
+		}
+		return &Authorization{Authorized: true, Message: "key is authorized for committer by offline snapshot", GPGKey: e.GPGKey}, nil
+	}
+	return &Authorization{Authorized: false, Message: "key is not authorized for committer by offline snapshot"}, nil
+}
+
+// LookupIdentity looks up whether the email address is the committer email
+// address of an entry of the snapshot.
+func (s *Snapshot) LookupIdentity(ctx context.Context, emailAddress string) (*Identity, error) {
+	for _, e := range s.Entries {
+		if strings.EqualFold(e.CommitterEmail, emailAddress) {
+			return &Identity{Authorized: true, Message: "email address has an authorized key in offline snapshot"}, nil
+		}
+	}
+	return &Identity{Authorized: false, Message: "email address has no authorized key in offline snapshot"}, nil
+}
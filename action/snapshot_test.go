@@ -0,0 +1,131 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func TestSnapshot(t *testing.T) {
+	admin := newTestEntity(t, "Snapshot Admin", "admin@example.com")
+	other := newTestEntity(t, "Mallory", "mallory@example.com")
+	keyRing := openpgp.EntityList{admin}
+
+	f := newFakeAPIServer(t, true)
+	f.Keys["87A2691085B4544E/john@doe.com"] = "john-key"
+	requests := []AuthorizationRequest{
+		{KeyID: "87A2691085B4544E", KeyFingerprint: "AAAA87A2691085B4544E", CommitterEmail: "john@doe.com"},
+		{KeyID: "7723AD85B1221B3B", CommitterEmail: "jane@doe.com"},
+	}
+
+	issuedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot, err := NewSnapshot(context.Background(), f.client(), requests, issuedAt, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(snapshot.Entries) != 1 {
+		t.Fatalf("expected only the authorized request in the snapshot, got %v", snapshot.Entries)
+	}
+
+	signed, err := snapshot.Sign(admin)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("reindented_file", func(t *testing.T) {
+		bs, err := json.MarshalIndent(signed, "", "    ")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		path := filepath.Join(t.TempDir(), "snapshot.json")
+		writeTestFile(t, path, string(bs))
+
+		loaded, err := LoadSnapshot(path, keyRing, CryptoPolicy{}, issuedAt.Add(time.Hour), 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		a, _ := loaded.AuthorizeKey(context.Background(), "87a2691085b4544e", "", "john@doe.com")
+		if !a.Authorized || a.GPGKey.Base64Key != "john-key" {
+			t.Errorf("expected john's key to be authorized, got %+v", a)
+		}
+		a, _ = loaded.AuthorizeKey(context.Background(), "87A2691085B4544E", "BBBB87A2691085B4544E", "john@doe.com")
+		if a.Authorized {
+			t.Errorf("expected mismatched fingerprint not to be authorized")
+		}
+		a, _ = loaded.AuthorizeKey(context.Background(), "87A2691085B4544E", "", "jane@doe.com")
+		if a.Authorized {
+			t.Errorf("expected key not to be authorized for another committer")
+		}
+	})
+
+	tests := []struct {
+		name        string
+		signed      SignedSnapshot
+		keyRing     openpgp.EntityList
+		now         time.Time
+		maxAge      time.Duration
+		expectedErr string
+	}{
+		{
+			name:    "valid",
+			signed:  *signed,
+			keyRing: keyRing,
+			now:     issuedAt.Add(time.Hour),
+		},
+		{
+			name:        "expired",
+			signed:      *signed,
+			keyRing:     keyRing,
+			now:         issuedAt.Add(24 * time.Hour),
+			expectedErr: "invalid offline snapshot: expired at 2026-01-02T00:00:00Z",
+		},
+		{
+			name:        "future",
+			signed:      *signed,
+			keyRing:     keyRing,
+			now:         issuedAt.Add(-time.Hour),
+			expectedErr: "invalid offline snapshot: issued in the future at 2026-01-01T00:00:00Z",
+		},
+		{
+			name:        "max_age",
+			signed:      *signed,
+			keyRing:     keyRing,
+			now:         issuedAt.Add(2 * time.Hour),
+			maxAge:      time.Hour,
+			expectedErr: "invalid offline snapshot: issued at 2026-01-01T00:00:00Z, more than 1h0m0s ago",
+		},
+		{
+			name:        "untrusted_signer",
+			signed:      *signed,
+			keyRing:     openpgp.EntityList{other},
+			now:         issuedAt.Add(time.Hour),
+			expectedErr: "invalid offline snapshot: signature check failed: openpgp: signature made by unknown entity",
+		},
+		{
+			name: "tampered",
+			signed: SignedSnapshot{
+				Snapshot:  json.RawMessage(strings.Replace(string(signed.Snapshot), "john-key", "evil-key", 1)),
+				Signature: signed.Signature,
+			},
+			keyRing:     keyRing,
+			now:         issuedAt.Add(time.Hour),
+			expectedErr: "invalid offline snapshot: signature check failed: openpgp: invalid signature: EdDSA verification failure",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifySnapshot(tt.signed, tt.keyRing, CryptoPolicy{}, tt.now, tt.maxAge)
+			assertEqualErr(t, tt.expectedErr, err)
+
+			if err != nil && NewOutcomeError(err).Code != ErrCodeInvalidSnapshot {
+				t.Errorf("expected outcome error code %s, got %q", ErrCodeInvalidSnapshot, NewOutcomeError(err).Code)
+			}
+		})
+	}
+}
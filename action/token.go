@@ -0,0 +1,146 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before its expiry an OAuth2 access token is
+// refreshed, so that it does not expire while a request is in flight.
+const tokenRefreshMargin = time.Minute
+
+// TokenSource provides the Bearer token for the Beyond Identity Key Management
+// API.
+type TokenSource interface {
+	// Token returns a currently valid token.
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticTokenSource is a TokenSource that always returns the same token.
+type StaticTokenSource string
+
+// Token implements TokenSource.
+func (s StaticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// FileTokenSource is a TokenSource that reads the token from a file, e.g. a
+// mounted secret. The file is read on every call, so a rotated token is picked
+// up without restarting.
+type FileTokenSource string
+
+// Token implements TokenSource.
+func (s FileTokenSource) Token(ctx context.Context) (string, error) {
+	bs, err := ioutil.ReadFile(string(s))
+	if err != nil {
+		return "", fmt.Errorf("failed to read api token file at '%s': %w", string(s), err)
+	}
+
+	token := strings.TrimSpace(string(bs))
+	if token == "" {
+		return "", fmt.Errorf("api token file at '%s' is empty", string(s))
+	}
+	return token, nil
+}
+
+// ClientCredentialsTokenSource is a TokenSource that obtains access tokens with
+// the OAuth2 client credentials grant (RFC 6749, section 4.4). Tokens are cached
+// and refreshed shortly before they expire. It is safe for concurrent use.
+type ClientCredentialsTokenSource struct {
+	HTTPClient   *http.Client
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenResponse is a successful response from an OAuth2 token endpoint.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token implements TokenSource.
+func (s *ClientCredentialsTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || time.Now().Add(tokenRefreshMargin).Before(s.expiry)) {
+		return s.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	requestedAt := time.Now()
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send token request: %w", err)
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", BadResponseError{
+			RequestMethod: req.Method,
+			RequestURL:    req.URL,
+			StatusCode:    resp.StatusCode,
+			Body:          body,
+			Header:        resp.Header,
+			Cause:         fmt.Errorf("expected status %d", http.StatusOK),
+		}
+	}
+
+	tr := tokenResponse{}
+	if err := json.Unmarshal(body, &tr); err != nil || tr.AccessToken == "" {
+		if err == nil {
+			err = fmt.Errorf("missing access_token")
+		}
+		return "", BadResponseError{
+			RequestMethod: req.Method,
+			RequestURL:    req.URL,
+			StatusCode:    resp.StatusCode,
+			Body:          []byte("<redacted>"),
+			Header:        resp.Header,
+			Cause:         err,
+		}
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return "", fmt.Errorf("unsupported token type: %q", tr.TokenType)
+	}
+
+	s.token = tr.AccessToken
+	s.expiry = time.Time{}
+	if tr.ExpiresIn > 0 {
+		s.expiry = requestedAt.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return s.token, nil
+}
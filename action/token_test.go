@@ -0,0 +1,148 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	var issued int
+	var expiresIn int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			http.Error(w, "invalid grant", http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("scope") != "keys:read keys:authorize" {
+			http.Error(w, "invalid scope", http.StatusBadRequest)
+			return
+		}
+		issued++
+		writeJSON(w, tokenResponse{AccessToken: fmt.Sprintf("token-%d", issued), TokenType: "Bearer", ExpiresIn: int64(expiresIn)})
+	}))
+	defer srv.Close()
+
+	source := &ClientCredentialsTokenSource{
+		HTTPClient:   srv.Client(),
+		TokenURL:     srv.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"keys:read", "keys:authorize"},
+	}
+
+	t.Run("cached_until_expiry", func(t *testing.T) {
+		expiresIn = 3600
+		for i := 0; i < 2; i++ {
+			token, err := source.Token(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token != "token-1" {
+				t.Errorf("expected token-1, got %s", token)
+			}
+		}
+	})
+
+	t.Run("refreshed_before_expiry", func(t *testing.T) {
+		// Expires within the refresh margin, so every call fetches a new token.
+		expiresIn = 30
+		source.expiry = time.Now()
+		for _, expected := range []string{"token-2", "token-3"} {
+			token, err := source.Token(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token != expected {
+				t.Errorf("expected %s, got %s", expected, token)
+			}
+		}
+	})
+
+	t.Run("invalid_client", func(t *testing.T) {
+		bad := &ClientCredentialsTokenSource{HTTPClient: srv.Client(), TokenURL: srv.URL, ClientID: "client", ClientSecret: "wrong"}
+		if _, err := bad.Token(context.Background()); err == nil {
+			t.Errorf("expected error for invalid client credentials")
+		}
+	})
+}
+
+func TestFileTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	source := FileTokenSource(path)
+
+	_, err := source.Token(context.Background())
+	if err == nil {
+		t.Fatalf("expected error for missing token file")
+	}
+
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		writeJSON(w, Authorization{Authorized: true})
+	}))
+	defer srv.Close()
+
+	c := APIClient{HTTPClient: srv.Client(), TokenSource: source, APIBaseURL: srv.URL}
+	for _, token := range []string{"first", "rotated"} {
+		writeTestFile(t, path, token+"\n")
+		if _, err := c.AuthorizeKey(context.Background(), "87A2691085B4544E", "", "john@doe.com"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{"Bearer first", "Bearer rotated"}
+	if len(tokens) != len(expected) || tokens[0] != expected[0] || tokens[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, tokens)
+	}
+}
+
+func TestConfigValidateAPICredentials(t *testing.T) {
+	base := Config{RepoPath: ".", CommitRef: "HEAD", APIBaseURL: "https://example.com", Repository: "repo"}
+
+	tests := []struct {
+		name        string
+		modify      func(c *Config)
+		expectedErr string
+	}{
+		{
+			name:        "none",
+			modify:      func(c *Config) {},
+			expectedErr: "missing config field: APIToken",
+		},
+		{
+			name:   "token_file",
+			modify: func(c *Config) { c.APITokenFile = "/run/secrets/token" },
+		},
+		{
+			name:        "token_and_token_file",
+			modify:      func(c *Config) { c.APIToken = "token"; c.APITokenFile = "/run/secrets/token" },
+			expectedErr: "only one of APIToken, APITokenFile and OAuthTokenURL may be set",
+		},
+		{
+			name:        "oauth_missing_secret",
+			modify:      func(c *Config) { c.OAuthTokenURL = "https://auth.example.com/token"; c.OAuthClientID = "client" },
+			expectedErr: "missing config field: OAuthClientSecret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.modify(&cfg)
+			var err error
+			if errs := cfg.Validate(); len(errs) > 0 {
+				err = errs[0]
+			}
+			assertEqualErr(t, tt.expectedErr, err)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrCodeUnauthorizedCoAuthors is the OutcomeError code of a commit with
+// co-authors that are not authorized.
+const ErrCodeUnauthorizedCoAuthors = "UNAUTHORIZED_CO_AUTHORS"
+
+// UnauthorizedCoAuthorsError is returned for a commit with Co-authored-by
+// trailers of email addresses that are neither on the allowlist nor known,
+// authorized identities. It lists those email addresses.
+type UnauthorizedCoAuthorsError []string
+
+func (e UnauthorizedCoAuthorsError) Error() string {
+	return fmt.Sprintf("co-authors are not authorized: %s", strings.Join(e, ", "))
+}
+
+// Code returns the OutcomeError code of the error.
+func (e UnauthorizedCoAuthorsError) Code() string {
+	return ErrCodeUnauthorizedCoAuthors
+}
+
+// Trailer is a "Key: value" line of the trailer block at the end of a commit
+// message, as written by `git interpret-trailers`.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+var (
+	trailerRegex  = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*): *(.*?)\s*$`)
+	coAuthorRegex = regexp.MustCompile(`^.*<([^<>\s]+)>$`)
+)
+
+// ParseTrailers returns the trailers of the commit message. The trailer block
+// is the last paragraph of the message, if the message has more than one.
+// Lines of the block that are not trailers are ignored.
+func ParseTrailers(message string) []Trailer {
+	paragraphs := strings.Split(strings.TrimSpace(strings.ReplaceAll(message, "\r\n", "\n")), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+
+	trailers := []Trailer{}
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if m := trailerRegex.FindStringSubmatch(line); m != nil {
+			trailers = append(trailers, Trailer{Key: m[1], Value: m[2]})
+		}
+	}
+	return trailers
+}
+
+// ParseCoAuthors returns the email addresses of the Co-authored-by trailers of
+// the commit message (e.g. "Co-authored-by: Jane Doe <jane@doe.com>"), in
+// order and without duplicates.
+func ParseCoAuthors(message string) []string {
+	emails := []string{}
+	seen := map[string]bool{}
+	for _, t := range ParseTrailers(message) {
+		if !strings.EqualFold(t.Key, "Co-authored-by") {
+			continue
+		}
+		m := coAuthorRegex.FindStringSubmatch(t.Value)
+		if m == nil || seen[strings.ToLower(m[1])] {
+			continue
+		}
+		seen[strings.ToLower(m[1])] = true
+		emails = append(emails, m[1])
+	}
+	return emails
+}
+
+// verifyCoAuthors fails o if a co-author of the commit is neither on the email
+// addresses of the allowlist nor a known, authorized identity in the Beyond
+// Identity directory or offline snapshot.
+func (v *verification) verifyCoAuthors(ctx context.Context, o *Outcome) {
+	if len(o.Commit.CoAuthors) == 0 {
+		return
+	}
+	_, loaded := v.getRepoAllowlist(ctx, o.Commit.Kind)
+
+	if v.authorizerErr != nil {
+		o.SetErrors(v.authorizerErr)
+		o.SetResultAndDescription(FAIL, "Failed to configure the authorizer. See errors for details.")
+		return
+	}
+	directory, _ := v.getAuthorizer()
+
+	unauthorized := UnauthorizedCoAuthorsError{}
+	for _, email := range o.Commit.CoAuthors {
+		if verifyCommitByEmailAddress(email, loaded.repoAllowlist.EmailAddresses) {
+			v.logger.Printf("Co-author %q is on email address allowlist\n\n", email)
+			continue
+		}
+		identity, err := directory.LookupIdentity(ctx, email)
+		if err != nil {
+			o.SetErrors(fmt.Errorf("failed to look up co-author %q: %w", email, err))
+			o.SetResultAndDescription(FAIL, "Failed to look up co-authors. See errors for details.")
+			return
+		}
+		if identity != nil && identity.Authorized {
+			v.logger.Printf("Co-author %q is an authorized Beyond Identity user\n\n", email)
+			continue
+		}
+		unauthorized = append(unauthorized, email)
+	}
+	if len(unauthorized) > 0 {
+		o.UnauthorizedCoAuthors = unauthorized
+		o.SetErrors(unauthorized)
+		o.SetResultAndDescription(FAIL, fmt.Sprintf("%d of %d co-authors are not authorized. See errors for details.", len(unauthorized), len(o.Commit.CoAuthors)))
+	}
+}
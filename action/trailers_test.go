@@ -0,0 +1,46 @@
+package action
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCoAuthors(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name: "trailers",
+			message: "Pair on the parser\n\nBody.\n\n" +
+				"Co-authored-by: Jane Doe <jane@doe.com>\n" +
+				"co-authored-by: John <john@doe.com>\n" +
+				"Signed-off-by: Jane Doe <jane@doe.com>\n" +
+				"Co-authored-by: Jane Doe <JANE@doe.com>\n",
+			expected: []string{"jane@doe.com", "john@doe.com"},
+		},
+		{
+			name:     "not_last_paragraph",
+			message:  "Subject\n\nCo-authored-by: Jane Doe <jane@doe.com>\n\nBody.\n",
+			expected: []string{},
+		},
+		{
+			name:     "subject_only",
+			message:  "Co-authored-by: Jane Doe <jane@doe.com>",
+			expected: []string{},
+		},
+		{
+			name:     "without_email",
+			message:  "Subject\n\nCo-authored-by: Jane Doe\n",
+			expected: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseCoAuthors(tt.message); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
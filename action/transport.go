@@ -0,0 +1,168 @@
+package action
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// apiRequestTimeout is the timeout of a single request to the Beyond Identity
+// Key Management API or the OAuth2 token endpoint.
+const apiRequestTimeout = 30 * time.Second
+
+// tlsVersions maps the minimum TLS versions accepted in a TransportConfig to
+// their crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TransportConfig configures the connection to the Beyond Identity Key
+// Management API. The zero value uses the system CA pool, the proxy from the
+// environment (HTTPS_PROXY, NO_PROXY) and TLS 1.2 or later.
+type TransportConfig struct {
+	// CACertFile is a path to a PEM file of additional CA certificates that
+	// are trusted for the API server, e.g. of a TLS-intercepting proxy.
+	CACertFile string
+	// ClientCertFile is a path to a PEM client certificate used for mutual
+	// TLS. Requires ClientKeyFile.
+	ClientCertFile string
+	// ClientKeyFile is a path to the PEM private key of ClientCertFile.
+	ClientKeyFile string
+	// ProxyURL is the URL of the proxy used for API requests. Defaults to the
+	// proxy from the environment.
+	ProxyURL string
+	// MinTLSVersion is the minimum TLS version ("1.0", "1.1", "1.2" or "1.3").
+	// Defaults to "1.2".
+	MinTLSVersion string
+	// PinnedCertSHA256 is a list of base64 SHA-256 hashes of the subject public
+	// key info of certificates. If set, a verified certificate chain of the API
+	// server must contain one of them. Surrounding whitespace is ignored.
+	PinnedCertSHA256 []string
+}
+
+// Validate checks that the TransportConfig is valid, without reading any
+// files.
+func (c TransportConfig) Validate() []error {
+	var errs []error
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		errs = append(errs, fmt.Errorf("both client certificate and client key must be set for mutual TLS"))
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid proxy url: %q", c.ProxyURL))
+		}
+	}
+	if _, ok := tlsVersions[c.MinTLSVersion]; c.MinTLSVersion != "" && !ok {
+		errs = append(errs, fmt.Errorf("invalid minimum TLS version: %q", c.MinTLSVersion))
+	}
+	_, pinErrs := c.pins()
+	return append(errs, pinErrs...)
+}
+
+// pins returns the set of valid PinnedCertSHA256 hashes, without surrounding
+// whitespace, and an error for each invalid one.
+func (c TransportConfig) pins() (map[string]bool, []error) {
+	pins := map[string]bool{}
+	var errs []error
+	for _, pin := range c.PinnedCertSHA256 {
+		pin = strings.TrimSpace(pin)
+		if bs, err := base64.StdEncoding.DecodeString(pin); err != nil || len(bs) != sha256.Size {
+			errs = append(errs, fmt.Errorf("invalid pinned certificate SHA-256 hash: %q", pin))
+			continue
+		}
+		pins[pin] = true
+	}
+	return pins, errs
+}
+
+// NewHTTPClient returns an http.Client for API requests that is configured by
+// the TransportConfig.
+func NewHTTPClient(c TransportConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.MinTLSVersion != "" {
+		v, ok := tlsVersions[c.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid minimum TLS version: %q", c.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = v
+	}
+
+	if c.CACertFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		bs, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate file at '%s': %w", c.CACertFile, err)
+		}
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("no certificates found in CA certificate file at '%s'", c.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCertFile != "" || c.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(c.PinnedCertSHA256) > 0 {
+		pins, errs := c.pins()
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkPinnedCertificate(cs.VerifiedChains, pins)
+		}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: apiRequestTimeout}, nil
+}
+
+// checkPinnedCertificate checks that one of the verified certificate chains of
+// the server contains a certificate with the SHA-256 hash of its subject public
+// key info pinned. Certificates the server presents outside of a verified
+// chain are not considered, as anyone can present them.
+func checkPinnedCertificate(verifiedChains [][]*x509.Certificate, pins map[string]bool) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if pins[CertificateSHA256(cert)] {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no verified certificate chain of the server matches a pinned SHA-256 hash")
+}
+
+// CertificateSHA256 returns the base64 SHA-256 hash of the subject public key
+// info of the certificate, as used by TransportConfig.PinnedCertSHA256.
+func CertificateSHA256(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
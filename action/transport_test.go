@@ -0,0 +1,258 @@
+package action
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	serverCert := srv.Certificate()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeTestFile(t, caFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Raw})))
+
+	tests := []struct {
+		name        string
+		cfg         TransportConfig
+		expectedErr string
+	}{
+		{
+			name:        "untrusted",
+			cfg:         TransportConfig{},
+			expectedErr: "x509: certificate signed by unknown authority",
+		},
+		{
+			name: "custom_ca",
+			cfg:  TransportConfig{CACertFile: caFile},
+		},
+		{
+			name: "pinned",
+			cfg:  TransportConfig{CACertFile: caFile, PinnedCertSHA256: []string{CertificateSHA256(serverCert)}},
+		},
+		{
+			name:        "pin_mismatch",
+			cfg:         TransportConfig{CACertFile: caFile, PinnedCertSHA256: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}},
+			expectedErr: "no verified certificate chain of the server matches a pinned SHA-256 hash",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+// testCertificate is a self-signed certificate for 127.0.0.1 and its key.
+type testCertificate struct {
+	Cert    *x509.Certificate
+	Key     *ecdsa.PrivateKey
+	CertPEM string
+	KeyPEM  string
+}
+
+func newTestCertificate(t *testing.T, commonName string, usage x509.ExtKeyUsage) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return &testCertificate{
+		Cert:    cert,
+		Key:     key,
+		CertPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		KeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+// TestNewHTTPClientPinAppendedCertificate checks that a server with a trusted
+// certificate cannot pass the pin by presenting the pinned certificate outside
+// of its verified chain, as a TLS-intercepting proxy could.
+func TestNewHTTPClientPinAppendedCertificate(t *testing.T) {
+	pinned := newTestCertificate(t, "api", x509.ExtKeyUsageServerAuth)
+	proxy := newTestCertificate(t, "proxy", x509.ExtKeyUsageServerAuth)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{proxy.Cert.Raw, pinned.Cert.Raw},
+		PrivateKey:  proxy.Key,
+	}}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeTestFile(t, caFile, proxy.CertPEM)
+
+	client, err := NewHTTPClient(TransportConfig{CACertFile: caFile, PinnedCertSHA256: []string{CertificateSHA256(pinned.Cert)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "no verified certificate chain of the server matches a pinned SHA-256 hash") {
+		t.Errorf("expected the connection to be refused, got %v", err)
+	}
+}
+
+func TestNewHTTPClientMutualTLS(t *testing.T) {
+	client := newTestCertificate(t, "client", x509.ExtKeyUsageClientAuth)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(client.Cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeTestFile(t, caFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})))
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writeTestFile(t, certFile, client.CertPEM)
+	writeTestFile(t, keyFile, client.KeyPEM)
+
+	for _, tt := range []struct {
+		name      string
+		cfg       TransportConfig
+		expectErr bool
+	}{
+		{name: "client_certificate", cfg: TransportConfig{CACertFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile}},
+		{name: "no_client_certificate", cfg: TransportConfig{CACertFile: caFile}, expectErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewHTTPClient(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp, err := c.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.expectErr {
+				t.Errorf("expected error %v, got %v", tt.expectErr, err)
+			}
+		})
+	}
+
+	if _, err := NewHTTPClient(TransportConfig{ClientCertFile: certFile, ClientKeyFile: caFile}); err == nil {
+		t.Errorf("expected error for mismatched client certificate and key")
+	}
+}
+
+func TestNewHTTPClientProxyURL(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(TransportConfig{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := client.Get("http://api.example.invalid/v0/ping")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if proxied != "http://api.example.invalid/v0/ping" {
+		t.Errorf("expected the request to be sent through the proxy, got %q", proxied)
+	}
+}
+
+func TestNewHTTPClientMinTLSVersion(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeTestFile(t, caFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})))
+
+	client, err := NewHTTPClient(TransportConfig{CACertFile: caFile, MinTLSVersion: "1.3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp, err := client.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected handshake with a TLS 1.2 server to fail")
+	}
+}
+
+func TestTransportConfigValidate(t *testing.T) {
+	errs := TransportConfig{
+		ClientCertFile:   "client.pem",
+		ProxyURL:         "proxy:3128",
+		MinTLSVersion:    "1.4",
+		PinnedCertSHA256: []string{" 47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=\n", "not-a-hash"},
+	}.Validate()
+	expected := []string{
+		"both client certificate and client key must be set for mutual TLS",
+		`invalid proxy url: "proxy:3128"`,
+		`invalid minimum TLS version: "1.4"`,
+		`invalid pinned certificate SHA-256 hash: "not-a-hash"`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i := range expected {
+		assertEqualErr(t, expected[i], errs[i])
+	}
+}
@@ -8,6 +8,8 @@ import (
 // https://www.w3.org/TR/2016/REC-html51-20161101/sec-forms.html#email-state-typeemail
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+var fingerprintRegex = regexp.MustCompile("^(?:[0-9A-F]{40}|[0-9A-F]{64})$")
+
 // Email validates that a string is a valid email address.
 func Email(s string) error {
 	if len(s) > 254 {
@@ -20,3 +22,13 @@ func Email(s string) error {
 
 	return nil
 }
+
+// Fingerprint validates that a string is a full hex PGP key fingerprint
+// (v4 or v5). Spaces, as printed by gpg, are ignored.
+func Fingerprint(s string) error {
+	if !fingerprintRegex.MatchString(normalizeFingerprint(s)) {
+		return fmt.Errorf("invalid fingerprint format: %q", s)
+	}
+
+	return nil
+}
@@ -0,0 +1,780 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Logger logs the progress of verification. *log.Logger is a Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// RepositoryOpener opens the git repository at path, like git.PlainOpen.
+type RepositoryOpener func(path string) (*git.Repository, error)
+
+// VerifierOption configures a Verifier, see NewVerifier.
+type VerifierOption func(*Verifier)
+
+// WithAuthorizer authorizes the keys of commits with a, instead of the Beyond
+// Identity API or offline snapshot configured by the Config.
+func WithAuthorizer(a Authorizer) VerifierOption {
+	return func(v *Verifier) {
+		v.authorizerOption = a
+	}
+}
+
+// WithAllowlist uses the allowlist, instead of loading the allowlist configured
+// by the Config.
+func WithAllowlist(allowlistYAML *AllowlistYAML) VerifierOption {
+	return func(v *Verifier) {
+		v.allowlistYAML = allowlistYAML
+	}
+}
+
+// WithClock uses now as the current time, e.g. to check the expiry of keys,
+// signatures, offline snapshots and cached results.
+func WithClock(now func() time.Time) VerifierOption {
+	return func(v *Verifier) {
+		v.now = now
+	}
+}
+
+// WithLogger logs to logger instead of the standard logger.
+func WithLogger(logger Logger) VerifierOption {
+	return func(v *Verifier) {
+		v.logger = logger
+	}
+}
+
+// WithHTTPClient sends the requests to the Beyond Identity API, keyservers and
+// Web Key Directories with client, instead of clients configured by the Config.
+func WithHTTPClient(client *http.Client) VerifierOption {
+	return func(v *Verifier) {
+		v.httpClient = client
+	}
+}
+
+// WithRepositoryOpener opens git repositories with open instead of
+// git.PlainOpen, e.g. to verify commits of a repository in memory.
+func WithRepositoryOpener(open RepositoryOpener) VerifierOption {
+	return func(v *Verifier) {
+		v.openRepository = open
+	}
+}
+
+// SetupError is returned when commits cannot be verified at all, e.g. because
+// the Config is invalid, the allowlist cannot be loaded or a ref cannot be
+// resolved.
+type SetupError struct {
+	// Desc describes the failure, as in the description of an Outcome.
+	Desc string
+	Errs []error
+}
+
+func (e *SetupError) Error() string {
+	msgs := []string{}
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%s %s", e.Desc, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the first error.
+func (e *SetupError) Unwrap() error {
+	if len(e.Errs) == 0 {
+		return nil
+	}
+	return e.Errs[0]
+}
+
+// CommitResult is the result of verifying a commit.
+type CommitResult struct {
+	Hash plumbing.Hash
+	// Result is PASS, FAIL, WARN, EXEMPT or SKIPPED.
+	Result string
+	// VerifiedBy is how the commit passed verification (e.g.
+	// "BI_MANAGED_KEY"), if it passed.
+	VerifiedBy string
+	// Cached is set if the result was read from the notes cache.
+	Cached bool
+	// Errors are the errors that occurred while verifying the commit, which
+	// may be inspected with errors.As (e.g. for a CryptoPolicyError). They are
+	// not available for cached results, see the Errors of the Outcome instead.
+	Errors []error
+	// Outcome is the Outcome of the commit, as output by the action.
+	Outcome *Outcome
+}
+
+// Passed returns whether the commit passed verification, or is exempt from it.
+func (r *CommitResult) Passed() bool {
+	return r.Result == PASS || r.Result == EXEMPT
+}
+
+func newCommitResult(o *Outcome) *CommitResult {
+	r := &CommitResult{Result: o.Result, Cached: o.Cached, Errors: o.errs, Outcome: o}
+	if o.Commit != nil {
+		r.Hash = plumbing.NewHash(o.Commit.CommitHash)
+	}
+	if o.VerificationDetails != nil {
+		r.VerifiedBy = o.VerificationDetails.VerifiedBy
+	}
+	return r
+}
+
+// RangeResult is the result of verifying a range of commits.
+type RangeResult struct {
+	// Result is PASS if all commits of the range passed or are exempt, or else
+	// FAIL, or WARN with report-only enforcement.
+	Result string
+	// Commits are the results of the commits of the range, oldest first.
+	Commits []*CommitResult
+	// Unverifiable are the hashes of the commits of the range whose parents
+	// are missing from a shallow clone, see UnverifiableCommitsError.
+	Unverifiable []plumbing.Hash
+	// Errors are the errors of the range itself, e.g. an
+	// UnverifiableCommitsError. The errors of each commit are in Commits.
+	Errors []error
+	// Outcome is the Outcome of the range, as output by the action.
+	Outcome *Outcome
+}
+
+// Passed returns whether all commits of the range passed verification.
+func (r *RangeResult) Passed() bool {
+	return r.Result == PASS
+}
+
+// Failed returns the results of the commits that failed verification,
+// including those with the result WARN.
+func (r *RangeResult) Failed() []*CommitResult {
+	failed := []*CommitResult{}
+	for _, c := range r.Commits {
+		if c.Result == FAIL || c.Result == WARN {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// Verifier verifies the signatures of commits in a git repository, as
+// configured by a Config and VerifierOptions. The allowlist is loaded once,
+// when the Verifier is created. A Verifier is safe for concurrent use.
+type Verifier struct {
+	cfg              Config
+	authorizerOption Authorizer
+	now              func() time.Time
+	logger           Logger
+	httpClient       *http.Client
+	openRepository   RepositoryOpener
+	repo             *git.Repository
+
+	allowlistYAML *AllowlistYAML
+	// allowlistCommit is the commit the allowlist was read from, if any.
+	allowlistCommit string
+	// enforcement is the enforcement of the repository, see Enforcement.
+	enforcement string
+	// baseline is the baseline commit of the repository, if any. Its
+	// ancestors are only listed when they are first needed.
+	baseline          *object.Commit
+	baselineOnce      sync.Once
+	baselineAncestors map[plumbing.Hash]bool
+	baselineErr       error
+	// cutover is the cutover of the repository, if any.
+	cutover time.Time
+	// breakGlassKeyRing are the keys that sign break-glass tokens, if
+	// configured.
+	breakGlassKeyRing openpgp.EntityList
+
+	keySourceOnce sync.Once
+	keySource     KeySource
+	keySourceErr  error
+
+	mu             sync.Mutex
+	repoAllowlists map[string]*loadedRepoAllowlist
+
+	authorizerOnce sync.Once
+	authorizer     *limitedAuthorizer
+	authorizerErr  error
+
+	// notesCache caches the results of commits, if configured.
+	notesCache *NotesCache
+}
+
+// loadedRepoAllowlist is the RepoAllowlist of one kind of commit, with the keys
+// of its enabled platforms and the errors that occurred while loading it.
+type loadedRepoAllowlist struct {
+	repoAllowlist    *RepoAllowlist
+	platformKeyRings map[string]openpgp.EntityList
+	errs             []error
+}
+
+// NewVerifier returns a Verifier for the repository at cfg.RepoPath. The
+// CommitRef and BaseRef of cfg are not used, see VerifyCommit and VerifyRange.
+// The API settings of cfg are not required if WithAuthorizer is given.
+// Returns a SetupError if the Config is invalid, or the repository, allowlist
+// or notes cache cannot be opened.
+func NewVerifier(cfg Config, opts ...VerifierOption) (*Verifier, error) {
+	v := &Verifier{
+		cfg:            cfg,
+		now:            time.Now,
+		logger:         log.Default(),
+		openRepository: git.PlainOpen,
+		repoAllowlists: map[string]*loadedRepoAllowlist{},
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	// Signatures and keys are checked against the clock of the verifier.
+	cfg.CryptoPolicy = cfg.CryptoPolicy.withClock(v.now)
+	v.cfg = cfg
+
+	if errs := cfg.validate(false, v.authorizerOption == nil); len(errs) > 0 {
+		return nil, &SetupError{Desc: "Invalid config. See errors for details.", Errs: errs}
+	}
+	// The Beyond Identity API and offline snapshot can look up co-authors, but
+	// an Authorizer from WithAuthorizer may not.
+	if _, ok := v.authorizerOption.(IdentityDirectory); cfg.VerifyCoAuthors && v.authorizerOption != nil && !ok {
+		return nil, &SetupError{Desc: "Failed to configure the authorizer. See errors for details.", Errs: []error{errNoIdentityDirectory}}
+	}
+
+	var err error
+	v.repo, err = v.openRepository(cfg.RepoPath)
+	if err != nil {
+		return nil, &SetupError{Desc: "Failed to open the repository. See errors for details.", Errs: []error{fmt.Errorf("failed to open repository: %w", err)}}
+	}
+
+	if v.allowlistYAML == nil {
+		v.allowlistYAML, v.allowlistCommit, err = v.loadAllowlist()
+		if err != nil {
+			return nil, &SetupError{Desc: "Failed to load the allowlist. See errors for details.", Errs: splitErrors(err)}
+		}
+	}
+
+	settings, err := GetSettingsForRepo(v.allowlistYAML.RepositorySettings, cfg.Repository)
+	if err != nil {
+		return nil, &SetupError{Desc: "Failed to load the allowlist. See errors for details.", Errs: []error{err}}
+	}
+	v.enforcement = settings.Enforcement
+	if v.enforcement == "" {
+		v.enforcement = cfg.Enforcement
+	}
+	if v.enforcement == "" {
+		v.enforcement = EnforcementEnforce
+	}
+
+	baseline := settings.BaselineCommit
+	if baseline == "" {
+		baseline = cfg.BaselineCommit
+	}
+	if baseline != "" {
+		v.baseline, err = resolveCommit(v.repo, baseline, "baseline commit")
+		if err != nil {
+			return nil, &SetupError{Desc: "Failed to resolve the baseline commit. See errors for details.", Errs: []error{err}}
+		}
+	}
+	cutover := settings.Cutover
+	if cutover == "" {
+		cutover = cfg.Cutover
+	}
+	if cutover != "" {
+		// The cutover has been validated.
+		v.cutover, _ = ParseCutover(cutover)
+	}
+
+	if cfg.BreakGlassKeyRingFilePath != "" {
+		v.breakGlassKeyRing, err = LoadBreakGlassKeys(cfg.BreakGlassKeyRingFilePath, cfg.BreakGlassKeyFingerprints)
+		if err != nil {
+			return nil, &SetupError{Desc: "Failed to load the break-glass keyring. See errors for details.", Errs: []error{err}}
+		}
+	}
+
+	if cfg.NotesCacheRef != "" {
+		v.notesCache, err = newNotesCache(cfg, v.repo, v.allowlistYAML, v.authorizerOption)
+		if err != nil {
+			return nil, &SetupError{Desc: "Failed to open the notes cache. See errors for details.", Errs: []error{err}}
+		}
+	}
+	return v, nil
+}
+
+// Enforcement returns the enforcement of the repository, EnforcementEnforce or
+// EnforcementReport. With EnforcementReport, failures have the result WARN
+// instead of FAIL.
+func (v *Verifier) Enforcement() string {
+	return v.enforcement
+}
+
+// VerifyCommit verifies the commit that ref resolves to. Returns a SetupError
+// if ref cannot be resolved.
+func (v *Verifier) VerifyCommit(ctx context.Context, ref string) (*CommitResult, error) {
+	v.logger.Printf("Verifying commit with ref %q in %q", ref, v.cfg.RepoPath)
+
+	commit, err := resolveCommit(v.repo, ref, "ref")
+	if err != nil {
+		errs := []error{err}
+		if v.cfg.SyntheticMergeCommit != "" {
+			// actions/checkout fetches only the merge commit of a pull request by default.
+			errs = append(errs, fmt.Errorf("the head commit of the pull request may be missing from the clone, check out %s instead of the merge commit", ref))
+		}
+		return nil, &SetupError{Desc: "Failed to get commit. See errors for details.", Errs: errs}
+	}
+
+	v.logger.Printf("\nCommit:\n================\n%s\n================\n\n", PrettyPrintCommit(commit))
+
+	if cached := v.cachedOutcome(commit); cached != nil {
+		cached.AllowlistCommit = v.allowlistCommit
+		v.enforce(cached)
+		return newCommitResult(cached), nil
+	}
+
+	o := v.newOutcome(commit)
+	o.AllowlistCommit = v.allowlistCommit
+	v.newVerification(ctx, nil, []*object.Commit{commit}).verify(ctx, o, commit)
+	v.cacheOutcome(o)
+	v.flushNotesCache(o)
+	v.enforce(o)
+	return newCommitResult(o), nil
+}
+
+// VerifyRange verifies all commits reachable from headRef but not from
+// baseRef, with Config.Parallelism commits at once. The range only passes if
+// all of its commits pass. With report-only enforcement, all commits are
+// verified even if FailFast is set. Returns a SetupError if the commits cannot
+// be listed.
+func (v *Verifier) VerifyRange(ctx context.Context, baseRef, headRef string) (*RangeResult, error) {
+	v.logger.Printf("Verifying commits in range %q..%q in %q", baseRef, headRef, v.cfg.RepoPath)
+
+	commits, missing, err := listCommits(v.repo, baseRef, headRef)
+	if err != nil {
+		return nil, &SetupError{Desc: "Failed to list commits. See errors for details.", Errs: []error{err}}
+	}
+
+	o := &Outcome{Version: version, Repository: v.cfg.Repository, Errors: []OutcomeError{}, AllowlistCommit: v.allowlistCommit}
+
+	// Commits with a trusted cached result are not verified again.
+	cached := map[plumbing.Hash]*Outcome{}
+	uncached := []*object.Commit{}
+	for _, commit := range commits {
+		if co := v.cachedOutcome(commit); co != nil {
+			cached[commit.Hash] = co
+		} else {
+			uncached = append(uncached, commit)
+		}
+	}
+	vn := v.newVerification(ctx, uncached, commits)
+
+	failFast := v.cfg.FailFast && v.enforcement != EnforcementReport
+	o.Commits = verifyCommits(ctx, commits, v.cfg.Parallelism, failFast, func(ctx context.Context, commit *object.Commit) *Outcome {
+		if co, ok := cached[commit.Hash]; ok {
+			return co
+		}
+		co := v.newOutcome(commit)
+		vn.verify(ctx, co, commit)
+		v.cacheOutcome(co)
+		return co
+	})
+	v.flushNotesCache(o)
+
+	// Parents missing from a shallow clone are exempt if the baseline commit
+	// is their descendant.
+	missing, err = v.withoutBaselineAncestors(missing)
+	if err != nil {
+		o.SetErrors(err)
+	}
+	unverifiable := v.reportUnverifiableCommits(o, missing)
+
+	failed, skipped, exempt := 0, 0, 0
+	for _, co := range o.Commits {
+		o.BreakGlassCommits = append(o.BreakGlassCommits, co.BreakGlassCommits...)
+		switch co.Result {
+		case FAIL:
+			failed++
+		case SKIPPED:
+			skipped++
+		case EXEMPT:
+			exempt++
+		}
+	}
+	switch {
+	case failed > 0:
+		o.SetResultAndDescription(FAIL, fmt.Sprintf("%d of %d commits failed verification. See commits for details.", failed, len(o.Commits)))
+	case skipped > 0:
+		o.SetResultAndDescription(FAIL, fmt.Sprintf("%d of %d commits were not verified. See commits for details.", skipped, len(o.Commits)))
+	case unverifiable:
+		o.SetResultAndDescription(FAIL, "Commits are missing from the shallow clone and cannot be verified. See errors for details.")
+	case exempt > 0:
+		o.SetResultAndDescription(PASS, fmt.Sprintf("%d of %d commits passed verification, the other %d are exempt.", len(o.Commits)-exempt, len(o.Commits), exempt))
+	default:
+		o.SetResultAndDescription(PASS, fmt.Sprintf("All %d commits passed verification.", len(o.Commits)))
+	}
+	v.enforce(o)
+
+	r := &RangeResult{Result: o.Result, Unverifiable: missing, Errors: o.errs, Outcome: o}
+	for _, co := range o.Commits {
+		r.Commits = append(r.Commits, newCommitResult(co))
+	}
+	return r, nil
+}
+
+// enforce turns the failures of the outcome into warnings, if the enforcement is
+// report-only.
+func (v *Verifier) enforce(o *Outcome) {
+	if v.enforcement == EnforcementReport {
+		reportFailures(o)
+	}
+}
+
+// exemption returns why the commit is exempt from verification, or an empty
+// string if it is not. Commits are exempt if they are the baseline commit or
+// one of its ancestors, or if they were committed before the cutover.
+func (v *Verifier) exemption(commit *object.Commit) (string, error) {
+	if v.baseline != nil {
+		ancestors, err := v.getBaselineAncestors()
+		if err != nil {
+			return "", err
+		}
+		if ancestors[commit.Hash] {
+			if commit.Hash == v.baseline.Hash {
+				return fmt.Sprintf("Commit is the baseline commit %s and exempt from verification.", v.baseline.Hash), nil
+			}
+			return fmt.Sprintf("Commit is an ancestor of the baseline commit %s and exempt from verification.", v.baseline.Hash), nil
+		}
+	}
+	if !v.cutover.IsZero() && commit.Committer.When.Before(v.cutover) {
+		return fmt.Sprintf("Commit was committed at %s, before the cutover %s, and is exempt from verification.", commit.Committer.When.UTC().Format(time.RFC3339), v.cutover.UTC().Format(time.RFC3339)), nil
+	}
+	return "", nil
+}
+
+// getBaselineAncestors returns the baseline commit and its ancestors, including
+// those missing from a shallow clone.
+func (v *Verifier) getBaselineAncestors() (map[plumbing.Hash]bool, error) {
+	v.baselineOnce.Do(func() {
+		v.baselineAncestors = map[plumbing.Hash]bool{}
+		_, v.baselineErr = walkCommits(v.baseline, v.baselineAncestors, func(*object.Commit) {})
+	})
+	if v.baselineErr != nil {
+		return nil, fmt.Errorf("failed to list the ancestors of the baseline commit: %w", v.baselineErr)
+	}
+	return v.baselineAncestors, nil
+}
+
+// withoutBaselineAncestors returns the hashes of the commits with parents
+// missing from a shallow clone that are not the baseline commit or one of its
+// ancestors.
+func (v *Verifier) withoutBaselineAncestors(hashes []plumbing.Hash) ([]plumbing.Hash, error) {
+	if v.baseline == nil || len(hashes) == 0 {
+		return hashes, nil
+	}
+	ancestors, err := v.getBaselineAncestors()
+	if err != nil {
+		return hashes, err
+	}
+	remaining := []plumbing.Hash{}
+	for _, h := range hashes {
+		commit, err := v.repo.CommitObject(h)
+		if err != nil {
+			return hashes, fmt.Errorf("failed to open commit %s: %w", h, err)
+		}
+		for _, p := range commit.ParentHashes {
+			if ancestors[p] {
+				continue
+			}
+			if _, err := v.repo.CommitObject(p); errors.Is(err, plumbing.ErrObjectNotFound) {
+				remaining = append(remaining, h)
+				break
+			}
+		}
+	}
+	return remaining, nil
+}
+
+// newOutcome returns the Outcome of verifying the commit, before it is
+// verified.
+func (v *Verifier) newOutcome(commit *object.Commit) *Outcome {
+	o := &Outcome{Version: version, Repository: v.cfg.Repository, Errors: []OutcomeError{}}
+	o.SetCommit(commit)
+	return o
+}
+
+// loadAllowlist loads the allowlist configured by cfg, verifying its signature
+// if signing keys are configured. Returns the hash of the commit the allowlist
+// was read from, if it was read from a git ref.
+func (v *Verifier) loadAllowlist() (*AllowlistYAML, string, error) {
+	cfg := v.cfg
+
+	var keyRing openpgp.EntityList
+	if cfg.AllowlistSigningKeyRingFilePath != "" {
+		var err error
+		keyRing, err = LoadAllowlistSigningKeys(cfg.AllowlistSigningKeyRingFilePath, cfg.AllowlistSigningKeyFingerprints)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if cfg.AllowlistConfigSource != "" {
+		src, err := ParseAllowlistSource(cfg.AllowlistConfigSource)
+		if err != nil {
+			return nil, "", err
+		}
+		return src.load(v.openRepository, keyRing, cfg.CryptoPolicy, v.logger)
+	}
+
+	if cfg.AllowlistConfigFilePath == "" {
+		v.logger.Printf("No allowlist configured\n")
+	}
+	// An empty allowlist grants no bypasses, so there is nothing to verify.
+	if keyRing == nil || cfg.AllowlistConfigFilePath == "" {
+		allowlistYAML, err := LoadAllowlistYAML(cfg.AllowlistConfigFilePath)
+		return allowlistYAML, "", err
+	}
+	allowlistYAML, err := loadSignedAllowlistYAML(cfg.AllowlistConfigFilePath, keyRing, cfg.CryptoPolicy, v.logger)
+	return allowlistYAML, "", err
+}
+
+// cachedOutcome returns the trusted cached outcome of the commit, or nil if
+// there is none.
+func (v *Verifier) cachedOutcome(commit *object.Commit) *Outcome {
+	if v.notesCache == nil {
+		return nil
+	}
+	o, err := v.notesCache.Get(commit.Hash, v.now())
+	if err != nil {
+		v.logger.Printf("Not using the cached result of commit %s: %v\n\n", commit.Hash, err)
+		return nil
+	}
+	if o != nil {
+		v.logger.Printf("Using the cached result of commit %s: %s\n\n", commit.Hash, o.Desc)
+	}
+	return o
+}
+
+// cacheOutcome adds the outcome of a commit to the notes cache, if configured.
+// Failing to cache an outcome does not fail verification.
+func (v *Verifier) cacheOutcome(o *Outcome) {
+	if v.notesCache == nil {
+		return
+	}
+	if err := v.notesCache.Put(o, v.now()); err != nil {
+		v.logger.Printf("Failed to cache the result of commit %s: %v\n\n", o.Commit.CommitHash, err)
+	}
+}
+
+// flushNotesCache writes the cached outcomes to the notes ref. Failing to write
+// them is reported in o, but does not fail verification.
+func (v *Verifier) flushNotesCache(o *Outcome) {
+	if v.notesCache == nil {
+		return
+	}
+	n, err := v.notesCache.Flush(v.now())
+	if err != nil {
+		o.SetErrors(fmt.Errorf("failed to write the notes cache: %w", err))
+		return
+	}
+	if n > 0 {
+		v.logger.Printf("Cached the results of %d commits in %s\n\n", n, v.cfg.NotesCacheRef)
+	}
+}
+
+// getRepoAllowlist returns the allowlist for commits of the kind, and the name
+// of its section. Third party keys are looked up with ctx.
+func (v *Verifier) getRepoAllowlist(ctx context.Context, kind string) (string, *loadedRepoAllowlist) {
+	v.keySourceOnce.Do(func() {
+		client := v.httpClient
+		if client == nil {
+			client = &http.Client{Timeout: keyLookupTimeout}
+		}
+		v.keySource, v.keySourceErr = newKeySource(v.cfg, client, v.logger)
+	})
+
+	section, allowlist := v.allowlistYAML.AllowlistForKind(kind)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if l, ok := v.repoAllowlists[section]; ok {
+		return section, l
+	}
+
+	// Parse out valid allowlist email addresses and keys for the specified
+	// repository. Any parsing errors are added to the outcome, but do not fail
+	// verification.
+	l := &loadedRepoAllowlist{}
+	if v.keySourceErr != nil {
+		l.errs = append(l.errs, v.keySourceErr)
+	}
+	var errs []error
+	l.repoAllowlist, errs = GetAllowlistForRepoWithKeySource(ctx, allowlist, v.cfg.Repository, v.keySource)
+	l.errs = append(l.errs, errs...)
+
+	if len(l.repoAllowlist.PlatformKeys) > 0 {
+		var err error
+		l.platformKeyRings, err = LoadPlatformKeys(v.cfg.PlatformKeysDir, l.repoAllowlist.PlatformKeys)
+		if err != nil {
+			l.errs = append(l.errs, err)
+		}
+	}
+
+	v.repoAllowlists[section] = l
+	return section, l
+}
+
+// getAuthorizer returns the Authorizer given by WithAuthorizer or configured by
+// cfg, limited to MaxConcurrentAPIRequests requests at once.
+func (v *Verifier) getAuthorizer() (*limitedAuthorizer, error) {
+	v.authorizerOnce.Do(func() {
+		a := v.authorizerOption
+		if a == nil {
+			a, v.authorizerErr = v.newAuthorizer()
+		}
+		if v.authorizerErr == nil {
+			v.authorizer = newLimitedAuthorizer(a, v.cfg.MaxConcurrentAPIRequests)
+		}
+	})
+	return v.authorizer, v.authorizerErr
+}
+
+// newAuthorizer returns the offline snapshot if one is configured, or else an
+// APIClient for the Beyond Identity Key Management API.
+func (v *Verifier) newAuthorizer() (Authorizer, error) {
+	cfg := v.cfg
+	if cfg.OfflineSnapshotFile != "" {
+		keyRing, err := LoadKeyRingFile(cfg.OfflineSnapshotKeyRingFilePath)
+		if err != nil {
+			return nil, err
+		}
+		snapshot, err := LoadSnapshot(cfg.OfflineSnapshotFile, keyRing, cfg.CryptoPolicy, v.now(), cfg.OfflineSnapshotMaxAge)
+		if err != nil {
+			return nil, err
+		}
+		v.logger.Printf("Using offline snapshot issued at %s, expiring at %s\n\n", snapshot.IssuedAt.Format(time.RFC3339), snapshot.ExpiresAt.Format(time.RFC3339))
+		return snapshot, nil
+	}
+
+	httpClient := v.httpClient
+	if httpClient == nil {
+		var err error
+		httpClient, err = NewHTTPClient(cfg.Transport)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return APIClient{
+		HTTPClient:  httpClient,
+		TokenSource: cfg.TokenSource(httpClient),
+		APIBaseURL:  cfg.APIBaseURL,
+		Logger:      v.logger,
+	}, nil
+}
+
+// verification is a call to VerifyCommit or VerifyRange, with the
+// authorizations of its commits requested in advance.
+type verification struct {
+	*Verifier
+	authorizer       Authorizer
+	authorizerErr    error
+	breakGlassTokens *breakGlassTokens
+}
+
+// newVerification returns a verification of the commits, prefetching the
+// authorizations of the uncached commits if possible, and with the break-glass
+// tokens supplied for them.
+func (v *Verifier) newVerification(ctx context.Context, uncached, commits []*object.Commit) *verification {
+	vn := &verification{Verifier: v, breakGlassTokens: v.loadBreakGlassTokens(commits)}
+	authorizer, err := v.getAuthorizer()
+	if err != nil {
+		vn.authorizerErr = err
+		return vn
+	}
+	vn.authorizer = vn.prefetchAuthorizations(ctx, authorizer, uncached)
+	return vn
+}
+
+// prefetchAuthorizations requests the authorizations of the commits that are
+// not passed by the allowlist at once through the batch API, if the authorizer
+// supports it, and returns an Authorizer that answers from them. On failure,
+// the authorizations are requested one commit at a time instead.
+func (v *verification) prefetchAuthorizations(ctx context.Context, authorizer *limitedAuthorizer, commits []*object.Commit) Authorizer {
+	if _, ok := authorizer.Authorizer.(APIClient); !ok {
+		return authorizer
+	}
+
+	requests := []AuthorizationRequest{}
+	for _, commit := range commits {
+		if !v.needsAuthorization(ctx, commit) {
+			continue
+		}
+		issuer, err := ParseSignatureIssuer(commit.PGPSignature)
+		if err != nil {
+			continue
+		}
+		requests = append(requests, AuthorizationRequest{KeyID: issuer.KeyID, KeyFingerprint: issuer.Fingerprint, CommitterEmail: commit.Committer.Email})
+	}
+	if len(requests) < 2 {
+		return authorizer
+	}
+
+	v.logger.Printf("Prefetching %d authorizations\n\n", len(requests))
+	authorizations, err := authorizer.GetAuthorizations(ctx, requests)
+	if err != nil {
+		v.logger.Printf("Failed to prefetch authorizations, requesting them one commit at a time: %v\n\n", err)
+		return authorizer
+	}
+
+	prefetched := map[AuthorizationRequest]*Authorization{}
+	for i, r := range requests {
+		prefetched[r] = authorizations[i]
+	}
+	return prefetchedAuthorizer{Authorizations: prefetched, Authorizer: authorizer}
+}
+
+// needsAuthorization returns whether verifying the signed commit may reach the
+// authorizer, as it is not exempt, and not passed by the email addresses,
+// third party keys or platform keys of the allowlist.
+func (v *verification) needsAuthorization(ctx context.Context, commit *object.Commit) bool {
+	if commit.PGPSignature == "" {
+		return false
+	}
+	if exemption, err := v.exemption(commit); err != nil || exemption != "" {
+		return false
+	}
+
+	_, loaded := v.getRepoAllowlist(ctx, ClassifyCommit(commit))
+	repoAllowlist := loaded.repoAllowlist
+	if verifyCommitByEmailAddress(commit.Committer.Email, repoAllowlist.EmailAddresses) {
+		return false
+	}
+	payload, err := EncodedCommitWithoutSignature(commit)
+	if err != nil {
+		return false
+	}
+	// The keys are checked again when the commit is verified, which logs them.
+	discard := log.New(ioutil.Discard, "", 0)
+	if _, pass, _ := verifyCommitSignatureByThirdPartyKeys(repoAllowlist.ThirdPartyKeys, payload, commit, v.cfg.CryptoPolicy, discard); pass {
+		return false
+	}
+	if _, pass, _ := verifyCommitSignatureByPlatformKeys(loaded.platformKeyRings, repoAllowlist.PlatformKeys, repoAllowlist.PlatformCommitterEmails, payload, commit, v.cfg.CryptoPolicy, discard); pass {
+		return false
+	}
+	return true
+}
+
+// asSetupError returns err as a SetupError.
+func asSetupError(err error) *SetupError {
+	var setupErr *SetupError
+	if errors.As(err, &setupErr) {
+		return setupErr
+	}
+	return &SetupError{Desc: "Failed to verify. See errors for details.", Errs: []error{err}}
+}
@@ -0,0 +1,144 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestVerifier(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+	b64Jane := base64PublicKey(t, jane)
+	authorizer := fakeAuthorizerFunc(func(email, keyID string) *Authorization {
+		if email != "jane@doe.com" || keyID != formatPGPKeyID(jane.PrimaryKey.KeyId) {
+			return &Authorization{Authorized: false, Message: "key not authorized"}
+		}
+		return &Authorization{Authorized: true, GPGKey: GPGKey{ID: "key", Base64Key: b64Jane}}
+	})
+
+	r := newTestRepo(t)
+	base := r.commit(testCommitOptions{})
+	passed := r.commit(testCommitOptions{Signer: jane, Parents: []plumbing.Hash{base}})
+	bypassed := r.commit(testCommitOptions{Email: "bot@example.com", Parents: []plumbing.Hash{passed}})
+	failed := r.commit(testCommitOptions{Signer: mallory, Parents: []plumbing.Hash{bypassed}})
+
+	allowlist := &AllowlistYAML{NonMergeCommitAllowlist: Allowlist{
+		EmailAddresses: []EmailAddressEntry{{EmailAddress: "bot@example.com"}},
+	}}
+	now := time.Now().Add(time.Hour)
+	var logs bytes.Buffer
+	// The API settings are not required with WithAuthorizer.
+	v, err := NewVerifier(Config{RepoPath: r.Path, Repository: "byndid/auth-commit-sig"},
+		WithAuthorizer(authorizer),
+		WithAllowlist(allowlist),
+		WithLogger(log.New(&logs, "", 0)),
+		WithClock(func() time.Time { return now }),
+	)
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		res, err := v.VerifyCommit(context.Background(), passed.String())
+		if err != nil {
+			t.Fatalf("failed to verify commit: %v", err)
+		}
+		if !res.Passed() || res.Hash != passed || res.VerifiedBy != "BI_MANAGED_KEY" {
+			t.Errorf("unexpected result %s by %q for %s: %s", res.Result, res.VerifiedBy, res.Hash, res.Outcome.Desc)
+		}
+
+		res, err = v.VerifyCommit(context.Background(), failed.String())
+		if err != nil {
+			t.Fatalf("failed to verify commit: %v", err)
+		}
+		if res.Passed() || len(res.Errors) == 0 {
+			t.Errorf("expected FAIL with errors, got %s: %v", res.Result, res.Errors)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		res, err := v.VerifyRange(context.Background(), base.String(), failed.String())
+		if err != nil {
+			t.Fatalf("failed to verify range: %v", err)
+		}
+		if res.Passed() || len(res.Commits) != 3 {
+			t.Fatalf("expected FAIL with 3 commits, got %s with %d", res.Result, len(res.Commits))
+		}
+		if res.Commits[1].VerifiedBy != "EMAIL_ADDRESS" {
+			t.Errorf("expected commit %s to be verified by EMAIL_ADDRESS, got %q", bypassed, res.Commits[1].VerifiedBy)
+		}
+		if f := res.Failed(); len(f) != 1 || f[0].Hash != failed {
+			t.Errorf("expected only %s to fail, got %v", failed, f)
+		}
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		// Keys are checked against the clock, and jane's key does not exist yet.
+		v, err := NewVerifier(Config{RepoPath: r.Path, Repository: "byndid/auth-commit-sig"},
+			WithAuthorizer(authorizer),
+			WithAllowlist(allowlist),
+			WithLogger(log.New(&bytes.Buffer{}, "", 0)),
+			WithClock(func() time.Time { return jane.PrimaryKey.CreationTime.Add(-time.Hour) }),
+		)
+		if err != nil {
+			t.Fatalf("failed to create verifier: %v", err)
+		}
+		res, err := v.VerifyCommit(context.Background(), passed.String())
+		if err != nil {
+			t.Fatalf("failed to verify commit: %v", err)
+		}
+		if res.Passed() {
+			t.Errorf("expected FAIL for a key created after the clock, got %s", res.Result)
+		}
+	})
+
+	t.Run("unknown_ref", func(t *testing.T) {
+		_, err := v.VerifyCommit(context.Background(), "refs/heads/missing")
+		var setupErr *SetupError
+		if !errors.As(err, &setupErr) || setupErr.Desc != "Failed to get commit. See errors for details." {
+			t.Errorf("expected SetupError, got %v", err)
+		}
+	})
+
+	if !strings.Contains(logs.String(), "Verifying commit with ref") {
+		t.Errorf("expected logs to be written to the logger, got %q", logs.String())
+	}
+}
+
+func TestNewVerifierInvalidConfig(t *testing.T) {
+	_, err := NewVerifier(Config{})
+	var setupErr *SetupError
+	if !errors.As(err, &setupErr) || setupErr.Desc != "Invalid config. See errors for details." {
+		t.Errorf("expected SetupError for invalid config, got %v", err)
+	}
+}
+
+func TestNewVerifierCoAuthorsRequireIdentityDirectory(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit(testCommitOptions{})
+
+	authorizer := fakeAuthorizerFunc(func(email, keyID string) *Authorization { return nil })
+	_, err := NewVerifier(Config{RepoPath: r.Path, Repository: "byndid/auth-commit-sig", VerifyCoAuthors: true},
+		WithAuthorizer(authorizer),
+		WithAllowlist(&AllowlistYAML{}),
+	)
+	var setupErr *SetupError
+	if !errors.As(err, &setupErr) || len(setupErr.Errs) != 1 || setupErr.Errs[0] != errNoIdentityDirectory {
+		t.Errorf("expected SetupError for an authorizer without identities, got %v", err)
+	}
+}
+
+// fakeAuthorizerFunc authorizes keys with a function of the committer email
+// address and key ID.
+type fakeAuthorizerFunc func(email, keyID string) *Authorization
+
+func (f fakeAuthorizerFunc) AuthorizeKey(ctx context.Context, keyID, fingerprint, committerEmail string) (*Authorization, error) {
+	return f(committerEmail, keyID), nil
+}
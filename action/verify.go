@@ -1,22 +1,28 @@
 package action
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
-// Verify verifies a commit.
+// Verify verifies a commit with the default CryptoPolicy.
+//
+// Deprecated: Use VerifyWithPolicy.
 func Verify(commit *object.Commit, authorization *Authorization) error {
+	return VerifyWithPolicy(commit, authorization, CryptoPolicy{})
+}
+
+// VerifyWithPolicy verifies a commit.
+func VerifyWithPolicy(commit *object.Commit, authorization *Authorization, policy CryptoPolicy) error {
 	if !authorization.Authorized {
 		return fmt.Errorf("authorization denied: %s", authorization.Message)
 	}
 
-	err := VerifyCommitSignature(authorization.GPGKey.Base64Key, commit)
+	err := VerifyCommitSignatureWithPolicy(authorization.GPGKey.Base64Key, commit, policy)
 	if err != nil {
 		return fmt.Errorf("signature verification failed: %w", err)
 	}
@@ -25,15 +31,24 @@ func Verify(commit *object.Commit, authorization *Authorization) error {
 }
 
 // VerifyCommitSignature accepts a commit object and a base64-encoded PGP public
-// key. Parses the key into a temporary key ring, then checks that the signature
-// attached to the commit is valid.
+// key, and checks the signature attached to the commit with the default
+// CryptoPolicy.
+//
+// Deprecated: Use VerifyCommitSignatureWithPolicy.
 func VerifyCommitSignature(base64Key string, commit *object.Commit) error {
+	return VerifyCommitSignatureWithPolicy(base64Key, commit, CryptoPolicy{})
+}
+
+// VerifyCommitSignatureWithPolicy accepts a commit object and a base64-encoded
+// PGP public key. Parses the key into a temporary key ring, then checks that the
+// signature attached to the commit is valid and allowed by the policy.
+func VerifyCommitSignatureWithPolicy(base64Key string, commit *object.Commit, policy CryptoPolicy) error {
 	payload, err := EncodedCommitWithoutSignature(commit)
 	if err != nil {
 		return fmt.Errorf("failed to encode commit: %w", err)
 	}
 
-	err = CheckSignatureByKey(base64Key, commit.PGPSignature, payload)
+	err = CheckSignatureByKeyWithPolicy(base64Key, commit.PGPSignature, payload, policy)
 	if err != nil {
 		return fmt.Errorf("signature verification failed: %w", err)
 	}
@@ -54,23 +69,101 @@ func verifyCommitByEmailAddress(committerEmailAddress string, allowlistEmailAddr
 	return false
 }
 
+// findIssuerKeyIDCollisions returns the fingerprints of the distinct keys (primary
+// keys or subkeys) within the keyRings that have the issuer's key ID. More than one
+// fingerprint means the key ID alone does not identify the issuer.
+func findIssuerKeyIDCollisions(issuer *SignatureIssuer, keyRings []openpgp.EntityList) []string {
+	fps := []string{}
+	for _, keyRing := range keyRings {
+		for _, key := range keyRing.KeysById(issuer.keyID) {
+			fp := formatFingerprint(key.PublicKey.Fingerprint)
+			if !containsFingerprint(fp, fps) {
+				fps = append(fps, fp)
+			}
+		}
+	}
+	return fps
+}
+
 // verifyCommitSignatureByThirdPartyKeys accepts a commit object and a list of
 // keyRings. Returns true if the signature attached to the commit object is
-// validated by a key within the list; otherwise returns false.
-func verifyCommitSignatureByThirdPartyKeys(keyRings []openpgp.EntityList, payload string, commit *object.Commit) (*ThirdPartyKey, bool) {
-	for _, key := range keyRings {
-		signer, err := openpgp.CheckArmoredDetachedSignature(key, strings.NewReader(payload), strings.NewReader(commit.PGPSignature), nil)
-		if err == nil {
-			keyID := fmt.Sprintf("%X", signer.PrimaryKey.KeyId)
-			fp := base64.StdEncoding.EncodeToString(signer.PrimaryKey.Fingerprint)
-			userID := signer.PrimaryIdentity().Name
-			log.Printf("Signature made using RSA key %s\nand fingerprint %s\nfrom %s\n\n", keyID, fp, userID)
-			return &ThirdPartyKey{
-				KeyID:       keyID,
-				Fingerprint: fp,
-				UserID:      userID,
-			}, true
+// validated by a key within the list and allowed by the policy; otherwise
+// returns false and the errors of keys rejected by the policy.
+func verifyCommitSignatureByThirdPartyKeys(keyRings []ThirdPartyKeyRing, payload string, commit *object.Commit, policy CryptoPolicy, logger Logger) (*ThirdPartyKey, bool, []error) {
+	policyErrs := []error{}
+	for _, tpk := range keyRings {
+		signer, signingKey, err := checkArmoredDetachedSignature(tpk.KeyRing, payload, commit.PGPSignature, policy)
+		if err != nil {
+			if policyErr := cryptoPolicyViolation(err, logger); policyErr != nil {
+				policyErrs = append(policyErrs, fmt.Errorf("third party key: %w", policyErr))
+			}
+			continue
+		}
+
+		signingFP := formatFingerprint(signingKey.Fingerprint)
+		if tpk.SigningKeyFingerprint != "" && signingFP != tpk.SigningKeyFingerprint {
+			logger.Printf("Signature made using key %s, but only key %s is allowed to sign\n\n", signingFP, tpk.SigningKeyFingerprint)
+			continue
 		}
+
+		keyID := formatPGPKeyID(signer.PrimaryKey.KeyId)
+		fp := formatFingerprint(signer.PrimaryKey.Fingerprint)
+		userID := signer.PrimaryIdentity().Name
+		logger.Printf("Signature made using key %s\nwith fingerprint %s\nand signing key fingerprint %s\nfrom %s\n\n", keyID, fp, signingFP, userID)
+		return &ThirdPartyKey{
+			KeyID:              keyID,
+			Fingerprint:        fp,
+			SigningKeyID:       formatPGPKeyID(signingKey.KeyId),
+			SigningFingerprint: signingFP,
+			UserID:             userID,
+		}, true, nil
+	}
+	return nil, false, policyErrs
+}
+
+// verifyCommitSignatureByPlatformKeys accepts a commit object and the keyRings
+// of the platforms allowed to sign it. Non-merge commits are only considered
+// for a platform if committed by one of its committerEmails. Returns true if
+// the signature attached to the commit object is validated by a platform key
+// and allowed by the policy; otherwise returns false and the errors of keys
+// rejected by the policy.
+func verifyCommitSignatureByPlatformKeys(keyRings map[string]openpgp.EntityList, platforms []string, committerEmails map[string][]string, payload string, commit *object.Commit, policy CryptoPolicy, logger Logger) (*PlatformKey, bool, []error) {
+	policyErrs := []error{}
+	for _, platform := range platforms {
+		if len(commit.ParentHashes) < 2 && !verifyCommitByEmailAddress(commit.Committer.Email, committerEmails[platform]) {
+			logger.Printf("Committer email: \"%s\" is not a %s platform committer, not trusting the %s platform key for this non-merge commit\n\n", commit.Committer.Email, platform, platform)
+			continue
+		}
+		signer, _, err := checkArmoredDetachedSignature(keyRings[platform], payload, commit.PGPSignature, policy)
+		if err != nil {
+			if policyErr := cryptoPolicyViolation(err, logger); policyErr != nil {
+				policyErrs = append(policyErrs, fmt.Errorf("%s platform key: %w", platform, policyErr))
+			}
+			continue
+		}
+
+		keyID := formatPGPKeyID(signer.PrimaryKey.KeyId)
+		fp := formatFingerprint(signer.PrimaryKey.Fingerprint)
+		userID := signer.PrimaryIdentity().Name
+		logger.Printf("Signature made using %s platform key %s\nand fingerprint %s\nfrom %s\n\n", platform, keyID, fp, userID)
+		return &PlatformKey{
+			Platform:    platform,
+			KeyID:       keyID,
+			Fingerprint: fp,
+			UserID:      userID,
+		}, true, nil
+	}
+	return nil, false, policyErrs
+}
+
+// cryptoPolicyViolation logs and returns err if it is a CryptoPolicyError, so
+// that a key rejected by the policy is distinguishable from a key that did not
+// produce the signature. Returns nil otherwise.
+func cryptoPolicyViolation(err error, logger Logger) error {
+	var policyErr CryptoPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil
 	}
-	return nil, false
+	logger.Printf("Signature rejected: %v\n\n", policyErr)
+	return policyErr
 }
@@ -0,0 +1,235 @@
+package action
+
+import (
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// emptyArmoredKey is an armored public key block without keys.
+const emptyArmoredKey = "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n\n=twTO\n-----END PGP PUBLIC KEY BLOCK-----"
+
+func TestVerifyCommitSignatureByThirdPartyKeys(t *testing.T) {
+	entity := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	if err := entity.AddSigningSubkey(&packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}); err != nil {
+		t.Fatalf("failed to add signing subkey: %v", err)
+	}
+	primary := entity.PrimaryKey
+	subkey := entity.Subkeys[len(entity.Subkeys)-1].PublicKey
+
+	keySource := KeyRingKeySource{KeyRing: openpgp.EntityList{entity}}
+
+	tests := []struct {
+		name          string
+		signingKey    *packet.PublicKey
+		entry         ThirdPartyKeyEntry
+		expectedPass  bool
+		expectedError string
+	}{
+		{
+			name:         "primary_key",
+			signingKey:   primary,
+			entry:        ThirdPartyKeyEntry{Fingerprint: formatFingerprint(primary.Fingerprint)},
+			expectedPass: true,
+		},
+		{
+			name:         "any_subkey",
+			signingKey:   subkey,
+			entry:        ThirdPartyKeyEntry{Fingerprint: formatFingerprint(primary.Fingerprint)},
+			expectedPass: true,
+		},
+		{
+			name:         "pinned_subkey",
+			signingKey:   subkey,
+			entry:        ThirdPartyKeyEntry{Fingerprint: formatFingerprint(primary.Fingerprint), Subkey: formatFingerprint(subkey.Fingerprint)},
+			expectedPass: true,
+		},
+		{
+			name:       "other_than_pinned_subkey",
+			signingKey: primary,
+			entry:      ThirdPartyKeyEntry{Fingerprint: formatFingerprint(primary.Fingerprint), Subkey: formatFingerprint(subkey.Fingerprint)},
+		},
+		{
+			name:         "inline_key_pinned_subkey",
+			signingKey:   subkey,
+			entry:        ThirdPartyKeyEntry{Key: armorPublicKey(t, entity), Subkey: formatFingerprint(subkey.Fingerprint)},
+			expectedPass: true,
+		},
+		{
+			name:       "inline_key_other_than_pinned_subkey",
+			signingKey: primary,
+			entry:      ThirdPartyKeyEntry{Key: armorPublicKey(t, entity), Subkey: formatFingerprint(subkey.Fingerprint)},
+		},
+		{
+			name:          "inline_key_unknown_subkey",
+			signingKey:    primary,
+			entry:         ThirdPartyKeyEntry{Key: armorPublicKey(t, entity), Subkey: strings.Repeat("AB", 20)},
+			expectedError: "third party key " + formatFingerprint(primary.Fingerprint) + " has no subkey " + strings.Repeat("AB", 20),
+		},
+		{
+			name:          "inline_key_email_address",
+			signingKey:    primary,
+			entry:         ThirdPartyKeyEntry{Key: armorPublicKey(t, entity), EmailAddress: "jane@doe.com"},
+			expectedError: "third party key " + formatFingerprint(primary.Fingerprint) + " must not set email_address with an inline key",
+		},
+		{
+			name:          "inline_key_empty",
+			signingKey:    primary,
+			entry:         ThirdPartyKeyEntry{Key: emptyArmoredKey},
+			expectedError: "failed to parse third party key: " + emptyArmoredKey + "\n with error: no keys found",
+		},
+		{
+			name:          "unknown_fingerprint",
+			signingKey:    primary,
+			entry:         ThirdPartyKeyEntry{Fingerprint: strings.Repeat("AB", 20)},
+			expectedError: "failed to get third party key " + strings.Repeat("AB", 20) + ": no key with fingerprint " + strings.Repeat("AB", 20) + " in keyring",
+		},
+		{
+			name:          "invalid_fingerprint",
+			signingKey:    primary,
+			entry:         ThirdPartyKeyEntry{Fingerprint: "ABCD"},
+			expectedError: `invalid fingerprint format: "ABCD"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commit := newTestCommit(t, entity, tt.signingKey.KeyId)
+
+			repoAllowlist, errs := GetAllowlistForRepoWithKeySource(context.Background(), &Allowlist{ThirdPartyKeys: []ThirdPartyKeyEntry{tt.entry}}, "repo", keySource)
+			if tt.expectedError != "" {
+				if len(errs) != 1 {
+					t.Fatalf("expected 1 error, got %v", errs)
+				}
+				assertEqualErr(t, tt.expectedError, errs[0])
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+
+			payload, err := EncodedCommitWithoutSignature(commit)
+			if err != nil {
+				t.Fatalf("failed to encode commit: %v", err)
+			}
+
+			tpk, pass, _ := verifyCommitSignatureByThirdPartyKeys(repoAllowlist.ThirdPartyKeys, payload, commit, CryptoPolicy{}, log.Default())
+			if pass != tt.expectedPass {
+				t.Fatalf("expected pass %v, got %v", tt.expectedPass, pass)
+			}
+			if !pass {
+				return
+			}
+
+			if tpk.Fingerprint != formatFingerprint(primary.Fingerprint) {
+				t.Errorf("expected fingerprint %s, got %s", formatFingerprint(primary.Fingerprint), tpk.Fingerprint)
+			}
+			if tpk.KeyID != formatPGPKeyID(primary.KeyId) {
+				t.Errorf("expected key id %s, got %s", formatPGPKeyID(primary.KeyId), tpk.KeyID)
+			}
+			if tpk.SigningFingerprint != formatFingerprint(tt.signingKey.Fingerprint) {
+				t.Errorf("expected signing fingerprint %s, got %s", formatFingerprint(tt.signingKey.Fingerprint), tpk.SigningFingerprint)
+			}
+		})
+	}
+}
+
+// newTestCommit returns a commit signed by the key of the entity with keyID.
+func newTestCommit(t *testing.T, signer *openpgp.Entity, keyID uint64) *object.Commit {
+	t.Helper()
+
+	when := time.Date(2022, 9, 5, 13, 58, 12, 0, time.UTC)
+	commit := &object.Commit{
+		Author:       object.Signature{Name: "Jane Doe", Email: "jane@doe.com", When: when},
+		Committer:    object.Signature{Name: "Jane Doe", Email: "jane@doe.com", When: when},
+		Message:      "Add usage to README\n",
+		TreeHash:     plumbing.NewHash("3657f03df2a230f4c0f8682e515efd5bdc030cb9"),
+		ParentHashes: []plumbing.Hash{plumbing.NewHash("cf52d82d9ea21d5c9d174b21936aed4d01fbbd25")},
+	}
+	if signer != nil {
+		signTestCommit(t, commit, signer, keyID)
+	}
+	return commit
+}
+
+// signTestCommit signs the commit with the key of the entity with keyID.
+func signTestCommit(t *testing.T, commit *object.Commit, signer *openpgp.Entity, keyID uint64) {
+	t.Helper()
+
+	payload, err := EncodedCommitWithoutSignature(commit)
+	if err != nil {
+		t.Fatalf("failed to encode commit: %v", err)
+	}
+
+	sig := &strings.Builder{}
+	err = openpgp.ArmoredDetachSign(sig, signer, strings.NewReader(payload), &packet.Config{SigningKeyId: keyID})
+	if err != nil {
+		t.Fatalf("failed to sign commit: %v", err)
+	}
+	commit.PGPSignature = sig.String()
+}
+
+func TestVerifyCommitSignatureByPlatformKeys(t *testing.T) {
+	platform := newTestEntity(t, "GitHub", "noreply@github.com")
+	keyRings := map[string]openpgp.EntityList{PlatformGitHub: {platform}}
+	committerEmails := map[string][]string{PlatformGitHub: {"noreply@github.com"}}
+
+	tests := []struct {
+		name           string
+		committerEmail string
+		parents        int
+		expectedPass   bool
+	}{
+		{name: "web_edit", committerEmail: "noreply@github.com", parents: 1, expectedPass: true},
+		{name: "platform_committer_case_insensitive", committerEmail: "NoReply@GitHub.com", parents: 1, expectedPass: true},
+		{name: "other_committer", committerEmail: "mallory@example.com", parents: 1},
+		{name: "merge_commit_any_committer", committerEmail: "jane@doe.com", parents: 2, expectedPass: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commit := newTestCommit(t, nil, 0)
+			commit.Committer.Email = tt.committerEmail
+			for len(commit.ParentHashes) < tt.parents {
+				commit.ParentHashes = append(commit.ParentHashes, plumbing.NewHash("4d2f5ac3a9dd52e57c7d4cf4ba6d5d06bd2d0c9b"))
+			}
+			signTestCommit(t, commit, platform, platform.PrimaryKey.KeyId)
+			payload, err := EncodedCommitWithoutSignature(commit)
+			if err != nil {
+				t.Fatalf("failed to encode commit: %v", err)
+			}
+
+			pk, pass, _ := verifyCommitSignatureByPlatformKeys(keyRings, []string{PlatformGitHub}, committerEmails, payload, commit, CryptoPolicy{}, log.Default())
+			if pass != tt.expectedPass {
+				t.Fatalf("expected pass %v, got %v", tt.expectedPass, pass)
+			}
+			if pass && pk.Fingerprint != formatFingerprint(platform.PrimaryKey.Fingerprint) {
+				t.Errorf("expected fingerprint %s, got %s", formatFingerprint(platform.PrimaryKey.Fingerprint), pk.Fingerprint)
+			}
+		})
+	}
+}
+
+func TestFindIssuerKeyIDCollisions(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@doe.com")
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+	// Force a 64-bit key ID collision between two distinct keys.
+	mallory.PrimaryKey.KeyId = jane.PrimaryKey.KeyId
+
+	issuer := &SignatureIssuer{KeyID: formatPGPKeyID(jane.PrimaryKey.KeyId), keyID: jane.PrimaryKey.KeyId}
+
+	fps := findIssuerKeyIDCollisions(issuer, []openpgp.EntityList{{jane}, {jane}})
+	if len(fps) != 1 {
+		t.Errorf("expected the same key in two keyrings to not collide, got %v", fps)
+	}
+
+	fps = findIssuerKeyIDCollisions(issuer, []openpgp.EntityList{{jane}, {mallory}})
+	if len(fps) != 2 {
+		t.Errorf("expected collision between distinct keys, got %v", fps)
+	}
+}
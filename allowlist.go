@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"byndid/auth-commit-sig/action"
+)
+
+// allowlistCommand runs the "allowlist" subcommands and returns the exit code.
+func allowlistCommand(args []string) int {
+	if len(args) < 2 || args[0] != "lint" {
+		log.Printf("Usage: %s allowlist lint <allowlist-file>...", os.Args[0])
+		return 2
+	}
+
+	code := 0
+	for _, path := range args[1:] {
+		errs, warnings := action.LintAllowlistYAML(path)
+		for _, err := range errs {
+			log.Println(err)
+		}
+		for _, w := range warnings {
+			log.Printf("warning: %v", w)
+		}
+		if len(errs) > 0 {
+			code = 1
+		}
+	}
+	if code == 0 {
+		log.Printf("No problems found in %d allowlist files", len(args)-1)
+	}
+	return code
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"byndid/auth-commit-sig/action"
+)
+
+// auditCommand runs the "audit" subcommands and returns the exit code.
+func auditCommand(args []string) int {
+	usage := func() int {
+		log.Printf("Usage: %s audit verify [-anchor <hash>] <audit-log-file>", os.Args[0])
+		return 2
+	}
+	if len(args) == 0 || args[0] != "verify" {
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	anchor := fs.String("anchor", "", "Hash of the last record before the log, e.g. of a rotated log, that its first record must follow")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		return usage()
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Printf("Failed to open audit log: %v", err)
+		return 1
+	}
+	defer f.Close()
+
+	count, err := action.VerifyAuditLog(f, *anchor)
+	if err != nil {
+		log.Printf("Verified %d audit records before the chain broke: %v", count, err)
+		return 1
+	}
+
+	log.Printf("Verified %d audit records", count)
+	return 0
+}
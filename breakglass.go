@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"byndid/auth-commit-sig/action"
+)
+
+// breakGlassCommand runs the "break-glass" subcommands and returns the exit
+// code.
+func breakGlassCommand(args []string) int {
+	if len(args) == 0 || args[0] != "issue" {
+		log.Printf("Usage: %s break-glass issue -repository <owner/repo> -commit <hash> -justification <text> -signing-key <file> [-ttl <duration>]", os.Args[0])
+		return 2
+	}
+
+	fs := flag.NewFlagSet("break-glass issue", flag.ContinueOnError)
+	repository := fs.String("repository", "", "Repository the token is issued for, e.g. owner/repo")
+	commit := fs.String("commit", "", "Full hash of the commit whose verification the token overrides")
+	justification := fs.String("justification", "", "Why verification is overridden, e.g. the incident")
+	signingKeyPath := fs.String("signing-key", "", "Path to the ASCII-armored private admin key used to sign the token")
+	ttl := fs.Duration("ttl", time.Hour, "How long the token is valid")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *repository == "" || *commit == "" || *justification == "" || *signingKeyPath == "" {
+		log.Printf("All of -repository, -commit, -justification and -signing-key are required")
+		return 2
+	}
+
+	signer, err := action.LoadSigningKeyFile(*signingKeyPath, []byte(os.Getenv("BREAK_GLASS_SIGNING_KEY_PASSPHRASE")))
+	if err != nil {
+		log.Printf("Failed to load signing key: %v", err)
+		return 1
+	}
+
+	token := action.NewBreakGlassToken(*repository, *commit, *justification, time.Now(), *ttl)
+	encoded, err := action.EncodeBreakGlassToken(token, signer)
+	if err != nil {
+		log.Printf("Failed to issue break-glass token: %v", err)
+		return 1
+	}
+
+	log.Printf("Issued break-glass token for commit %s of %s, expiring at %s", token.CommitHash, token.Repository, token.ExpiresAt.Format(time.RFC3339))
+	fmt.Println(encoded)
+	return 0
+}
@@ -7,6 +7,9 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"byndid/auth-commit-sig/action"
 )
@@ -14,17 +17,92 @@ import (
 func main() {
 	log.SetFlags(0)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "snapshot":
+			os.Exit(snapshotCommand(os.Args[2:]))
+		case "audit":
+			os.Exit(auditCommand(os.Args[2:]))
+		case "allowlist":
+			os.Exit(allowlistCommand(os.Args[2:]))
+		case "break-glass":
+			os.Exit(breakGlassCommand(os.Args[2:]))
+		}
+	}
+
 	path := flag.String("path", ".", "Path to the git repository")
 	ref := flag.String("ref", "HEAD", "Commit reference to check")
+	base := flag.String("base", "", "Base reference of a range of commits to check, if any")
 	flag.Parse()
 
 	cfg := action.Config{
-		RepoPath:                *path,
-		CommitRef:               *ref,
-		APIToken:                getRequiredEnv("API_TOKEN"),
-		APIBaseURL:              getOptionalEnv("API_BASE_URL", "https://api.byndid.com/key-mgmt"),
-		Repository:              getRequiredEnv("REPOSITORY"),
-		AllowlistConfigFilePath: getOptionalEnv("ALLOWLIST_CONFIG_FILE_PATH", ""),
+		RepoPath:                        *path,
+		CommitRef:                       *ref,
+		BaseRef:                         *base,
+		Parallelism:                     getOptionalEnvInt("PARALLELISM", 0),
+		MaxConcurrentAPIRequests:        getOptionalEnvInt("MAX_CONCURRENT_API_REQUESTS", 0),
+		FailFast:                        getOptionalEnvBool("FAIL_FAST", false),
+		VerifyMergedCommits:             getOptionalEnvBool("VERIFY_MERGED_COMMITS", false),
+		VerifyCoAuthors:                 getOptionalEnvBool("VERIFY_CO_AUTHORS", false),
+		ShallowPolicy:                   getOptionalEnv("SHALLOW_POLICY", action.ShallowPolicyFail),
+		Enforcement:                     getOptionalEnv("ENFORCEMENT", action.EnforcementEnforce),
+		BaselineCommit:                  getOptionalEnv("BASELINE_COMMIT", ""),
+		Cutover:                         getOptionalEnv("CUTOVER", ""),
+		Repository:                      getOptionalEnv("REPOSITORY", ""),
+		AllowlistConfigFilePath:         getOptionalEnv("ALLOWLIST_CONFIG_FILE_PATH", ""),
+		AllowlistConfigSource:           getOptionalEnv("ALLOWLIST_CONFIG_SOURCE", ""),
+		AllowlistSigningKeyRingFilePath: getOptionalEnv("ALLOWLIST_SIGNING_KEYRING_FILE_PATH", ""),
+		AllowlistSigningKeyFingerprints: getOptionalEnvList("ALLOWLIST_SIGNING_KEY_FINGERPRINTS"),
+		ThirdPartyKeyRingFilePath:       getOptionalEnv("THIRD_PARTY_KEYRING_FILE_PATH", ""),
+		KeyserverURL:                    getOptionalEnv("KEYSERVER_URL", ""),
+		WKDLookup:                       getOptionalEnvBool("WKD_LOOKUP", false),
+		WKDBaseURL:                      getOptionalEnv("WKD_BASE_URL", ""),
+		KeyCacheDir:                     getOptionalEnv("KEY_CACHE_DIR", ""),
+		KeyCacheTTL:                     getOptionalEnvDuration("KEY_CACHE_TTL", 24*time.Hour),
+		PlatformKeysDir:                 getOptionalEnv("PLATFORM_KEYS_DIR", action.DefaultPlatformKeysDir),
+		OfflineSnapshotFile:             getOptionalEnv("OFFLINE_SNAPSHOT_FILE", ""),
+		OfflineSnapshotKeyRingFilePath:  getOptionalEnv("OFFLINE_SNAPSHOT_KEYRING_FILE_PATH", ""),
+		OfflineSnapshotMaxAge:           getOptionalEnvDuration("OFFLINE_SNAPSHOT_MAX_AGE", 0),
+		AuditLogFile:                    getOptionalEnv("AUDIT_LOG_FILE", ""),
+		AuditSyslogAddress:              getOptionalEnv("AUDIT_SYSLOG_ADDRESS", ""),
+		AuditHTTPURL:                    getOptionalEnv("AUDIT_HTTP_URL", ""),
+		AuditHTTPToken:                  getOptionalEnv("AUDIT_HTTP_TOKEN", ""),
+		AuditStateFile:                  getOptionalEnv("AUDIT_STATE_FILE", ""),
+		NotesCacheRef:                   getOptionalEnv("NOTES_CACHE_REF", ""),
+		NotesCacheSigningKeyFilePath:    getOptionalEnv("NOTES_CACHE_SIGNING_KEY_FILE_PATH", ""),
+		NotesCacheSigningKeyPassphrase:  getOptionalEnv("NOTES_CACHE_SIGNING_KEY_PASSPHRASE", ""),
+		NotesCacheKeyRingFilePath:       getOptionalEnv("NOTES_CACHE_KEYRING_FILE_PATH", ""),
+		NotesCacheMaxAge:                getOptionalEnvDuration("NOTES_CACHE_MAX_AGE", action.DefaultNotesCacheMaxAge),
+		BreakGlassToken:                 getOptionalEnv("BREAK_GLASS_TOKEN", ""),
+		BreakGlassKeyRingFilePath:       getOptionalEnv("BREAK_GLASS_KEYRING_FILE_PATH", ""),
+		BreakGlassKeyFingerprints:       getOptionalEnvList("BREAK_GLASS_KEY_FINGERPRINTS"),
+		BreakGlassMaxTTL:                getOptionalEnvDuration("BREAK_GLASS_MAX_TTL", action.DefaultBreakGlassMaxTTL),
+		CryptoPolicy: action.CryptoPolicy{
+			AllowedHashes:              getOptionalEnvList("CRYPTO_POLICY_ALLOWED_HASHES"),
+			AllowedPublicKeyAlgorithms: getOptionalEnvList("CRYPTO_POLICY_ALLOWED_PUBLIC_KEY_ALGORITHMS"),
+			MinRSABits:                 getOptionalEnvInt("CRYPTO_POLICY_MIN_RSA_BITS", 0),
+			AllowedCurves:              getOptionalEnvList("CRYPTO_POLICY_ALLOWED_CURVES"),
+			AllowV3Signatures:          getOptionalEnvBool("CRYPTO_POLICY_ALLOW_V3_SIGNATURES", false),
+		},
+	}
+	setAPIConfigFromEnv(&cfg)
+
+	// Derive the repository and commits from the CI event, if any.
+	if getOptionalEnvBool("AUTO_CONFIG", true) {
+		platform, event, err := loadCIEvent()
+		if err != nil {
+			log.Printf("Not configuring from the %s event: %v", platform, err)
+		} else if event != nil {
+			event.Apply(&cfg, getOptionalEnvBool("VERIFY_ALL_COMMITS", false))
+			log.Printf("Configured from the %s %s event: repository %q, ref %q, base ref %q", platform, event.Name, cfg.Repository, cfg.CommitRef, cfg.BaseRef)
+		}
+	}
+	if cfg.Repository == "" {
+		cfg.Repository = os.Getenv("GITHUB_REPOSITORY")
+	}
+	if cfg.Repository == "" {
+		log.Printf("Missing required environment variable: %q", "REPOSITORY")
+		os.Exit(2)
 	}
 
 	outcome := action.Run(context.Background(), cfg)
@@ -36,30 +114,131 @@ func main() {
 
 	log.Printf("Outcome JSON: \n%s", outcomeJSON)
 
+	writeCIReports(outcome)
+
 	// If result of action is FAIL, exit with error.
 	if outcome.Result == action.FAIL {
 		log.Println("Action failed. See outcome for additional details.")
 		os.Exit(1)
 	}
+	if outcome.Result == action.WARN {
+		log.Println("Action would have failed, but enforcement is report-only. See outcome for additional details.")
+		return
+	}
 
 	log.Println("Action succeeded. See outcome for additional details.")
 }
 
-func getRequiredEnv(name string) string {
+// loadCIEvent returns the event of the CI platform the action runs on, or nil
+// if it does not run on a supported platform.
+func loadCIEvent() (string, *action.CIEvent, error) {
+	switch {
+	case os.Getenv("GITHUB_EVENT_PATH") != "":
+		event, err := action.LoadGitHubEvent(os.Getenv("GITHUB_EVENT_NAME"), os.Getenv("GITHUB_EVENT_PATH"), os.Getenv("GITHUB_SHA"))
+		return "GitHub", event, err
+	case os.Getenv("GITLAB_CI") == "true":
+		event, err := action.LoadGitLabEvent(os.Getenv)
+		return "GitLab CI", event, err
+	case os.Getenv("BITBUCKET_BUILD_NUMBER") != "":
+		event, err := action.LoadBitbucketEvent(os.Getenv)
+		return "Bitbucket Pipelines", event, err
+	}
+	return "", nil, nil
+}
+
+// writeCIReports writes the outcome in the report formats of the CI
+// platforms that are configured. Failing to write a report does not change
+// the result of the action.
+func writeCIReports(outcome *action.Outcome) {
+	if path := getOptionalEnv("GITLAB_CODE_QUALITY_REPORT_FILE", ""); path != "" {
+		if err := action.WriteGitLabCodeQualityReport(path, outcome); err != nil {
+			log.Printf("Failed to write the GitLab code quality report: %v", err)
+		}
+	}
+	if path := getOptionalEnv("BITBUCKET_REPORT_FILE", ""); path != "" {
+		if err := action.WriteBitbucketReport(path, getOptionalEnv("BITBUCKET_ANNOTATIONS_FILE", ""), outcome); err != nil {
+			log.Printf("Failed to write the Bitbucket report: %v", err)
+		}
+	}
+}
+
+// setAPIConfigFromEnv sets the fields of cfg that configure access to the
+// Beyond Identity Key Management API.
+func setAPIConfigFromEnv(cfg *action.Config) {
+	cfg.APIToken = getOptionalEnv("API_TOKEN", "")
+	cfg.APITokenFile = getOptionalEnv("API_TOKEN_FILE", "")
+	cfg.OAuthTokenURL = getOptionalEnv("OAUTH_TOKEN_URL", "")
+	cfg.OAuthClientID = getOptionalEnv("OAUTH_CLIENT_ID", "")
+	cfg.OAuthClientSecret = getOptionalEnv("OAUTH_CLIENT_SECRET", "")
+	cfg.OAuthScopes = getOptionalEnvList("OAUTH_SCOPES")
+	cfg.APIBaseURL = getOptionalEnv("API_BASE_URL", "https://api.byndid.com/key-mgmt")
+	cfg.Transport = action.TransportConfig{
+		CACertFile:       getOptionalEnv("API_CA_CERT_FILE", ""),
+		ClientCertFile:   getOptionalEnv("API_CLIENT_CERT_FILE", ""),
+		ClientKeyFile:    getOptionalEnv("API_CLIENT_KEY_FILE", ""),
+		ProxyURL:         getOptionalEnv("API_PROXY_URL", ""),
+		MinTLSVersion:    getOptionalEnv("API_MIN_TLS_VERSION", ""),
+		PinnedCertSHA256: getOptionalEnvList("API_PINNED_CERT_SHA256"),
+	}
+}
+
+func getOptionalEnv(name string, defaultValue string) string {
 	value := os.Getenv(name)
 	if value == "" {
-		log.Printf("Missing required environment variable: %q", name)
-		os.Exit(2)
+		return defaultValue
 	}
 	return value
 }
 
-func getOptionalEnv(name string, defaultValue string) string {
+func getOptionalEnvBool(name string, defaultValue bool) bool {
 	value := os.Getenv(name)
 	if value == "" {
 		return defaultValue
 	}
-	return value
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean in environment variable %q: %v", name, err)
+		os.Exit(2)
+	}
+	return b
+}
+
+func getOptionalEnvInt(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer in environment variable %q: %v", name, err)
+		os.Exit(2)
+	}
+	return i
+}
+
+// getOptionalEnvList returns the comma separated values of the environment
+// variable, or nil if it is not set.
+func getOptionalEnvList(name string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func getOptionalEnvDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration in environment variable %q: %v", name, err)
+		os.Exit(2)
+	}
+	return d
 }
 
 func jsonMarshal(t interface{}) ([]byte, error) {
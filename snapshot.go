@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"byndid/auth-commit-sig/action"
+)
+
+// snapshotCommand runs the "snapshot" subcommands and returns the exit code.
+func snapshotCommand(args []string) int {
+	if len(args) == 0 || args[0] != "export" {
+		log.Printf("Usage: %s snapshot export -requests <file> -signing-key <file> [-ttl <duration>] [-out <file>]", os.Args[0])
+		return 2
+	}
+
+	fs := flag.NewFlagSet("snapshot export", flag.ContinueOnError)
+	requestsPath := fs.String("requests", "", "Path to a JSON file with the list of authorization requests to export")
+	signingKeyPath := fs.String("signing-key", "", "Path to the ASCII-armored private key used to sign the snapshot")
+	ttl := fs.Duration("ttl", 7*24*time.Hour, "How long the snapshot is valid")
+	out := fs.String("out", "snapshot.json", "Path of the snapshot file to write")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *requestsPath == "" || *signingKeyPath == "" {
+		log.Printf("Both -requests and -signing-key are required")
+		return 2
+	}
+
+	cfg := action.Config{}
+	setAPIConfigFromEnv(&cfg)
+
+	bs, err := ioutil.ReadFile(*requestsPath)
+	if err != nil {
+		log.Printf("Failed to read requests file: %v", err)
+		return 1
+	}
+	requests := []action.AuthorizationRequest{}
+	if err := json.Unmarshal(bs, &requests); err != nil {
+		log.Printf("Failed to parse requests file: %v", err)
+		return 1
+	}
+
+	signer, err := action.LoadSigningKeyFile(*signingKeyPath, []byte(os.Getenv("SNAPSHOT_SIGNING_KEY_PASSPHRASE")))
+	if err != nil {
+		log.Printf("Failed to load signing key: %v", err)
+		return 1
+	}
+
+	httpClient, err := action.NewHTTPClient(cfg.Transport)
+	if err != nil {
+		log.Printf("Failed to configure the API client: %v", err)
+		return 1
+	}
+	client := action.APIClient{
+		HTTPClient:  httpClient,
+		TokenSource: cfg.TokenSource(httpClient),
+		APIBaseURL:  cfg.APIBaseURL,
+	}
+
+	snapshot, err := action.NewSnapshot(context.Background(), client, requests, time.Now(), *ttl)
+	if err != nil {
+		log.Printf("Failed to export snapshot: %v", err)
+		return 1
+	}
+	signed, err := snapshot.Sign(signer)
+	if err != nil {
+		log.Printf("Failed to sign snapshot: %v", err)
+		return 1
+	}
+
+	signedJSON, err := jsonMarshal(signed)
+	if err != nil {
+		log.Printf("Failed to marshal snapshot: %v", err)
+		return 1
+	}
+	if err := ioutil.WriteFile(*out, signedJSON, 0o644); err != nil {
+		log.Printf("Failed to write snapshot: %v", err)
+		return 1
+	}
+
+	log.Printf("Exported %d of %d authorizations to %s, expiring at %s", len(snapshot.Entries), len(requests), *out, snapshot.ExpiresAt.Format(time.RFC3339))
+	return 0
+}